@@ -17,13 +17,23 @@
 package api
 
 import (
+	"github.com/trustedanalytics-ng/tap-ceph-broker/audit"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
 	commonLogger "github.com/trustedanalytics-ng/tap-go-common/logger"
-	"github.com/trustedanalytics-ng/tap-go-common/os"
 )
 
 var logger, _ = commonLogger.InitLogger("api")
 
+// SetLoggerLevel changes log level of api package
+func SetLoggerLevel(level string) error {
+	return commonLogger.SetLoggerLevel(logger, level)
+}
+
 // Context for ceph-broker main functionalities
 type Context struct {
-	OS os.OS
+	Storage    storage.Service
+	Config     config.Config
+	Audit      audit.Log
+	Operations *OperationTracker
 }
@@ -0,0 +1,175 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/audit"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// AuditMiddleware records every mutating request in the audit log
+func (c *Context) AuditMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if c.Audit == nil || req.Method == http.MethodGet {
+		next(rw, req)
+		return
+	}
+
+	startTime := time.Now()
+	entry := model.AuditEntry{
+		Time:          startTime.UTC(),
+		RequestID:     RequestIDFromRequest(req),
+		SourceAddress: req.RemoteAddr,
+		Operation:     req.Method + " " + req.RoutePath(),
+		ImageName:     req.PathParams["imageName"],
+		LockName:      req.PathParams["lockName"],
+		Locker:        req.PathParams["locker"],
+		Parameters:    readAuditParameters(req),
+	}
+	if principal, ok := PrincipalFromRequest(req); ok {
+		entry.Principal = principal.Name
+	}
+	if imageName, ok := entry.Parameters["imageName"].(string); ok && entry.ImageName == "" {
+		entry.ImageName = imageName
+	}
+
+	next(rw, req)
+
+	entry.DurationMs = int64(time.Since(startTime) / time.Millisecond)
+	entry.Status = rw.StatusCode()
+	entry.Outcome = model.AuditOutcomeSuccess
+	if entry.Status >= http.StatusBadRequest {
+		entry.Outcome = model.AuditOutcomeFailure
+	}
+	if err := c.Audit.Record(entry); err != nil {
+		newRequestLogger(entry.RequestID).Errorf("AuditMiddleware: cannot record entry %v: %v", entry, err)
+	}
+}
+
+// maxAuditedBodySize limits request body buffered to record its parameters, parameters of larger bodies are omitted
+const maxAuditedBodySize = 64 * 1024
+
+// readAuditParameters collects query and JSON body parameters, leaving request body intact for the handler
+func readAuditParameters(req *web.Request) map[string]interface{} {
+	log := newRequestLogger(RequestIDFromRequest(req))
+	parameters := map[string]interface{}{}
+	for key := range req.URL.Query() {
+		parameters[key] = req.URL.Query().Get(key)
+	}
+	if req.Body == nil {
+		return parameters
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxAuditedBodySize+1))
+	if err != nil {
+		log.Warningf("readAuditParameters: cannot read request body: %v", err)
+	}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	if len(body) > maxAuditedBodySize {
+		parameters["body"] = fmt.Sprintf("omitted, larger than %d bytes", maxAuditedBodySize)
+		return parameters
+	}
+
+	bodyParameters := map[string]interface{}{}
+	if len(body) > 0 && json.Unmarshal(body, &bodyParameters) == nil {
+		for key, value := range bodyParameters {
+			parameters[key] = value
+		}
+	}
+	if initialContent, ok := parameters["initialContent"].(map[string]interface{}); ok {
+		auditArchive(initialContent)
+	}
+	return parameters
+}
+
+// auditArchive replaces initial content archive with its size and SHA-256 digest, content of volumes is not audited
+func auditArchive(initialContent map[string]interface{}) {
+	encoded, ok := initialContent["archive"].(string)
+	if !ok {
+		return
+	}
+	delete(initialContent, "archive")
+	archive, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		initialContent["archiveSize"] = "invalid base64"
+		return
+	}
+	initialContent["archiveSize"] = len(archive)
+	initialContent["archiveSHA256"] = fmt.Sprintf("%x", sha256.Sum256(archive))
+}
+
+func parseAuditFilter(req *web.Request) (audit.Filter, error) {
+	filter := audit.Filter{Principal: req.URL.Query().Get("principal")}
+	var err error
+	if since := req.URL.Query().Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("invalid since parameter: %v", err)
+		}
+	}
+	if until := req.URL.Query().Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("invalid until parameter: %v", err)
+		}
+	}
+	return filter, nil
+}
+
+// ListAuditEntries returns audit log entries filtered by time and principal
+func (c *Context) ListAuditEntries(rw web.ResponseWriter, req *web.Request) {
+	if c.Audit == nil {
+		respondError(rw, req, http.StatusNotImplemented, errors.New("audit log is disabled"))
+		return
+	}
+
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	entries, err := c.Audit.Query(filter)
+	if err == audit.ErrQueryNotSupported {
+		respondError(rw, req, http.StatusNotImplemented, err)
+		return
+	}
+	if err != nil {
+		respond500(rw, req, err)
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, entries, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/audit"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestAuditLog(t *testing.T) {
+	Convey("Testing audit log", t, func() {
+		mockCtrl, c, mock, _ := prepareMocksAndClient(t)
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldBeNil)
+		auditLog, err := audit.NewFileLog(filepath.Join(dir, "audit.log"))
+		So(err, ShouldBeNil)
+		c.Audit = auditLog
+		router := SetupRouter(&c)
+
+		header := authorizedHeader()
+		sampleName := "sampleRBD"
+
+		listEntries := func(query string) []model.AuditEntry {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/audit"+query, nil, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			entries := []model.AuditEntry{}
+			So(json.Unmarshal(rr.Body.Bytes(), &entries), ShouldBeNil)
+			return entries
+		}
+
+		Convey("When RBD is deleted", func() {
+			mock.expectNotInUse(sampleName)
+			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "trash", "mv", sampleName, "--expires-at", gomock.Any()).Return("", nil)
+
+			rr := commonHttp.SendRequestWithHeaders("DELETE", "/api/v1/rbd/"+sampleName, nil, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusNoContent)
+
+			entries := listEntries("")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Principal, ShouldEqual, "user")
+			So(entries[0].Operation, ShouldEqual, "DELETE /api/v1/rbd/:imageName")
+			So(entries[0].ImageName, ShouldEqual, sampleName)
+			So(entries[0].Outcome, ShouldEqual, model.AuditOutcomeSuccess)
+			So(entries[0].Status, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("When RBD creation fails", func() {
+			mock.osMock.EXPECT().ExecuteCommand(rbdPath, "create", sampleName, "--size=100", "--image-feature=layering").Return("", fmt.Errorf("some error"))
+
+			body := commonHttp.PrepareAndValidateRequest(model.RBD{ImageName: sampleName, Size: 100, FileSystem: model.EXT4}, t)
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+
+			entries := listEntries("")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].ImageName, ShouldEqual, sampleName)
+			So(entries[0].Parameters["fileSystem"], ShouldEqual, model.EXT4)
+			So(entries[0].Outcome, ShouldEqual, model.AuditOutcomeFailure)
+		})
+
+		Convey("When initial content archive is given only its size and digest are audited", func() {
+			input := model.RBD{ImageName: sampleName, Size: 100, FileSystem: "unknown", InitialContent: &model.InitialContent{Archive: []byte("archive")}}
+			body := commonHttp.PrepareAndValidateRequest(input, t)
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+			entries := listEntries("")
+			So(entries, ShouldHaveLength, 1)
+			initialContent := entries[0].Parameters["initialContent"].(map[string]interface{})
+			So(initialContent, ShouldNotContainKey, "archive")
+			So(initialContent["archiveSize"], ShouldEqual, len("archive"))
+			So(initialContent["archiveSHA256"], ShouldEqual, fmt.Sprintf("%x", sha256.Sum256([]byte("archive"))))
+		})
+
+		Convey("When request body is too large to be audited", func() {
+			archive := make([]byte, maxAuditedBodySize)
+			input := model.RBD{ImageName: sampleName, Size: 100, FileSystem: "unknown", InitialContent: &model.InitialContent{Archive: archive}}
+			body := commonHttp.PrepareAndValidateRequest(input, t)
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, header, t)
+
+			// handler gets the whole body and rejects file system
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			So(rr.Body.String(), ShouldContainSubstring, "file system")
+			entries := listEntries("")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Parameters, ShouldNotContainKey, "initialContent")
+			So(entries[0].Parameters["body"], ShouldStartWith, "omitted")
+		})
+
+		Convey("When audited request body is close to the limit", func() {
+			// every "<" is escaped in the recorded entry, so it is several times longer than the body
+			label := strings.Repeat("<", maxAuditedBodySize-100)
+			body := []byte(`{"imageName":"` + sampleName + `","size":100,"fileSystem":"unknown","labels":{"note":"` + label + `"}}`)
+			So(len(body), ShouldBeLessThanOrEqualTo, maxAuditedBodySize)
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+
+			entries := listEntries("")
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].Parameters["labels"], ShouldResemble, map[string]interface{}{"note": label})
+		})
+
+		Convey("When entries are filtered by other principal", func() {
+			mock.expectNotInUse(sampleName)
+			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "trash", "mv", sampleName, "--expires-at", gomock.Any()).Return("", nil)
+			commonHttp.SendRequestWithHeaders("DELETE", "/api/v1/rbd/"+sampleName, nil, router, header, t)
+
+			So(listEntries("?principal=other"), ShouldBeEmpty)
+		})
+
+		Convey("When time filter is malformed", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/audit?since=yesterday", nil, router, header, t)
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Reset(func() {
+			mockCtrl.Finish()
+			auditLog.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
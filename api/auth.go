@@ -17,22 +17,136 @@
 package api
 
 import (
-	"os"
+	"context"
+	"crypto/x509"
+	"os/user"
+	"strconv"
 
 	"github.com/gocraft/web"
 
 	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
 )
 
-// BasicAuthorizeMiddleware authorizes user with credentials taken from envs
+const (
+	AuthMethodBasic       = "basic"
+	AuthMethodCertificate = "certificate"
+	AuthMethodUnixSocket  = "unix"
+)
+
+// Principal identifies the authenticated caller of a request
+type Principal struct {
+	Name       string `json:"name"`
+	AuthMethod string `json:"authMethod"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromRequest returns principal attached to the request by BasicAuthorizeMiddleware
+func PrincipalFromRequest(req *web.Request) (Principal, bool) {
+	principal, ok := req.Context().Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+func withPrincipal(req *web.Request, principal Principal) {
+	req.Request = req.Request.WithContext(context.WithValue(req.Context(), principalKey{}, principal))
+}
+
+// principalFromCertificate maps client certificate to principal name. SANs take precedence over subject CN.
+func principalFromCertificate(cert *x509.Certificate) string {
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	if len(cert.EmailAddresses) > 0 {
+		return cert.EmailAddresses[0]
+	}
+	if len(cert.URIs) > 0 {
+		return cert.URIs[0].String()
+	}
+	return cert.Subject.CommonName
+}
+
+func (c *Context) isCertPrincipalAllowed(name string) bool {
+	if len(c.Config.ClientCertPrincipals) == 0 {
+		return true
+	}
+	for _, principal := range c.Config.ClientCertPrincipals {
+		if principal == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin returns true if principal of the request is one of configured admins
+func (c *Context) isAdmin(req *web.Request) bool {
+	principal, ok := PrincipalFromRequest(req)
+	if !ok {
+		return false
+	}
+	for _, admin := range c.Config.Admins {
+		if admin == principal.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// certificatePrincipal returns principal for verified client certificate, if one was presented
+func (c *Context) certificatePrincipal(req *web.Request, log *requestLogger) (Principal, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return Principal{}, false
+	}
+	name := principalFromCertificate(req.TLS.VerifiedChains[0][0])
+	if name == "" || !c.isCertPrincipalAllowed(name) {
+		log.Infof("EnforceAuthMiddleware - Certificate: principal %q is not allowed", name)
+		return Principal{}, false
+	}
+	return Principal{Name: name, AuthMethod: AuthMethodCertificate}, true
+}
+
+// unixSocketPrincipal returns principal for local process connected over Unix socket. Access to the socket
+// is controlled by its file permissions.
+func unixSocketPrincipal(req *web.Request) (Principal, bool) {
+	credentials, ok := peerCredentialsFromContext(req.Context())
+	if !ok {
+		return Principal{}, false
+	}
+	return Principal{Name: peerPrincipalName(credentials), AuthMethod: AuthMethodUnixSocket}, true
+}
+
+// peerPrincipalName returns name of the user of local process, or its uid if the user is unknown
+func peerPrincipalName(credentials PeerCredentials) string {
+	name := strconv.FormatUint(uint64(credentials.UID), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	return name
+}
+
+// BasicAuthorizeMiddleware authorizes local process connected over Unix socket, user with verified client certificate
+// or configured credentials
 func (c *Context) BasicAuthorizeMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
-	logger.Info("Trying to access url ", req.URL.Path, " by BasicAuthorize")
+	log := newRequestLogger(RequestIDFromRequest(req))
+	log.Info("Trying to access url ", req.URL.Path, " by BasicAuthorize")
+	if principal, ok := unixSocketPrincipal(req); ok {
+		log.Info("EnforceAuthMiddleware - Unix socket: User authenticated as ", principal.Name)
+		withPrincipal(req, principal)
+		next(rw, req)
+		return
+	}
+	if principal, ok := c.certificatePrincipal(req, log); ok {
+		log.Info("EnforceAuthMiddleware - Certificate: User authenticated as ", principal.Name)
+		withPrincipal(req, principal)
+		next(rw, req)
+		return
+	}
 	username, password, isOK := req.BasicAuth()
-	if !isOK || username != os.Getenv("CEPH_BROKER_USER") || password != os.Getenv("CEPH_BROKER_PASS") {
-		logger.Info("EnforceAuthMiddleware - BasicAuth: Invalid Basic Auth credentials")
+	if !isOK || c.Config.User == "" || username != c.Config.User || password != c.Config.Password {
+		log.Info("EnforceAuthMiddleware - BasicAuth: Invalid Basic Auth credentials")
 		commonHttp.RespondUnauthorized(rw)
 		return
 	}
-	logger.Info("EnforceAuthMiddleware - BasicAuth: User authenticated as ", username)
+	log.Info("EnforceAuthMiddleware - BasicAuth: User authenticated as ", username)
+	withPrincipal(req, Principal{Name: username, AuthMethod: AuthMethodBasic})
 	next(rw, req)
 }
@@ -0,0 +1,93 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gocraft/web"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPrincipalFromCertificate(t *testing.T) {
+	sampleURI, _ := url.Parse("spiffe://cluster.local/node-agent")
+	testCases := []struct {
+		cert   x509.Certificate
+		output string
+	}{
+		{x509.Certificate{Subject: pkix.Name{CommonName: "agent"}}, "agent"},
+		{x509.Certificate{Subject: pkix.Name{CommonName: "agent"}, DNSNames: []string{"worker-1.cluster.local"}}, "worker-1.cluster.local"},
+		{x509.Certificate{Subject: pkix.Name{CommonName: "agent"}, EmailAddresses: []string{"ops@example.com"}}, "ops@example.com"},
+		{x509.Certificate{URIs: []*url.URL{sampleURI}}, "spiffe://cluster.local/node-agent"},
+		{x509.Certificate{}, ""},
+	}
+
+	for _, tc := range testCases {
+		output := principalFromCertificate(&tc.cert)
+		if output != tc.output {
+			t.Errorf("principalFromCertificate(%v) = %q; want %q", tc.cert.Subject, output, tc.output)
+		}
+	}
+}
+
+func TestCertificateAuthorization(t *testing.T) {
+	Convey("Testing BasicAuthorizeMiddleware with client certificate", t, func() {
+		c := &Context{}
+		router := web.New(*c)
+		router.Middleware(c.BasicAuthorizeMiddleware)
+		var principal Principal
+		router.Get("/", func(rw web.ResponseWriter, req *web.Request) {
+			principal, _ = PrincipalFromRequest(req)
+		})
+
+		request := func(cert *x509.Certificate) int {
+			req := httptest.NewRequest("GET", "/", nil)
+			if cert != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr.Code
+		}
+
+		Convey("When verified certificate is presented", func() {
+			status := request(&x509.Certificate{Subject: pkix.Name{CommonName: "agent"}})
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(principal, ShouldResemble, Principal{Name: "agent", AuthMethod: AuthMethodCertificate})
+		})
+
+		Convey("When certificate principal is not on allowed list", func() {
+			c.Config.ClientCertPrincipals = []string{"other-agent", "another-agent"}
+			status := request(&x509.Certificate{Subject: pkix.Name{CommonName: "agent"}})
+
+			So(status, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("When neither certificate nor credentials are presented", func() {
+			status := request(nil)
+
+			So(status, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestCapacityCheck(t *testing.T) {
+	Convey("Testing pool capacity check", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		service := c.Storage.(*storage.RBDService)
+		service.Pool = fake.Pool
+		service.OvercommitRatio = 1.5
+		backend.SetPoolCapacity(1000)
+		backend.AddImage("existing", 1000, model.EXT4)
+		backend.SetUsed("existing", 400)
+
+		create := func(size uint64) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(model.RBD{ImageName: "new", Size: size, FileSystem: model.EXT4})
+			return commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, authorizedHeader(), t)
+		}
+
+		Convey("When image fits in overcommitted pool", func() {
+			rr := create(500)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			_, ok := backend.Image("new")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When image exceeds overcommitted pool", func() {
+			rr := create(501)
+
+			So(rr.Code, ShouldEqual, http.StatusInsufficientStorage)
+			response := model.InsufficientCapacityResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+			So(response.Pool, ShouldEqual, fake.Pool)
+			So(response.CapacityBytes, ShouldEqual, 1000*mebibyte)
+			So(response.OvercommitRatio, ShouldEqual, 1.5)
+			So(response.ProvisionedBytes, ShouldEqual, 1000*mebibyte)
+			So(response.AvailableBytes, ShouldEqual, 500*mebibyte)
+			So(response.RequestedBytes, ShouldEqual, 501*mebibyte)
+			So(response.Message, ShouldContainSubstring, "available")
+			_, ok := backend.Image("new")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When image is in trash its size stays provisioned", func() {
+			rr := commonHttp.SendRequestWithHeaders("DELETE", "/api/v1/rbd/existing", nil, router, authorizedHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusNoContent)
+
+			rr = create(501)
+
+			So(rr.Code, ShouldEqual, http.StatusInsufficientStorage)
+			response := model.InsufficientCapacityResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+			So(response.ProvisionedBytes, ShouldEqual, 1000*mebibyte)
+			So(create(500).Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("When check is disabled", func() {
+			service.OvercommitRatio = 0
+
+			rr := create(5000)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("When OSB instance exceeds pool", func() {
+			backend.SetPoolCapacity(100)
+			body := osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: osbPlans[0].ID})
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", "/v2/service_instances/instance-1", body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusInsufficientStorage)
+		})
+
+		Convey("When CSI volume is expanded", func() {
+			controller := NewCSIController(&c)
+			expand := func(size int64) error {
+				_, err := controller.ControllerExpandVolume(context.Background(), &model.ControllerExpandVolumeRequest{
+					VolumeID: "existing", CapacityRange: &model.CapacityRange{RequiredBytes: size * mebibyte}})
+				return err
+			}
+
+			So(CSIErrorCode(expand(1501)), ShouldEqual, model.CSICodeResourceExhausted)
+			So(expand(1500), ShouldBeNil)
+			image, _ := backend.Image("existing")
+			So(image.Size, ShouldEqual, 1500)
+		})
+	})
+}
@@ -0,0 +1,295 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// copyProgressInterval is period in which usage of copied image is checked to report progress
+var copyProgressInterval = time.Second
+
+// RenameRBD renames RBD which is not in use, see startImageOperation
+func (c *Context) RenameRBD(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	input := model.RenameRequest{}
+	if err := commonHttp.ReadJson(req, &input); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	if err := storage.ValidateDestinationName(input.NewName); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	operation := model.ImageOperation{Type: model.ImageOperationRename, ImageName: name, DestinationName: input.NewName}
+	c.startImageOperation(rw, req, operation, func(ctx context.Context) error {
+		return c.Storage.RenameImage(ctx, name, input.NewName)
+	})
+}
+
+// CopyRBD copies RBD which is not in use, optionally with its snapshots and to another pool, see startImageOperation.
+// Copy is owned by the caller.
+func (c *Context) CopyRBD(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	input := model.CopyRequest{}
+	if err := commonHttp.ReadJson(req, &input); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	if err := storage.ValidateDestinationName(input.DestinationName); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	if input.DestinationPool == c.Config.CephPool {
+		input.DestinationPool = ""
+	}
+	if input.DestinationPool != "" {
+		if err := storage.ValidatePoolName(input.DestinationPool); err != nil {
+			respond400(rw, req, err)
+			return
+		}
+	}
+
+	operation := model.ImageOperation{
+		Type:            model.ImageOperationCopy,
+		ImageName:       name,
+		DestinationName: input.DestinationName,
+		DestinationPool: input.DestinationPool,
+		Deep:            input.Deep,
+	}
+	principal, _ := PrincipalFromRequest(req)
+	c.startImageOperation(rw, req, operation, func(ctx context.Context) error {
+		destination := storage.ImageSpec(input.DestinationPool, input.DestinationName)
+		if err := c.Storage.CopyImage(ctx, name, input.DestinationPool, input.DestinationName, input.Deep); err != nil {
+			return err
+		}
+		if principal.Name == "" {
+			return nil
+		}
+		return c.Storage.SetImageOwner(ctx, destination, principal.Name)
+	})
+}
+
+// GetImageOperation returns state of rename or copy started by the broker within last hour
+func (c *Context) GetImageOperation(rw web.ResponseWriter, req *web.Request) {
+	id := req.PathParams["operationID"]
+	operation, ok := c.Operations.getImageOperation(id)
+	if !ok {
+		respond404(rw, req, fmt.Errorf("operation %q not found", id))
+		return
+	}
+
+	if err := commonHttp.WriteJson(rw, operation, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
+
+// checkOwner allows operation on RBD to its owner and admins; RBD without owner can be used by any caller
+func (c *Context) checkOwner(ctx context.Context, req *web.Request, name string) (int, error) {
+	metadata, err := c.Storage.ImageMetadata(ctx, name)
+	if err == storage.ErrNotFound {
+		return http.StatusNotFound, fmt.Errorf("RBD %q not found", name)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("cannot get owner of RBD %q: %v", name, err)
+	}
+	if principal, _ := PrincipalFromRequest(req); metadata.Owner != "" && principal.Name != metadata.Owner && !c.isAdmin(req) {
+		return http.StatusForbidden, fmt.Errorf("RBD %q is owned by %q", name, metadata.Owner)
+	}
+	return http.StatusOK, nil
+}
+
+// startImageOperation runs rename or copy of RBD owned by the caller, unless caller is admin, and not used
+// by any client or other operation of the broker. Source and destination are serialized with other operations
+// until it ends. With "async=true" query parameter operation state is returned with status 202 and can be
+// polled under Location; otherwise resulting RBD is returned when operation ends.
+func (c *Context) startImageOperation(rw web.ResponseWriter, req *web.Request, operation model.ImageOperation,
+	run func(ctx context.Context) error) {
+	ctx := requestContext(req)
+	operation.ID = model.NewRequestID()
+	destination := storage.ImageSpec(operation.DestinationPool, operation.DestinationName)
+	// images are reserved before the checks, so that no other operation of the broker starts using them in between
+	unlock, err := c.Operations.lockImages(operation.Type+" operation "+operation.ID, operation.ImageName, destination)
+	if err != nil {
+		respondError(rw, req, http.StatusConflict, err)
+		return
+	}
+	if code, err := c.checkOwner(ctx, req, operation.ImageName); err != nil {
+		unlock()
+		respondError(rw, req, code, err)
+		return
+	}
+	err = c.Storage.CheckNotInUse(ctx, operation.ImageName)
+	if busyErr, ok := err.(*storage.BusyError); ok {
+		unlock()
+		respondBusy(rw, req, busyErr)
+		return
+	}
+	if err != nil {
+		unlock()
+		respond500(rw, req, fmt.Errorf("cannot %s RBD: %v", operation.Type, err))
+		return
+	}
+	operation.State = model.OperationInProgress
+	operation.StartedAt = time.Now().UTC()
+	c.Operations.setImageOperation(operation)
+
+	if req.URL.Query().Get("async") == "true" {
+		c.Operations.begin()
+		log := newRequestLogger(RequestIDFromRequest(req))
+		go func() {
+			defer c.Operations.end()
+			defer unlock()
+			// request context is cancelled when the response is sent
+			if _, err := c.runImageOperation(storage.WithLogger(context.Background(), log), operation, run); err != nil {
+				log.Errorf("%s of RBD %q FAILED: %v", operation.Type, operation.ImageName, err)
+			}
+		}()
+		rw.Header().Set("Location", "/api/v1/operations/"+operation.ID)
+		commonHttp.WriteJson(rw, operation, http.StatusAccepted)
+		return
+	}
+
+	defer unlock()
+	if operation, err = c.runImageOperation(ctx, operation, run); err != nil {
+		errNew := fmt.Errorf("cannot %s RBD: %v", operation.Type, err)
+		if capacityErr, ok := err.(*storage.CapacityError); ok {
+			respondInsufficientCapacity(rw, req, capacityErr)
+			return
+		}
+		switch err {
+		case storage.ErrNotFound:
+			respond404(rw, req, errNew)
+		case storage.ErrImageExists:
+			respondError(rw, req, http.StatusConflict, errNew)
+		default:
+			respond500(rw, req, errNew)
+		}
+		return
+	}
+
+	info, err := c.Storage.ImageInfo(ctx, destination)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get RBD: %v", err))
+		return
+	}
+	labels, err := c.Storage.ImageLabels(ctx, destination)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get labels of RBD: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, model.RBD{ImageName: operation.DestinationName, Size: info.Size / mebibyte, Labels: labels}, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
+
+// runImageOperation runs operation recording its progress and final state, which is returned with its error
+func (c *Context) runImageOperation(ctx context.Context, operation model.ImageOperation,
+	run func(ctx context.Context) error) (model.ImageOperation, error) {
+	done := make(chan struct{})
+	polling := sync.WaitGroup{}
+	if operation.Type == model.ImageOperationCopy {
+		polling.Add(1)
+		go func() {
+			defer polling.Done()
+			c.pollCopyProgress(ctx, operation, done)
+		}()
+	}
+	err := run(ctx)
+	close(done)
+	polling.Wait()
+
+	finishedAt := time.Now().UTC()
+	operation.FinishedAt = &finishedAt
+	if err != nil {
+		operation.State = model.OperationFailed
+		operation.Description = err.Error()
+	} else {
+		operation.State = model.OperationSucceeded
+		operation.Progress = 100
+	}
+	c.Operations.setImageOperation(operation)
+	return operation, err
+}
+
+// pollCopyProgress compares space used by the copy with space used by the source, until done is closed
+func (c *Context) pollCopyProgress(ctx context.Context, operation model.ImageOperation, done <-chan struct{}) {
+	total, err := copiedBytes(ctx, c.Storage, operation.ImageName, operation.Deep)
+	if err != nil || total == 0 {
+		return
+	}
+	destination := storage.ImageSpec(operation.DestinationPool, operation.DestinationName)
+	ticker := time.NewTicker(copyProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		copied, err := copiedBytes(ctx, c.Storage, destination, operation.Deep)
+		if err != nil {
+			// copy is not created yet
+			continue
+		}
+		// operation is finished when the command exits
+		operation.Progress = int(copied * 100 / total)
+		if operation.Progress > 99 {
+			operation.Progress = 99
+		}
+		c.Operations.setImageOperation(operation)
+	}
+}
+
+func copiedBytes(ctx context.Context, service storage.Service, image string, deep bool) (uint64, error) {
+	report, err := service.DiskUsage(ctx, image)
+	if err != nil {
+		return 0, err
+	}
+	if deep {
+		return report.TotalUsedBytes, nil
+	}
+	if len(report.Images) == 0 {
+		return 0, errors.New("image usage not reported")
+	}
+	return report.Images[0].UsedBytes, nil
+}
@@ -0,0 +1,225 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestRenameAndCopyRBD(t *testing.T) {
+	Convey("Testing RBD rename and copy", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		sampleName := "sampleRBD"
+		backend.AddImage(sampleName, 100, model.EXT4)
+		backend.SetUsed(sampleName, 40)
+		_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", sampleName, "label.env", "prod")
+		So(err, ShouldBeNil)
+
+		send := func(method, path string, input interface{}) *httptest.ResponseRecorder {
+			body := []byte{}
+			if input != nil {
+				body, err = json.Marshal(input)
+				So(err, ShouldBeNil)
+			}
+			return commonHttp.SendRequestWithHeaders(method, path, body, router, authorizedHeader(), t)
+		}
+		rbdOf := func(rr *httptest.ResponseRecorder) model.RBD {
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			rbd := model.RBD{}
+			So(json.Unmarshal(rr.Body.Bytes(), &rbd), ShouldBeNil)
+			return rbd
+		}
+
+		Convey("When RBD is renamed", func() {
+			rbd := rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "renamed"}))
+
+			So(rbd, ShouldResemble, model.RBD{ImageName: "renamed", Size: 100, Labels: map[string]string{"env": "prod"}})
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeFalse)
+			_, ok = backend.Image("renamed")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When RBD is renamed to name of existing RBD", func() {
+			backend.AddImage("other", 10, "")
+
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "other"})
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When RBD does not exist", func() {
+			rr := send("POST", "/api/v1/rbd/missing/rename", model.RenameRequest{NewName: "renamed"})
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When new name refers to another pool", func() {
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "other/renamed"})
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When RBD is copied", func() {
+			_, err := backend.ExecuteCommand(rbdPath, "snap", "create", sampleName+"@backup")
+			So(err, ShouldBeNil)
+
+			rbd := rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/copy", model.CopyRequest{DestinationName: "copied"}))
+
+			So(rbd, ShouldResemble, model.RBD{ImageName: "copied", Size: 100, Labels: map[string]string{"env": "prod"}})
+			image, ok := backend.Image("copied")
+			So(ok, ShouldBeTrue)
+			So(image.FileSystem, ShouldEqual, model.EXT4)
+			So(image.Snapshots, ShouldBeEmpty)
+			So(image.Meta["owner"], ShouldEqual, testUser)
+			_, ok = backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+
+			Convey("Deep copy includes snapshots", func() {
+				rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/copy", model.CopyRequest{DestinationName: "deep", Deep: true}))
+
+				image, _ := backend.Image("deep")
+				So(image.Snapshots, ShouldHaveLength, 1)
+				So(image.Snapshots[0].Name, ShouldEqual, "backup")
+				So(backend.Commands(), ShouldContain, "rbd deep cp sampleRBD deep")
+			})
+		})
+
+		Convey("When RBD is copied to another pool", func() {
+			rbd := rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/copy", model.CopyRequest{DestinationName: "moved", DestinationPool: "archive"}))
+
+			So(rbd.ImageName, ShouldEqual, "moved")
+			So(backend.Commands(), ShouldContain, "rbd cp sampleRBD moved --dest-pool archive")
+			_, ok := backend.Image("archive/moved")
+			So(ok, ShouldBeTrue)
+			_, ok = backend.Image("moved")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When copy exceeds pool capacity", func() {
+			service := c.Storage.(*storage.RBDService)
+			service.Pool = fake.Pool
+			service.OvercommitRatio = 1
+			backend.SetPoolCapacity(150)
+
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/copy", model.CopyRequest{DestinationName: "copied"})
+
+			So(rr.Code, ShouldEqual, http.StatusInsufficientStorage)
+			_, ok := backend.Image("copied")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When RBD is owned by another user", func() {
+			_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", sampleName, "owner", "alice")
+			So(err, ShouldBeNil)
+
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "renamed"})
+
+			So(rr.Code, ShouldEqual, http.StatusForbidden)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+			unlock, err := c.Operations.lockImages("test operation", sampleName, storage.ImageSpec("", "renamed"))
+			So(err, ShouldBeNil)
+			unlock()
+
+			Convey("Admin can rename it", func() {
+				c.Config.Admins = []string{testUser}
+				router = SetupRouter(&c)
+
+				rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "renamed"}))
+
+				image, _ := backend.Image("renamed")
+				So(image.Meta["owner"], ShouldEqual, "alice")
+			})
+		})
+
+		Convey("When RBD is mapped", func() {
+			backend.MapImage(sampleName)
+
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/copy", model.CopyRequest{DestinationName: "copied"})
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+			_, ok := backend.Image("copied")
+			So(ok, ShouldBeFalse)
+			unlock, err := c.Operations.lockImages("test operation", sampleName, storage.ImageSpec("", "copied"))
+			So(err, ShouldBeNil)
+			unlock()
+		})
+
+		Convey("When RBD is used by another operation", func() {
+			unlock, err := c.Operations.lockImages("test operation", sampleName)
+			So(err, ShouldBeNil)
+
+			So(send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "renamed"}).Code, ShouldEqual, http.StatusConflict)
+			So(send("DELETE", "/api/v1/rbd/"+sampleName, nil).Code, ShouldEqual, http.StatusConflict)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+
+			Convey("It can be renamed after the operation ends", func() {
+				unlock()
+
+				rbdOf(send("POST", "/api/v1/rbd/"+sampleName+"/rename", model.RenameRequest{NewName: "renamed"}))
+			})
+		})
+
+		Convey("When RBD is copied asynchronously", func() {
+			defer func(interval time.Duration) { copyProgressInterval = interval }(copyProgressInterval)
+			copyProgressInterval = time.Millisecond
+			rr := send("POST", "/api/v1/rbd/"+sampleName+"/copy?async=true", model.CopyRequest{DestinationName: "copied", Deep: true})
+
+			So(rr.Code, ShouldEqual, http.StatusAccepted)
+			operation := model.ImageOperation{}
+			So(json.Unmarshal(rr.Body.Bytes(), &operation), ShouldBeNil)
+			So(operation.Type, ShouldEqual, model.ImageOperationCopy)
+			So(operation.State, ShouldEqual, model.OperationInProgress)
+			location := rr.Header().Get("Location")
+			So(location, ShouldEqual, "/api/v1/operations/"+operation.ID)
+
+			for i := 0; i < 100 && operation.State == model.OperationInProgress; i++ {
+				time.Sleep(10 * time.Millisecond)
+				rr = send("GET", location, nil)
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				So(json.Unmarshal(rr.Body.Bytes(), &operation), ShouldBeNil)
+			}
+
+			So(operation.State, ShouldEqual, model.OperationSucceeded)
+			So(operation.Progress, ShouldEqual, 100)
+			So(operation.FinishedAt, ShouldNotBeNil)
+			image, ok := backend.Image("copied")
+			So(ok, ShouldBeTrue)
+			So(image.Used, ShouldEqual, 40)
+		})
+
+		Convey("When operation does not exist", func() {
+			So(send("GET", "/api/v1/operations/unknown", nil).Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
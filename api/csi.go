@@ -0,0 +1,405 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+)
+
+const (
+	mebibyte             = 1024 * 1024
+	defaultCSIVolumeSize = 1024 // MB
+	rbdTimestampLayout   = "Mon Jan _2 15:04:05 2006"
+)
+
+// CSIController implements CSI Controller service on top of storage service of the Context.
+// Methods follow csi.proto semantics: they are idempotent and fail with *model.CSIError carrying gRPC status code,
+// so that gRPC server generated from CSI spec can delegate to them directly.
+type CSIController struct {
+	context Context
+}
+
+// NewCSIController returns CSI Controller service using storage service, configuration and operation tracker of the Context
+func NewCSIController(c *Context) *CSIController {
+	return &CSIController{context: *c}
+}
+
+func csiError(code model.CSICode, format string, a ...interface{}) error {
+	return &model.CSIError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CSIErrorCode returns gRPC status code of error returned by CSIController
+func CSIErrorCode(err error) model.CSICode {
+	if err == nil {
+		return model.CSICodeOK
+	}
+	if csiErr, ok := err.(*model.CSIError); ok {
+		return csiErr.Code
+	}
+	return model.CSICodeInternal
+}
+
+func validateCSIName(name string) error {
+	if name == "" {
+		return csiError(model.CSICodeInvalidArgument, "name is empty")
+	}
+	if strings.ContainsAny(name, "/@ \t\n") {
+		return csiError(model.CSICodeInvalidArgument, "name %q contains characters not allowed in RBD image names", name)
+	}
+	return nil
+}
+
+// requiredSize returns size in MB satisfying capacity range
+func requiredSize(capacity *model.CapacityRange) (uint64, error) {
+	if capacity == nil || capacity.RequiredBytes == 0 && capacity.LimitBytes == 0 {
+		return defaultCSIVolumeSize, nil
+	}
+	if capacity.RequiredBytes < 0 || capacity.LimitBytes < 0 {
+		return 0, csiError(model.CSICodeInvalidArgument, "capacity range cannot be negative")
+	}
+	size := uint64((capacity.RequiredBytes + mebibyte - 1) / mebibyte)
+	if size == 0 {
+		size = defaultCSIVolumeSize
+		if capacity.LimitBytes > 0 && size*mebibyte > uint64(capacity.LimitBytes) {
+			size = uint64(capacity.LimitBytes / mebibyte)
+		}
+	}
+	if size == 0 || capacity.LimitBytes > 0 && size*mebibyte > uint64(capacity.LimitBytes) {
+		return 0, csiError(model.CSICodeOutOfRange, "capacity range %d-%d bytes cannot be satisfied with whole MBs", capacity.RequiredBytes, capacity.LimitBytes)
+	}
+	return size, nil
+}
+
+func sizeInRange(sizeBytes uint64, capacity *model.CapacityRange) bool {
+	if capacity == nil {
+		return true
+	}
+	return sizeBytes >= uint64(capacity.RequiredBytes) && (capacity.LimitBytes == 0 || sizeBytes <= uint64(capacity.LimitBytes))
+}
+
+// volumeFileSystem validates capabilities and returns file system to format the volume with, empty for block volumes
+func volumeFileSystem(capabilities []model.VolumeCapability) (string, error) {
+	if len(capabilities) == 0 {
+		return "", csiError(model.CSICodeInvalidArgument, "volume capabilities are empty")
+	}
+	fs := ""
+	block := false
+	for _, capability := range capabilities {
+		switch capability.AccessMode {
+		case model.CSIAccessModeSingleNodeWriter, model.CSIAccessModeSingleNodeReaderOnly, model.CSIAccessModeMultiNodeReaderOnly:
+		default:
+			return "", csiError(model.CSICodeInvalidArgument, "access mode %q is not supported by RBD volumes", capability.AccessMode)
+		}
+		if capability.Block {
+			block = true
+			continue
+		}
+		capabilityFS := capability.FsType
+		if capabilityFS == "" {
+			capabilityFS = model.EXT4
+		}
+		if err := storage.ValidateFileSystem(capabilityFS); err != nil {
+			return "", csiError(model.CSICodeInvalidArgument, "%v", err)
+		}
+		if fs != "" && fs != capabilityFS {
+			return "", csiError(model.CSICodeInvalidArgument, "conflicting file systems %q and %q requested", fs, capabilityFS)
+		}
+		fs = capabilityFS
+	}
+	if block && fs != "" {
+		return "", csiError(model.CSICodeInvalidArgument, "block and mount access cannot be requested together")
+	}
+	return fs, nil
+}
+
+func (s *CSIController) volume(name string, sizeBytes uint64, fs string) model.Volume {
+	volumeContext := map[string]string{
+		"pool":     s.context.Config.CephPool,
+		"monitors": strings.Join(s.context.Config.CephMonitors, ","),
+	}
+	if fs != "" {
+		volumeContext["fsType"] = fs
+	}
+	return model.Volume{VolumeID: name, CapacityBytes: int64(sizeBytes), VolumeContext: volumeContext}
+}
+
+// ControllerGetCapabilities lists supported controller capabilities
+func (s *CSIController) ControllerGetCapabilities(ctx context.Context) []string {
+	return []string{
+		model.CSICapabilityCreateDeleteVolume,
+		model.CSICapabilityCreateDeleteSnapshot,
+		model.CSICapabilityListVolumes,
+		model.CSICapabilityExpandVolume,
+	}
+}
+
+// lockVolume reserves image for the call, it fails with ABORTED if the image is used by another operation of the broker
+func (s *CSIController) lockVolume(call, name string) (func(), error) {
+	unlock, err := s.context.Operations.lockImages("CSI "+call, name)
+	if err != nil {
+		return nil, csiError(model.CSICodeAborted, "%v", err)
+	}
+	return unlock, nil
+}
+
+// CreateVolume creates RBD image named after the volume and formats it unless block access is requested
+func (s *CSIController) CreateVolume(ctx context.Context, req *model.CreateVolumeRequest) (*model.CreateVolumeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, csiError(model.CSICodeAborted, "%v", err)
+	}
+	if err := validateCSIName(req.Name); err != nil {
+		return nil, err
+	}
+	fs, err := volumeFileSystem(req.VolumeCapabilities)
+	if err != nil {
+		return nil, err
+	}
+	size, err := requiredSize(req.CapacityRange)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lockVolume("CreateVolume", req.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	s.context.Operations.begin()
+	defer s.context.Operations.end()
+
+	info, err := s.context.Storage.ImageInfo(ctx, req.Name)
+	if err == nil {
+		if !sizeInRange(info.Size, req.CapacityRange) {
+			return nil, csiError(model.CSICodeAlreadyExists, "volume %q exists with incompatible size %d bytes", req.Name, info.Size)
+		}
+		return &model.CreateVolumeResponse{Volume: s.volume(req.Name, info.Size, fs)}, nil
+	}
+	if err != storage.ErrNotFound {
+		return nil, csiError(model.CSICodeInternal, "cannot get RBD image info: %v", err)
+	}
+
+	// block volumes are created with empty file system and are not formatted
+	if _, err = s.context.Storage.CreateImage(ctx, model.RBD{ImageName: req.Name, Size: size, FileSystem: fs}); err != nil {
+		if _, ok := err.(*storage.CapacityError); ok {
+			return nil, csiError(model.CSICodeResourceExhausted, "cannot create volume %q: %v", req.Name, err)
+		}
+		return nil, csiError(model.CSICodeInternal, "cannot create volume %q: %v", req.Name, err)
+	}
+	return &model.CreateVolumeResponse{Volume: s.volume(req.Name, size*mebibyte, fs)}, nil
+}
+
+// DeleteVolume moves RBD image which is not in use to trash; removing volume which does not exist succeeds
+func (s *CSIController) DeleteVolume(ctx context.Context, req *model.DeleteVolumeRequest) error {
+	if err := ctx.Err(); err != nil {
+		return csiError(model.CSICodeAborted, "%v", err)
+	}
+	if req.VolumeID == "" {
+		return csiError(model.CSICodeInvalidArgument, "volume id is empty")
+	}
+
+	unlock, err := s.lockVolume("DeleteVolume", req.VolumeID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s.context.Operations.begin()
+	defer s.context.Operations.end()
+
+	err = s.context.Storage.CheckNotInUse(ctx, req.VolumeID)
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if _, ok := err.(*storage.BusyError); ok {
+		return csiError(model.CSICodeFailedPrecondition, "volume %q is in use: %v", req.VolumeID, err)
+	}
+	if err == nil {
+		err = s.context.Storage.TrashImage(ctx, req.VolumeID, s.context.Config.TrashDeferment)
+	}
+	if err != nil && err != storage.ErrNotFound {
+		return csiError(model.CSICodeInternal, "cannot delete volume %q: %v", req.VolumeID, err)
+	}
+	return nil
+}
+
+// ControllerExpandVolume grows RBD image, file system has to be expanded on the node
+func (s *CSIController) ControllerExpandVolume(ctx context.Context, req *model.ControllerExpandVolumeRequest) (*model.ControllerExpandVolumeResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, csiError(model.CSICodeAborted, "%v", err)
+	}
+	if req.VolumeID == "" {
+		return nil, csiError(model.CSICodeInvalidArgument, "volume id is empty")
+	}
+	if req.CapacityRange == nil {
+		return nil, csiError(model.CSICodeInvalidArgument, "capacity range is required")
+	}
+	size, err := requiredSize(req.CapacityRange)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lockVolume("ControllerExpandVolume", req.VolumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	s.context.Operations.begin()
+	defer s.context.Operations.end()
+
+	info, err := s.context.Storage.ImageInfo(ctx, req.VolumeID)
+	if err == storage.ErrNotFound {
+		return nil, csiError(model.CSICodeNotFound, "volume %q does not exist", req.VolumeID)
+	} else if err != nil {
+		return nil, csiError(model.CSICodeInternal, "cannot get RBD image info: %v", err)
+	}
+	if info.Size >= size*mebibyte {
+		return &model.ControllerExpandVolumeResponse{CapacityBytes: int64(info.Size), NodeExpansionRequired: true}, nil
+	}
+	if err = s.context.Storage.ResizeImage(ctx, req.VolumeID, size); err != nil {
+		if _, ok := err.(*storage.CapacityError); ok {
+			return nil, csiError(model.CSICodeResourceExhausted, "cannot resize volume %q: %v", req.VolumeID, err)
+		}
+		return nil, csiError(model.CSICodeInternal, "cannot resize volume %q: %v", req.VolumeID, err)
+	}
+	return &model.ControllerExpandVolumeResponse{CapacityBytes: int64(size * mebibyte), NodeExpansionRequired: true}, nil
+}
+
+// CreateSnapshot creates RBD snapshot of the volume, snapshot id is <volume id>@<name>
+func (s *CSIController) CreateSnapshot(ctx context.Context, req *model.CreateSnapshotRequest) (*model.CreateSnapshotResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, csiError(model.CSICodeAborted, "%v", err)
+	}
+	if req.SourceVolumeID == "" {
+		return nil, csiError(model.CSICodeInvalidArgument, "source volume id is empty")
+	}
+	if err := validateCSIName(req.Name); err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lockVolume("CreateSnapshot", req.SourceVolumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	s.context.Operations.begin()
+	defer s.context.Operations.end()
+
+	snapshots, err := s.context.Storage.ListSnapshots(ctx, req.SourceVolumeID)
+	if err == storage.ErrNotFound {
+		return nil, csiError(model.CSICodeNotFound, "volume %q does not exist", req.SourceVolumeID)
+	} else if err != nil {
+		return nil, csiError(model.CSICodeInternal, "cannot list snapshots: %v", err)
+	}
+	snapshot := model.Snapshot{
+		SnapshotID:     req.SourceVolumeID + storage.SnapshotSeparator + req.Name,
+		SourceVolumeID: req.SourceVolumeID,
+		ReadyToUse:     true,
+	}
+	for _, existing := range snapshots {
+		if existing.Name == req.Name {
+			snapshot.SizeBytes = int64(existing.Size)
+			snapshot.CreationTime, _ = time.ParseInLocation(rbdTimestampLayout, existing.Timestamp, time.Local)
+			return &model.CreateSnapshotResponse{Snapshot: snapshot}, nil
+		}
+	}
+
+	info, err := s.context.Storage.ImageInfo(ctx, req.SourceVolumeID)
+	if err != nil {
+		return nil, csiError(model.CSICodeInternal, "cannot get RBD image info: %v", err)
+	}
+	if err = s.context.Storage.CreateSnapshot(ctx, req.SourceVolumeID, req.Name); err != nil {
+		return nil, csiError(model.CSICodeInternal, "cannot create snapshot %q: %v", snapshot.SnapshotID, err)
+	}
+	snapshot.SizeBytes = int64(info.Size)
+	snapshot.CreationTime = time.Now()
+	return &model.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
+// DeleteSnapshot removes RBD snapshot; removing snapshot which does not exist succeeds
+func (s *CSIController) DeleteSnapshot(ctx context.Context, snapshotID string) error {
+	if err := ctx.Err(); err != nil {
+		return csiError(model.CSICodeAborted, "%v", err)
+	}
+	if snapshotID == "" {
+		return csiError(model.CSICodeInvalidArgument, "snapshot id is empty")
+	}
+	parts := strings.SplitN(snapshotID, storage.SnapshotSeparator, 2)
+	if len(parts) != 2 {
+		return nil
+	}
+
+	unlock, err := s.lockVolume("DeleteSnapshot", parts[0])
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s.context.Operations.begin()
+	defer s.context.Operations.end()
+
+	if err := s.context.Storage.RemoveSnapshot(ctx, parts[0], parts[1]); err != nil && err != storage.ErrNotFound {
+		return csiError(model.CSICodeInternal, "cannot delete snapshot %q: %v", snapshotID, err)
+	}
+	return nil
+}
+
+// ListVolumes lists RBD images; starting token is index of the first returned image
+func (s *CSIController) ListVolumes(ctx context.Context, req *model.ListVolumesRequest) (*model.ListVolumesResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, csiError(model.CSICodeAborted, "%v", err)
+	}
+	if req.MaxEntries < 0 {
+		return nil, csiError(model.CSICodeInvalidArgument, "max entries cannot be negative")
+	}
+	images, err := s.context.Storage.ListImages(ctx)
+	if err != nil {
+		return nil, csiError(model.CSICodeInternal, "cannot list RBD images: %v", err)
+	}
+	sort.Strings(images)
+
+	start := 0
+	if req.StartingToken != "" {
+		if start, err = strconv.Atoi(req.StartingToken); err != nil || start < 0 || start > len(images) {
+			return nil, csiError(model.CSICodeAborted, "invalid starting token %q", req.StartingToken)
+		}
+	}
+	end := len(images)
+	if req.MaxEntries > 0 && start+int(req.MaxEntries) < end {
+		end = start + int(req.MaxEntries)
+	}
+
+	response := &model.ListVolumesResponse{Entries: []model.Volume{}}
+	for _, image := range images[start:end] {
+		info, err := s.context.Storage.ImageInfo(ctx, image)
+		if err == storage.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, csiError(model.CSICodeInternal, "cannot get RBD image info: %v", err)
+		}
+		response.Entries = append(response.Entries, s.volume(image, info.Size, ""))
+	}
+	if end < len(images) {
+		response.NextToken = strconv.Itoa(end)
+	}
+	return response, nil
+}
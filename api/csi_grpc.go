@@ -0,0 +1,267 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+)
+
+// Name and version of the CSI plugin reported by Identity service
+const (
+	CSIDriverName    = "tap-ceph-broker.csi"
+	CSIDriverVersion = "0.1.0"
+)
+
+// csiServer exposes CSIController as CSI Identity and Controller gRPC services, translating generated messages
+// to model types. Calls not supported by RBD volumes are left unimplemented.
+type csiServer struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	controller *CSIController
+}
+
+// NewCSIServer returns gRPC server with CSI Identity and Controller services registered. Calls are authenticated
+// as the user of the process connected over Unix socket, and mutating calls are recorded in the audit log.
+func NewCSIServer(c *Context) *grpc.Server {
+	server := grpc.NewServer(grpc.Creds(newUnixTransportCredentials()), grpc.UnaryInterceptor(c.csiAuditInterceptor))
+	csiServer := &csiServer{controller: NewCSIController(c)}
+	csi.RegisterIdentityServer(server, csiServer)
+	csi.RegisterControllerServer(server, csiServer)
+	return server
+}
+
+// csiAuditEntry returns audit entry describing mutating CSI call, secrets sent with the call are not recorded
+func csiAuditEntry(req interface{}) (model.AuditEntry, bool) {
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		parameters := map[string]interface{}{"requiredBytes": r.GetCapacityRange().GetRequiredBytes(), "limitBytes": r.GetCapacityRange().GetLimitBytes()}
+		if len(r.Parameters) > 0 {
+			parameters["parameters"] = r.Parameters
+		}
+		return model.AuditEntry{ImageName: r.Name, Parameters: parameters}, true
+	case *csi.DeleteVolumeRequest:
+		return model.AuditEntry{ImageName: r.VolumeId}, true
+	case *csi.ControllerExpandVolumeRequest:
+		parameters := map[string]interface{}{"requiredBytes": r.GetCapacityRange().GetRequiredBytes(), "limitBytes": r.GetCapacityRange().GetLimitBytes()}
+		return model.AuditEntry{ImageName: r.VolumeId, Parameters: parameters}, true
+	case *csi.CreateSnapshotRequest:
+		return model.AuditEntry{ImageName: r.SourceVolumeId, Parameters: map[string]interface{}{"name": r.Name}}, true
+	case *csi.DeleteSnapshotRequest:
+		imageName := strings.SplitN(r.SnapshotId, "@", 2)[0]
+		return model.AuditEntry{ImageName: imageName, Parameters: map[string]interface{}{"snapshotId": r.SnapshotId}}, true
+	}
+	return model.AuditEntry{}, false
+}
+
+// csiAuditInterceptor records mutating CSI calls in the audit log, like AuditMiddleware does for REST API requests.
+// Status of the entry is gRPC status code of the call.
+func (c *Context) csiAuditInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	entry, ok := csiAuditEntry(req)
+	if c.Audit == nil || !ok {
+		return handler(ctx, req)
+	}
+
+	startTime := time.Now()
+	entry.Time = startTime.UTC()
+	entry.RequestID = model.NewRequestID()
+	entry.Operation = info.FullMethod
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.SourceAddress = p.Addr.String()
+		if authInfo, ok := p.AuthInfo.(peerAuthInfo); ok {
+			entry.Principal = peerPrincipalName(authInfo.Credentials)
+		}
+	}
+
+	// commands executed by the call are logged with the request id of the entry
+	response, err := handler(storage.WithLogger(ctx, newRequestLogger(entry.RequestID)), req)
+
+	entry.DurationMs = int64(time.Since(startTime) / time.Millisecond)
+	entry.Status = int(status.Code(err))
+	entry.Outcome = model.AuditOutcomeSuccess
+	if err != nil {
+		entry.Outcome = model.AuditOutcomeFailure
+	}
+	if err := c.Audit.Record(entry); err != nil {
+		newRequestLogger(entry.RequestID).Errorf("csiAuditInterceptor: cannot record entry %v: %v", entry, err)
+	}
+	return response, err
+}
+
+// csiStatus converts error returned by CSIController to gRPC status error
+func csiStatus(err error) error {
+	return status.Error(codes.Code(CSIErrorCode(err)), err.Error())
+}
+
+func csiCapacityRange(capacity *csi.CapacityRange) *model.CapacityRange {
+	if capacity == nil {
+		return nil
+	}
+	return &model.CapacityRange{RequiredBytes: capacity.RequiredBytes, LimitBytes: capacity.LimitBytes}
+}
+
+func csiVolumeCapabilities(capabilities []*csi.VolumeCapability) []model.VolumeCapability {
+	result := []model.VolumeCapability{}
+	for _, capability := range capabilities {
+		volumeCapability := model.VolumeCapability{
+			Block:      capability.GetBlock() != nil,
+			AccessMode: capability.GetAccessMode().GetMode().String(),
+		}
+		if mount := capability.GetMount(); mount != nil {
+			volumeCapability.FsType = mount.FsType
+		}
+		result = append(result, volumeCapability)
+	}
+	return result
+}
+
+func csiVolume(volume model.Volume) *csi.Volume {
+	return &csi.Volume{VolumeId: volume.VolumeID, CapacityBytes: volume.CapacityBytes, VolumeContext: volume.VolumeContext}
+}
+
+func (s *csiServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: CSIDriverName, VendorVersion: CSIDriverVersion}, nil
+}
+
+func (s *csiServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{Capabilities: []*csi.PluginCapability{
+		{Type: &csi.PluginCapability_Service_{Service: &csi.PluginCapability_Service{
+			Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
+		}}},
+		{Type: &csi.PluginCapability_VolumeExpansion_{VolumeExpansion: &csi.PluginCapability_VolumeExpansion{
+			Type: csi.PluginCapability_VolumeExpansion_ONLINE,
+		}}},
+	}}, nil
+}
+
+func (s *csiServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(true)}, nil
+}
+
+func (s *csiServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	response := &csi.ControllerGetCapabilitiesResponse{}
+	for _, capability := range s.controller.ControllerGetCapabilities(ctx) {
+		response.Capabilities = append(response.Capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{
+				Type: csi.ControllerServiceCapability_RPC_Type(csi.ControllerServiceCapability_RPC_Type_value[capability]),
+			}},
+		})
+	}
+	return response, nil
+}
+
+func (s *csiServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	response, err := s.controller.CreateVolume(ctx, &model.CreateVolumeRequest{
+		Name:               req.Name,
+		CapacityRange:      csiCapacityRange(req.CapacityRange),
+		VolumeCapabilities: csiVolumeCapabilities(req.VolumeCapabilities),
+		Parameters:         req.Parameters,
+	})
+	if err != nil {
+		return nil, csiStatus(err)
+	}
+	return &csi.CreateVolumeResponse{Volume: csiVolume(response.Volume)}, nil
+}
+
+func (s *csiServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if err := s.controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: req.VolumeId}); err != nil {
+		return nil, csiStatus(err)
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ValidateVolumeCapabilities confirms capabilities which CreateVolume would accept for existing volume
+func (s *csiServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+	if _, err := s.controller.context.Storage.ImageInfo(ctx, req.VolumeId); err == storage.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "volume %q does not exist", req.VolumeId)
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot get RBD image info: %v", err)
+	}
+	if _, err := volumeFileSystem(csiVolumeCapabilities(req.VolumeCapabilities)); err != nil {
+		if len(req.VolumeCapabilities) == 0 {
+			return nil, csiStatus(err)
+		}
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+		VolumeContext:      req.VolumeContext,
+		VolumeCapabilities: req.VolumeCapabilities,
+		Parameters:         req.Parameters,
+	}}, nil
+}
+
+func (s *csiServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	response, err := s.controller.ListVolumes(ctx, &model.ListVolumesRequest{MaxEntries: req.MaxEntries, StartingToken: req.StartingToken})
+	if err != nil {
+		return nil, csiStatus(err)
+	}
+	result := &csi.ListVolumesResponse{NextToken: response.NextToken}
+	for _, volume := range response.Entries {
+		result.Entries = append(result.Entries, &csi.ListVolumesResponse_Entry{Volume: csiVolume(volume)})
+	}
+	return result, nil
+}
+
+func (s *csiServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	response, err := s.controller.CreateSnapshot(ctx, &model.CreateSnapshotRequest{SourceVolumeID: req.SourceVolumeId, Name: req.Name})
+	if err != nil {
+		return nil, csiStatus(err)
+	}
+	return &csi.CreateSnapshotResponse{Snapshot: &csi.Snapshot{
+		SnapshotId:     response.Snapshot.SnapshotID,
+		SourceVolumeId: response.Snapshot.SourceVolumeID,
+		SizeBytes:      response.Snapshot.SizeBytes,
+		CreationTime:   timestamppb.New(response.Snapshot.CreationTime),
+		ReadyToUse:     response.Snapshot.ReadyToUse,
+	}}, nil
+}
+
+func (s *csiServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	if err := s.controller.DeleteSnapshot(ctx, req.SnapshotId); err != nil {
+		return nil, csiStatus(err)
+	}
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+func (s *csiServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	response, err := s.controller.ControllerExpandVolume(ctx, &model.ControllerExpandVolumeRequest{
+		VolumeID:      req.VolumeId,
+		CapacityRange: csiCapacityRange(req.CapacityRange),
+	})
+	if err != nil {
+		return nil, csiStatus(err)
+	}
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         response.CapacityBytes,
+		NodeExpansionRequired: response.NodeExpansionRequired,
+	}, nil
+}
@@ -0,0 +1,239 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/audit"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func csiMountCapabilities(fs string) []*csi.VolumeCapability {
+	return []*csi.VolumeCapability{{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fs}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}}
+}
+
+// TestCSIServer runs basic CSI sanity checks against gRPC server listening on Unix socket
+func TestCSIServer(t *testing.T) {
+	Convey("Testing CSI gRPC server", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		dir, err := ioutil.TempDir("", "csi")
+		So(err, ShouldBeNil)
+		socket := filepath.Join(dir, "csi.sock")
+		listener, err := net.Listen("unix", socket)
+		So(err, ShouldBeNil)
+		server := NewCSIServer(&c)
+		go server.Serve(listener)
+		connection, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			connection.Close()
+			server.Stop()
+			os.RemoveAll(dir)
+		})
+
+		identity := csi.NewIdentityClient(connection)
+		controller := csi.NewControllerClient(connection)
+		ctx := context.Background()
+		capacity := &csi.CapacityRange{RequiredBytes: 2 * 1024 * mebibyte}
+
+		Convey("Identity service reports plugin and controller capability", func() {
+			info, err := identity.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+			So(err, ShouldBeNil)
+			So(info.Name, ShouldEqual, CSIDriverName)
+			So(info.VendorVersion, ShouldNotBeEmpty)
+
+			probe, err := identity.Probe(ctx, &csi.ProbeRequest{})
+			So(err, ShouldBeNil)
+			So(probe.GetReady().GetValue(), ShouldBeTrue)
+
+			capabilities, err := identity.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+			So(err, ShouldBeNil)
+			So(capabilities.Capabilities[0].GetService().GetType(), ShouldEqual, csi.PluginCapability_Service_CONTROLLER_SERVICE)
+		})
+
+		Convey("Controller capabilities are translated", func() {
+			response, err := controller.ControllerGetCapabilities(ctx, &csi.ControllerGetCapabilitiesRequest{})
+
+			So(err, ShouldBeNil)
+			types := []csi.ControllerServiceCapability_RPC_Type{}
+			for _, capability := range response.Capabilities {
+				types = append(types, capability.GetRpc().GetType())
+			}
+			So(types, ShouldResemble, []csi.ControllerServiceCapability_RPC_Type{
+				csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+				csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+				csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+				csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+			})
+		})
+
+		Convey("CreateVolume validates request", func() {
+			_, err := controller.CreateVolume(ctx, &csi.CreateVolumeRequest{VolumeCapabilities: csiMountCapabilities("")})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+
+			_, err = controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-1"})
+			So(status.Code(err), ShouldEqual, codes.InvalidArgument)
+		})
+
+		Convey("CreateVolume is idempotent and DeleteVolume removes volume", func() {
+			request := &csi.CreateVolumeRequest{Name: "pvc-1", CapacityRange: capacity, VolumeCapabilities: csiMountCapabilities("xfs")}
+
+			first, err := controller.CreateVolume(ctx, request)
+			So(err, ShouldBeNil)
+			second, err := controller.CreateVolume(ctx, request)
+			So(err, ShouldBeNil)
+			So(second.Volume.VolumeId, ShouldEqual, first.Volume.VolumeId)
+			So(second.Volume.CapacityBytes, ShouldEqual, capacity.RequiredBytes)
+
+			request.CapacityRange = &csi.CapacityRange{RequiredBytes: 4 * 1024 * mebibyte}
+			_, err = controller.CreateVolume(ctx, request)
+			So(status.Code(err), ShouldEqual, codes.AlreadyExists)
+
+			for i := 0; i < 2; i++ {
+				_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+				So(err, ShouldBeNil)
+			}
+			_, ok := backend.Image("pvc-1")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("ValidateVolumeCapabilities confirms capabilities of existing volume", func() {
+			_, err := controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "pvc-1", VolumeCapabilities: csiMountCapabilities("")})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			backend.AddImage("pvc-1", 1024, "ext4")
+			response, err := controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "pvc-1", VolumeCapabilities: csiMountCapabilities("")})
+			So(err, ShouldBeNil)
+			So(response.Confirmed, ShouldNotBeNil)
+
+			multiWriter := csiMountCapabilities("")
+			multiWriter[0].AccessMode.Mode = csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER
+			response, err = controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{VolumeId: "pvc-1", VolumeCapabilities: multiWriter})
+			So(err, ShouldBeNil)
+			So(response.Confirmed, ShouldBeNil)
+			So(response.Message, ShouldNotBeEmpty)
+		})
+
+		Convey("ListVolumes pages through volumes", func() {
+			backend.AddImage("pvc-1", 1024, "ext4")
+			backend.AddImage("pvc-2", 1024, "ext4")
+
+			first, err := controller.ListVolumes(ctx, &csi.ListVolumesRequest{MaxEntries: 1})
+			So(err, ShouldBeNil)
+			So(len(first.Entries), ShouldEqual, 1)
+			So(first.NextToken, ShouldNotBeEmpty)
+
+			second, err := controller.ListVolumes(ctx, &csi.ListVolumesRequest{StartingToken: first.NextToken})
+			So(err, ShouldBeNil)
+			So(len(second.Entries), ShouldEqual, 1)
+			So(second.Entries[0].Volume.VolumeId, ShouldEqual, "pvc-2")
+			So(second.NextToken, ShouldBeEmpty)
+		})
+
+		Convey("Snapshots are created and deleted idempotently", func() {
+			backend.AddImage("pvc-1", 1024, "ext4")
+
+			for i := 0; i < 2; i++ {
+				response, err := controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: "pvc-1", Name: "snap-1"})
+				So(err, ShouldBeNil)
+				So(response.Snapshot.SnapshotId, ShouldEqual, "pvc-1@snap-1")
+				So(response.Snapshot.CreationTime, ShouldNotBeNil)
+			}
+			for i := 0; i < 2; i++ {
+				_, err := controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "pvc-1@snap-1"})
+				So(err, ShouldBeNil)
+			}
+
+			_, err := controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: "pvc-2", Name: "snap-1"})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+		})
+
+		Convey("ControllerExpandVolume grows volume", func() {
+			_, err := controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "pvc-1", CapacityRange: capacity})
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+
+			backend.AddImage("pvc-1", 1024, "ext4")
+			response, err := controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{VolumeId: "pvc-1", CapacityRange: capacity})
+			So(err, ShouldBeNil)
+			So(response.CapacityBytes, ShouldEqual, capacity.RequiredBytes)
+			So(response.NodeExpansionRequired, ShouldBeTrue)
+		})
+
+		Convey("Mutating calls are audited", func() {
+			auditLog, err := audit.NewFileLog(filepath.Join(dir, "audit.log"))
+			So(err, ShouldBeNil)
+			defer auditLog.Close()
+			c.Audit = auditLog
+			capabilities := csiMountCapabilities("ext4")
+
+			_, err = controller.CreateVolume(ctx, &csi.CreateVolumeRequest{Name: "pvc-1", CapacityRange: capacity, VolumeCapabilities: capabilities,
+				Secrets: map[string]string{"key": "secret"}})
+			So(err, ShouldBeNil)
+			expand := &csi.ControllerExpandVolumeRequest{VolumeId: "pvc-1", CapacityRange: &csi.CapacityRange{RequiredBytes: 3 * 1024 * mebibyte}}
+			_, err = controller.ControllerExpandVolume(ctx, expand)
+			So(err, ShouldBeNil)
+			_, err = controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "pvc-1"})
+			So(err, ShouldBeNil)
+			_, err = controller.ControllerExpandVolume(ctx, expand)
+			So(status.Code(err), ShouldEqual, codes.NotFound)
+			_, err = controller.ListVolumes(ctx, &csi.ListVolumesRequest{})
+			So(err, ShouldBeNil)
+
+			entries, err := auditLog.Query(audit.Filter{})
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 4)
+			principal := peerPrincipalName(PeerCredentials{UID: uint32(os.Getuid())})
+			for _, entry := range entries {
+				So(entry.ImageName, ShouldEqual, "pvc-1")
+				So(entry.Principal, ShouldEqual, principal)
+				So(entry.RequestID, ShouldNotBeEmpty)
+			}
+			So(entries[0].Operation, ShouldEqual, "/csi.v1.Controller/CreateVolume")
+			So(entries[0].Outcome, ShouldEqual, model.AuditOutcomeSuccess)
+			So(entries[0].Parameters["requiredBytes"], ShouldEqual, capacity.RequiredBytes)
+			So(entries[0].Parameters, ShouldNotContainKey, "secrets")
+			So(entries[1].Operation, ShouldEqual, "/csi.v1.Controller/ControllerExpandVolume")
+			So(entries[1].Parameters["requiredBytes"], ShouldEqual, expand.CapacityRange.RequiredBytes)
+			So(entries[2].Operation, ShouldEqual, "/csi.v1.Controller/DeleteVolume")
+			So(entries[3].Outcome, ShouldEqual, model.AuditOutcomeFailure)
+			So(entries[3].Status, ShouldEqual, int(codes.NotFound))
+		})
+
+		Convey("Calls not supported by RBD volumes are unimplemented", func() {
+			_, err := controller.ControllerPublishVolume(ctx, &csi.ControllerPublishVolumeRequest{VolumeId: "pvc-1", NodeId: "node-1"})
+
+			So(status.Code(err), ShouldEqual, codes.Unimplemented)
+		})
+	})
+}
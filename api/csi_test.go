@@ -0,0 +1,312 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func mountCapability(fs string) []model.VolumeCapability {
+	return []model.VolumeCapability{{FsType: fs, AccessMode: model.CSIAccessModeSingleNodeWriter}}
+}
+
+func TestCSICreateVolume(t *testing.T) {
+	Convey("Testing CSI CreateVolume", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		controller := NewCSIController(&c)
+		ctx := context.Background()
+		name := "pvc-1"
+		capacity := &model.CapacityRange{RequiredBytes: 2 * 1024 * mebibyte}
+
+		Convey("When volume does not exist it is created and formatted", func() {
+			response, err := controller.CreateVolume(ctx, &model.CreateVolumeRequest{Name: name, CapacityRange: capacity, VolumeCapabilities: mountCapability(model.XFS)})
+
+			So(err, ShouldBeNil)
+			So(response.Volume.VolumeID, ShouldEqual, name)
+			So(response.Volume.CapacityBytes, ShouldEqual, capacity.RequiredBytes)
+			So(response.Volume.VolumeContext["fsType"], ShouldEqual, model.XFS)
+			image, _ := backend.Image(name)
+			So(image.Size, ShouldEqual, 2048)
+			So(image.FileSystem, ShouldEqual, model.XFS)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When block volume is requested it is not formatted", func() {
+			capabilities := []model.VolumeCapability{{Block: true, AccessMode: model.CSIAccessModeSingleNodeWriter}}
+
+			_, err := controller.CreateVolume(ctx, &model.CreateVolumeRequest{Name: name, VolumeCapabilities: capabilities})
+
+			So(err, ShouldBeNil)
+			image, _ := backend.Image(name)
+			So(image.Size, ShouldEqual, defaultCSIVolumeSize)
+			So(image.FileSystem, ShouldBeEmpty)
+		})
+
+		Convey("When compatible volume exists the call is idempotent", func() {
+			request := &model.CreateVolumeRequest{Name: name, CapacityRange: capacity, VolumeCapabilities: mountCapability("")}
+			_, err := controller.CreateVolume(ctx, request)
+			So(err, ShouldBeNil)
+
+			response, err := controller.CreateVolume(ctx, request)
+
+			So(err, ShouldBeNil)
+			So(response.Volume.CapacityBytes, ShouldEqual, capacity.RequiredBytes)
+		})
+
+		Convey("When volume exists with incompatible size", func() {
+			backend.AddImage(name, 1024, model.EXT4)
+
+			_, err := controller.CreateVolume(ctx, &model.CreateVolumeRequest{Name: name, CapacityRange: capacity, VolumeCapabilities: mountCapability("")})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAlreadyExists)
+		})
+
+		Convey("When request is invalid", func() {
+			requests := []model.CreateVolumeRequest{
+				{Name: "", VolumeCapabilities: mountCapability("")},
+				{Name: "bad@name", VolumeCapabilities: mountCapability("")},
+				{Name: name},
+				{Name: name, VolumeCapabilities: mountCapability("ntfs")},
+				{Name: name, VolumeCapabilities: []model.VolumeCapability{{AccessMode: "MULTI_NODE_MULTI_WRITER"}}},
+			}
+			for _, request := range requests {
+				_, err := controller.CreateVolume(ctx, &request)
+
+				So(CSIErrorCode(err), ShouldEqual, model.CSICodeInvalidArgument)
+			}
+		})
+
+		Convey("When capacity range cannot be satisfied", func() {
+			capacity := &model.CapacityRange{RequiredBytes: mebibyte + 1, LimitBytes: mebibyte + 2}
+
+			_, err := controller.CreateVolume(ctx, &model.CreateVolumeRequest{Name: name, CapacityRange: capacity, VolumeCapabilities: mountCapability("")})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeOutOfRange)
+		})
+
+		Convey("When volume is used by another operation", func() {
+			unlock, err := c.Operations.lockImages("test operation", name)
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			_, err = controller.CreateVolume(ctx, &model.CreateVolumeRequest{Name: name, CapacityRange: capacity, VolumeCapabilities: mountCapability(model.XFS)})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+			_, ok := backend.Image(name)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestCSIDeleteVolume(t *testing.T) {
+	Convey("Testing CSI DeleteVolume", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		controller := NewCSIController(&c)
+		ctx := context.Background()
+		backend.AddImage("pvc-1", 1024, model.EXT4)
+
+		Convey("When volume exists", func() {
+			So(controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-1"}), ShouldBeNil)
+
+			_, ok := backend.Image("pvc-1")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When volume does not exist", func() {
+			So(controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-2"}), ShouldBeNil)
+		})
+
+		Convey("When volume is in use", func() {
+			backend.MapImage("pvc-1")
+
+			err := controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-1"})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeFailedPrecondition)
+		})
+
+		Convey("When volume is being copied asynchronously", func() {
+			release := backend.Hold("rbd", "cp")
+			defer release()
+			body := commonHttp.PrepareAndValidateRequest(model.CopyRequest{DestinationName: "pvc-2"}, t)
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd/pvc-1/copy?async=true", body, SetupRouter(&c), authorizedHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusAccepted)
+
+			err := controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-1"})
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+			err = controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-2"})
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+			_, ok := backend.Image("pvc-1")
+			So(ok, ShouldBeTrue)
+
+			release()
+			for i := 0; i < 100 && err != nil; i++ {
+				time.Sleep(10 * time.Millisecond)
+				err = controller.DeleteVolume(ctx, &model.DeleteVolumeRequest{VolumeID: "pvc-1"})
+			}
+			So(err, ShouldBeNil)
+			_, ok = backend.Image("pvc-2")
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When context is cancelled", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			err := controller.DeleteVolume(cancelled, &model.DeleteVolumeRequest{VolumeID: "pvc-1"})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+			_, ok := backend.Image("pvc-1")
+			So(ok, ShouldBeTrue)
+		})
+	})
+}
+
+func TestCSIControllerExpandVolume(t *testing.T) {
+	Convey("Testing CSI ControllerExpandVolume", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		controller := NewCSIController(&c)
+		ctx := context.Background()
+		request := &model.ControllerExpandVolumeRequest{VolumeID: "pvc-1", CapacityRange: &model.CapacityRange{RequiredBytes: 2048 * mebibyte}}
+
+		Convey("When volume is smaller", func() {
+			backend.AddImage("pvc-1", 1024, model.EXT4)
+
+			response, err := controller.ControllerExpandVolume(ctx, request)
+
+			So(err, ShouldBeNil)
+			So(response.CapacityBytes, ShouldEqual, 2048*mebibyte)
+			So(response.NodeExpansionRequired, ShouldBeTrue)
+			image, _ := backend.Image("pvc-1")
+			So(image.Size, ShouldEqual, 2048)
+		})
+
+		Convey("When volume is already big enough", func() {
+			backend.AddImage("pvc-1", 4096, model.EXT4)
+
+			response, err := controller.ControllerExpandVolume(ctx, request)
+
+			So(err, ShouldBeNil)
+			So(response.CapacityBytes, ShouldEqual, 4096*mebibyte)
+			image, _ := backend.Image("pvc-1")
+			So(image.Size, ShouldEqual, 4096)
+		})
+
+		Convey("When volume does not exist", func() {
+			_, err := controller.ControllerExpandVolume(ctx, request)
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeNotFound)
+		})
+
+		Convey("When volume is used by another operation", func() {
+			backend.AddImage("pvc-1", 1024, model.EXT4)
+			unlock, err := c.Operations.lockImages("test operation", "pvc-1")
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			_, err = controller.ControllerExpandVolume(ctx, request)
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+			image, _ := backend.Image("pvc-1")
+			So(image.Size, ShouldEqual, 1024)
+		})
+	})
+}
+
+func TestCSICreateSnapshot(t *testing.T) {
+	Convey("Testing CSI CreateSnapshot", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		controller := NewCSIController(&c)
+		ctx := context.Background()
+		request := &model.CreateSnapshotRequest{SourceVolumeID: "pvc-1", Name: "snap-1"}
+		backend.AddImage("pvc-1", 1024, model.EXT4)
+
+		Convey("When snapshot does not exist", func() {
+			response, err := controller.CreateSnapshot(ctx, request)
+
+			So(err, ShouldBeNil)
+			So(response.Snapshot.SnapshotID, ShouldEqual, "pvc-1@snap-1")
+			So(response.Snapshot.SizeBytes, ShouldEqual, 1024*mebibyte)
+			So(response.Snapshot.ReadyToUse, ShouldBeTrue)
+			image, _ := backend.Image("pvc-1")
+			So(image.Snapshots, ShouldHaveLength, 1)
+
+			Convey("And it is created again", func() {
+				again, err := controller.CreateSnapshot(ctx, request)
+
+				So(err, ShouldBeNil)
+				So(again.Snapshot.SnapshotID, ShouldEqual, response.Snapshot.SnapshotID)
+				So(again.Snapshot.CreationTime.Unix(), ShouldEqual, response.Snapshot.CreationTime.Unix())
+			})
+
+			Convey("And it is deleted", func() {
+				So(controller.DeleteSnapshot(ctx, "pvc-1@snap-1"), ShouldBeNil)
+				So(controller.DeleteSnapshot(ctx, "pvc-1@snap-1"), ShouldBeNil)
+
+				image, _ := backend.Image("pvc-1")
+				So(image.Snapshots, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When source volume does not exist", func() {
+			request.SourceVolumeID = "pvc-2"
+
+			_, err := controller.CreateSnapshot(ctx, request)
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeNotFound)
+		})
+	})
+}
+
+func TestCSIListVolumes(t *testing.T) {
+	Convey("Testing CSI ListVolumes", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		controller := NewCSIController(&c)
+		ctx := context.Background()
+		for _, name := range []string{"pvc-3", "pvc-1", "pvc-2"} {
+			backend.AddImage(name, 1024, model.EXT4)
+		}
+
+		Convey("When volumes are listed in pages", func() {
+			first, err := controller.ListVolumes(ctx, &model.ListVolumesRequest{MaxEntries: 2})
+			So(err, ShouldBeNil)
+			So(first.Entries, ShouldHaveLength, 2)
+			So(first.Entries[0].VolumeID, ShouldEqual, "pvc-1")
+			So(first.Entries[0].CapacityBytes, ShouldEqual, 1024*mebibyte)
+			So(first.NextToken, ShouldEqual, "2")
+
+			second, err := controller.ListVolumes(ctx, &model.ListVolumesRequest{MaxEntries: 2, StartingToken: first.NextToken})
+			So(err, ShouldBeNil)
+			So(second.Entries, ShouldHaveLength, 1)
+			So(second.Entries[0].VolumeID, ShouldEqual, "pvc-3")
+			So(second.NextToken, ShouldEqual, "")
+		})
+
+		Convey("When starting token is invalid", func() {
+			_, err := controller.ListVolumes(ctx, &model.ListVolumesRequest{StartingToken: "5"})
+
+			So(CSIErrorCode(err), ShouldEqual, model.CSICodeAborted)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// CheckFileSystem checks or repairs file system of RBD which is not watched or locked by any client.
+// Result of the check tool is returned with status 200 even if errors were found.
+func (c *Context) CheckFileSystem(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	input := model.FsckRequest{}
+	if req.ContentLength != 0 {
+		if err := commonHttp.ReadJson(req, &input); err != nil {
+			respond400(rw, req, err)
+			return
+		}
+	}
+	if input.FileSystem != "" {
+		if err := storage.ValidateFileSystem(input.FileSystem); err != nil {
+			respond400(rw, req, err)
+			return
+		}
+	}
+
+	unlock, ok := c.lockImage(rw, req, name)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	result, err := c.Storage.CheckFileSystem(requestContext(req), name, input.FileSystem, input.Repair)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			respond404(rw, req, fmt.Errorf("cannot check RBD: %v", err))
+			return
+		}
+		if busyErr, ok := err.(*storage.BusyError); ok {
+			respondBusy(rw, req, busyErr)
+			return
+		}
+		respond500(rw, req, fmt.Errorf("cannot check RBD: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, result, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
@@ -0,0 +1,161 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestCheckFileSystem(t *testing.T) {
+	Convey("Testing CheckFileSystem", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		sampleName := "sample_RBD"
+		path := "/api/v1/rbd/" + sampleName + "/fsck"
+		backend.AddImage(sampleName, 1024, model.EXT4)
+
+		check := func(body string) (int, model.FsckResult) {
+			rr := commonHttp.SendRequestWithHeaders("POST", path, []byte(body), router, authorizedHeader(), t)
+			result := model.FsckResult{}
+			if rr.Code == http.StatusOK {
+				So(json.Unmarshal(rr.Body.Bytes(), &result), ShouldBeNil)
+			}
+			return rr.Code, result
+		}
+
+		Convey("When file system is clean", func() {
+			status, result := check("")
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(result.FileSystem, ShouldEqual, model.EXT4)
+			So(result.Command, ShouldEqual, "/sbin/e2fsck -f -n /dev/rbd0")
+			So(result.ExitStatus, ShouldEqual, 0)
+			So(result.Clean, ShouldBeTrue)
+			image, _ := backend.Image(sampleName)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When check finds errors", func() {
+			backend.Corrupt(sampleName)
+
+			status, result := check(`{"repair": false}`)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(result.ExitStatus, ShouldEqual, 4)
+			So(result.Clean, ShouldBeFalse)
+			So(result.Report, ShouldContainSubstring, "UNEXPECTED INCONSISTENCY")
+			image, _ := backend.Image(sampleName)
+			So(image.Corrupted, ShouldBeTrue)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When errors are repaired", func() {
+			backend.Corrupt(sampleName)
+
+			status, result := check(`{"repair": true}`)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(result.Command, ShouldEqual, "/sbin/e2fsck -f -y /dev/rbd0")
+			So(result.ExitStatus, ShouldEqual, 1)
+			So(result.Clean, ShouldBeTrue)
+			image, _ := backend.Image(sampleName)
+			So(image.Corrupted, ShouldBeFalse)
+		})
+
+		Convey("When xfs is checked", func() {
+			backend.AddImage("xfs_RBD", 1024, model.XFS)
+			backend.Corrupt("xfs_RBD")
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd/xfs_RBD/fsck", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			result := model.FsckResult{}
+			So(json.Unmarshal(rr.Body.Bytes(), &result), ShouldBeNil)
+			So(result.Command, ShouldEqual, "/sbin/xfs_repair -n /dev/rbd0")
+			So(result.ExitStatus, ShouldEqual, 1)
+			So(result.Clean, ShouldBeFalse)
+		})
+
+		Convey("When file system is recorded it is not detected", func() {
+			backend.AddImage("xfs_RBD", 1024, model.XFS)
+			recordFileSystem(backend, "xfs_RBD", model.XFS)
+			backend.Fail("", errors.New("exit status 2"), "blkid")
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd/xfs_RBD/fsck", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			result := model.FsckResult{}
+			So(json.Unmarshal(rr.Body.Bytes(), &result), ShouldBeNil)
+			So(result.FileSystem, ShouldEqual, model.XFS)
+			So(result.Command, ShouldEqual, "/sbin/xfs_repair -n /dev/rbd0")
+			So(result.Clean, ShouldBeTrue)
+		})
+
+		Convey("When image is locked", func() {
+			backend.AddLock(sampleName, fake.Lock{ID: "kubelet_lock_magic_node-1", Locker: "client.4100", Address: "10.0.0.1:0/1"})
+
+			status, _ := check("")
+
+			So(status, ShouldEqual, http.StatusConflict)
+			image, _ := backend.Image(sampleName)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When image is mapped elsewhere it is not repaired", func() {
+			backend.AddWatcher(sampleName, fake.Watcher{Address: "10.0.0.2:0/2", Client: 4200, Cookie: 1})
+			backend.Corrupt(sampleName)
+
+			status, _ := check(`{"repair": true}`)
+
+			So(status, ShouldEqual, http.StatusConflict)
+			image, _ := backend.Image(sampleName)
+			So(image.Corrupted, ShouldBeTrue)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When image does not exist", func() {
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd/other/fsck", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When file system is not supported", func() {
+			status, _ := check(`{"fileSystem": "ntfs"}`)
+
+			So(status, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When image is not formatted", func() {
+			backend.AddImage("raw_RBD", 1024, "")
+
+			rr := commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd/raw_RBD/fsck", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			image, _ := backend.Image("raw_RBD")
+			So(image.Device, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,85 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// UpdateLabels merges labels of RBD with request body, a JSON object mapping keys to new values, or to null
+// for labels to be removed. RBD with resulting labels is returned.
+func (c *Context) UpdateLabels(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	patch := map[string]*string{}
+	if err := commonHttp.ReadJson(req, &patch); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	for key, value := range patch {
+		if err := storage.ValidateLabelKey(key); err != nil {
+			respond400(rw, req, err)
+			return
+		}
+		if value != nil {
+			if err := storage.ValidateLabelValue(*value); err != nil {
+				respond400(rw, req, err)
+				return
+			}
+		}
+	}
+
+	unlock, ok := c.lockImage(rw, req, name)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	ctx := requestContext(req)
+	info, err := c.Storage.ImageInfo(ctx, name)
+	if err != nil {
+		errNew := fmt.Errorf("cannot get RBD: %v", err)
+		if err == storage.ErrNotFound {
+			respond404(rw, req, errNew)
+			return
+		}
+		respond500(rw, req, errNew)
+		return
+	}
+	labels, err := c.Storage.UpdateImageLabels(ctx, name, patch)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot update labels of RBD: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, model.RBD{ImageName: info.Name, Size: info.Size / mebibyte, Labels: labels}, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
@@ -0,0 +1,134 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestLabels(t *testing.T) {
+	Convey("Testing RBD labels", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+
+		create := func(name string, labels map[string]string) int {
+			body, _ := json.Marshal(model.RBD{ImageName: name, Size: 100, FileSystem: model.EXT4, Labels: labels})
+			return commonHttp.SendRequestWithHeaders("POST", "/api/v1/rbd", body, router, authorizedHeader(), t).Code
+		}
+		list := func(query string) (int, []model.RBD) {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/rbd"+query, nil, router, authorizedHeader(), t)
+			images := []model.RBD{}
+			if rr.Code == http.StatusOK {
+				So(json.Unmarshal(rr.Body.Bytes(), &images), ShouldBeNil)
+			}
+			return rr.Code, images
+		}
+		names := func(images []model.RBD) []string {
+			result := []string{}
+			for _, image := range images {
+				result = append(result, image.ImageName)
+			}
+			return result
+		}
+
+		So(create("web", map[string]string{"env": "prod", "service": "web"}), ShouldEqual, http.StatusOK)
+		So(create("db", map[string]string{"env": "prod", "service": "db", "cost-center": "cc42"}), ShouldEqual, http.StatusOK)
+		So(create("scratch", nil), ShouldEqual, http.StatusOK)
+
+		Convey("Labels should be stored as image-meta", func() {
+			image, _ := backend.Image("web")
+			So(image.Meta, ShouldResemble, map[string]string{"label.env": "prod", "label.service": "web", "owner": testUser, "filesystem": model.EXT4})
+		})
+
+		Convey("Labels should be returned from get", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/rbd/db", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			rbd := model.RBD{}
+			So(json.Unmarshal(rr.Body.Bytes(), &rbd), ShouldBeNil)
+			So(rbd.Labels, ShouldResemble, map[string]string{"env": "prod", "service": "db", "cost-center": "cc42"})
+		})
+
+		Convey("Labels which are not labels of RBD should not be returned", func() {
+			_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", "scratch", "conf_rbd_cache", "false")
+			So(err, ShouldBeNil)
+
+			_, images := list("?selector=!env")
+
+			So(names(images), ShouldResemble, []string{"scratch"})
+			So(images[0].Labels, ShouldBeEmpty)
+		})
+
+		Convey("Images should be filtered with selector", func() {
+			status, images := list("")
+			So(status, ShouldEqual, http.StatusOK)
+			So(names(images), ShouldResemble, []string{"db", "scratch", "web"})
+
+			_, images = list("?selector=env%3Dprod,service!%3Ddb")
+			So(names(images), ShouldResemble, []string{"web"})
+			So(images[0].Labels, ShouldResemble, map[string]string{"env": "prod", "service": "web"})
+
+			_, images = list("?selector=cost-center")
+			So(names(images), ShouldResemble, []string{"db"})
+		})
+
+		Convey("Invalid selector should be rejected", func() {
+			status, _ := list("?selector=env%3Dprod%20env")
+
+			So(status, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("Invalid labels should be rejected on create", func() {
+			So(create("invalid", map[string]string{"bad key": "x"}), ShouldEqual, http.StatusBadRequest)
+			_, ok := backend.Image("invalid")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Labels should be patched", func() {
+			rr := commonHttp.SendRequestWithHeaders("PATCH", "/api/v1/rbd/db/labels", []byte(`{"env":"qa","cost-center":null,"owner":"alice"}`), router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			rbd := model.RBD{}
+			So(json.Unmarshal(rr.Body.Bytes(), &rbd), ShouldBeNil)
+			So(rbd.ImageName, ShouldEqual, "db")
+			So(rbd.Size, ShouldEqual, 100)
+			So(rbd.Labels, ShouldResemble, map[string]string{"env": "qa", "service": "db", "owner": "alice"})
+			image, _ := backend.Image("db")
+			So(image.Meta, ShouldResemble, map[string]string{"label.env": "qa", "label.service": "db", "label.owner": "alice", "owner": testUser, "filesystem": model.EXT4})
+		})
+
+		Convey("Invalid patch should be rejected", func() {
+			for _, body := range []string{`{"bad key":"x"}`, `{"env":"bad value"}`, `["env"]`} {
+				rr := commonHttp.SendRequestWithHeaders("PATCH", "/api/v1/rbd/db/labels", []byte(body), router, authorizedHeader(), t)
+				So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+
+		Convey("Labels of missing image should not be patched", func() {
+			rr := commonHttp.SendRequestWithHeaders("PATCH", "/api/v1/rbd/missing/labels", []byte(`{"env":"qa"}`), router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+	})
+}
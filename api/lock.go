@@ -27,109 +27,16 @@ import (
 	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
 )
 
-func filterNonemptyLines(input string) []string {
-	lines := strings.Split(string(input), "\n")
-	nonemptyLines := []string{}
-	for _, l := range lines {
-		// FIXME - workaround for Ceph warnings due to invalid ceph.conf
-		if strings.HasPrefix(l, "warning:") {
-			logger.Info("Skipping line:", l)
-			continue
-		}
-
-		if len(strings.TrimSpace(l)) > 0 {
-			nonemptyLines = append(nonemptyLines, l)
-		}
-	}
-	return nonemptyLines
-}
-
-func (c *Context) listImages() ([]string, error) {
-	logger.Debug("listImages")
-	output, err := c.OS.ExecuteCommand(rbdPath, "list")
-	if err != nil {
-		logger.Errorf("listImages: FAILED: %v", err)
-		return []string{}, err
-	}
-	logger.Debug("listImages: rbd output: ", string(output))
-	imageLines := filterNonemptyLines(output)
-	return imageLines, nil
-}
-
-func (c *Context) lockListForImage(imageName string) ([]model.Lock, error) {
-	logger.Debug("lockListForImage: getting locks for image", imageName)
-	out := []model.Lock{}
-	output, err := c.OS.ExecuteCommand(rbdPath, "lock", "list", imageName)
-	if err != nil {
-		logger.Errorf("lockListForImage: FAILED: %v", err)
-		return out, err
-	}
-	logger.Debug("lockListForImage: rbd output: ", string(output))
-	lockLines := filterNonemptyLines(output)
-
-	for i, nonemptyLockLine := range lockLines {
-		if i < 2 {
-			continue // skip header and 'There is 1 exclusive lock on this image.' line
-		}
-
-		fields := strings.Fields(nonemptyLockLine)
-		if len(fields) < 3 {
-			continue
-		}
-
-		lock := model.Lock{LockName: fields[1], ImageName: imageName, Locker: fields[0], Address: fields[2]}
-		/*
-		   There is 1 exclusive lock on this image.
-		   Locker      ID                                                         Address
-		   client.4239 kubelet_lock_magic_compute-worker-1.instance.cluster.local 10.0.2.190:0/3340152652
-		*/
-
-		out = append(out, lock)
-	}
-	logger.Info("locks: ", out)
-	return out, nil
-}
-
-func (c *Context) allLocks() ([]model.Lock, error) {
-	logger.Debug("allLocks")
-	locks := []model.Lock{}
-	images, err := c.listImages()
-	logger.Info("allLocks: images", images)
-	if err != nil {
-		return locks, err
-	}
-	for _, image := range images {
-		logger.Info("allLocks: getting locks for image", image)
-		imageLocks, err := c.lockListForImage(image)
-		if err != nil {
-			return locks, err
-		}
-		locks = append(locks, imageLocks...)
-	}
-	return locks, nil
-}
-
-func (c *Context) removeLock(lock model.Lock) error {
-	logger.Info("removeLock:", lock)
-	output, err := c.OS.ExecuteCommandCombinedOutput(rbdPath, "lock", "remove", lock.ImageName, lock.LockName, lock.Locker)
-	if err != nil {
-		logger.Error("removeLock: FAILED:", err, string(output))
-		return err
-	}
-	logger.Info("removeLock: SUCCESS.")
-	return nil
-}
-
 func (c *Context) ListLocks(rw web.ResponseWriter, req *web.Request) {
-	locks, err := c.allLocks()
+	locks, err := c.Storage.ListLocks(requestContext(req))
 	if err != nil {
-		commonHttp.Respond500(rw, err)
+		respond500(rw, req, err)
 		return
 	}
 
 	if err = commonHttp.WriteJson(rw, locks, http.StatusOK); err != nil {
 		err = fmt.Errorf("cannot parse response: %v", err)
-		commonHttp.Respond500(rw, err)
+		respond500(rw, req, err)
 		return
 	}
 
@@ -142,9 +49,9 @@ func (c *Context) DeleteLock(rw web.ResponseWriter, req *web.Request) {
 
 	lock := model.Lock{LockName: strings.Replace(lockName, "\"", "", -1), ImageName: imageName, Locker: locker}
 
-	err := c.removeLock(lock)
+	err := c.Storage.RemoveLock(requestContext(req), lock)
 	if err != nil {
-		commonHttp.Respond500(rw, err)
+		respond500(rw, req, err)
 		return
 	}
 
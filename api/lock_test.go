@@ -26,6 +26,7 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
 )
 
 const (
@@ -174,32 +175,51 @@ func createLockRow(locker, id, address string) string {
 	return fmt.Sprintf("%s %s %s", locker, id, address)
 }
 
+func TestListLocksOfImages(t *testing.T) {
+	Convey("Testing ListLocks with locked images", t, func() {
+		_, backend, client := prepareFakeAndClient(t)
+		backend.AddImage(sampleImage1, 100, model.EXT4)
+		backend.AddImage(sampleImage2, 100, model.EXT4)
+		backend.AddImage("unlocked", 100, model.EXT4)
+		backend.AddLock(sampleImage1, fake.Lock{ID: sampleID1, Locker: sampleLocker1, Address: sampleAddress1})
+		backend.AddLock(sampleImage2, fake.Lock{ID: sampleID2, Locker: sampleLocker2, Address: sampleAddress2})
+
+		locks, status, err := client.ListLocks()
+
+		So(status, ShouldEqual, http.StatusOK)
+		So(err, ShouldBeNil)
+		So(locks, ShouldResemble, []model.Lock{
+			{ImageName: sampleImage1, LockName: sampleID1, Locker: sampleLocker1, Address: sampleAddress1},
+			{ImageName: sampleImage2, LockName: sampleID2, Locker: sampleLocker2, Address: sampleAddress2},
+		})
+	})
+}
+
 func TestDeleteLock(t *testing.T) {
 	Convey("Testing DeleteLock", t, func() {
-		mockCtrl, _, mock, client := prepareMocksAndClient(t)
+		_, backend, client := prepareFakeAndClient(t)
+		lock := model.Lock{ImageName: sampleImage1, LockName: sampleID1, Locker: sampleLocker1, Address: sampleAddress1}
+		backend.AddImage(sampleImage1, 100, model.EXT4)
+		backend.AddLock(sampleImage1, fake.Lock{ID: sampleID1, Locker: sampleLocker1, Address: sampleAddress1})
 
 		Convey("When deleting lock exists", func() {
-			lock := model.Lock{ImageName: sampleImage1, LockName: sampleID1, Locker: sampleLocker1, Address: sampleAddress1}
-			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "lock", "remove", lock.ImageName, lock.LockName, lock.Locker).Return("", nil)
-
 			status, err := client.DeleteLock(lock)
 
 			So(status, ShouldEqual, http.StatusNoContent)
 			So(err, ShouldBeNil)
+			image, _ := backend.Image(sampleImage1)
+			So(image.Locks, ShouldBeEmpty)
 		})
 
 		Convey("When deleting lock return error", func() {
-			lock := model.Lock{ImageName: sampleImage1, LockName: sampleID1, Locker: sampleLocker1, Address: sampleAddress1}
-			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "lock", "remove", lock.ImageName, lock.LockName, lock.Locker).Return("", fmt.Errorf("some error"))
+			lock.Locker = sampleLocker2
 
 			status, err := client.DeleteLock(lock)
 
 			So(status, ShouldEqual, http.StatusInternalServerError)
 			So(err, ShouldNotBeNil)
-		})
-
-		Reset(func() {
-			mockCtrl.Finish()
+			image, _ := backend.Image(sampleImage1)
+			So(image.Locks, ShouldHaveLength, 1)
 		})
 	})
 }
@@ -0,0 +1,244 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/gocraft/web"
+	"gopkg.in/yaml.v2"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+)
+
+const (
+	manifestFormatYAML = "yaml"
+	manifestFormatJSON = "json"
+
+	defaultManifestSecret    = "ceph-secret"
+	defaultManifestUser      = "admin"
+	defaultManifestNamespace = "default"
+)
+
+var kubernetesNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+
+// manifestOptions are taken from query parameters of manifest request
+type manifestOptions struct {
+	Format       string
+	Name         string
+	FileSystem   string
+	AccessMode   string
+	Secret       string
+	User         string
+	StorageClass string
+	Claim        bool
+	Namespace    string
+}
+
+// kubernetesName converts RBD image name to a valid Kubernetes object name
+func kubernetesName(imageName string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, imageName)
+	return strings.Trim(name, "-.")
+}
+
+func parseManifestOptions(imageName string, query url.Values) (manifestOptions, error) {
+	get := func(key, defaultValue string) string {
+		if value := query.Get(key); value != "" {
+			return value
+		}
+		return defaultValue
+	}
+	options := manifestOptions{
+		Format:       get("format", manifestFormatYAML),
+		Name:         get("name", kubernetesName(imageName)),
+		FileSystem:   query.Get("fileSystem"),
+		AccessMode:   get("accessMode", model.AccessModeReadWriteOnce),
+		Secret:       get("secretName", defaultManifestSecret),
+		User:         get("user", defaultManifestUser),
+		StorageClass: query.Get("storageClass"),
+		Claim:        query.Get("claim") == "true",
+		Namespace:    get("namespace", defaultManifestNamespace),
+	}
+
+	if options.Format != manifestFormatYAML && options.Format != manifestFormatJSON {
+		return options, fmt.Errorf("format %q is not supported, use %s or %s", options.Format, manifestFormatYAML, manifestFormatJSON)
+	}
+	if options.FileSystem != "" {
+		if err := storage.ValidateFileSystem(options.FileSystem); err != nil {
+			return options, err
+		}
+	}
+	if options.AccessMode != model.AccessModeReadWriteOnce && options.AccessMode != model.AccessModeReadOnlyMany {
+		return options, fmt.Errorf("access mode %q is not supported by RBD volumes", options.AccessMode)
+	}
+	for _, name := range []string{options.Name, options.Secret, options.Namespace} {
+		if !kubernetesNamePattern.MatchString(name) {
+			return options, fmt.Errorf("%q is not a valid Kubernetes name", name)
+		}
+	}
+	if options.StorageClass != "" && !kubernetesNamePattern.MatchString(options.StorageClass) {
+		return options, fmt.Errorf("%q is not a valid Kubernetes name", options.StorageClass)
+	}
+	return options, nil
+}
+
+// persistentVolumeManifests renders PersistentVolume and, if requested, PersistentVolumeClaim bound to it
+func (c *Context) persistentVolumeManifests(rbd model.RBD, options manifestOptions) []interface{} {
+	capacity := fmt.Sprintf("%dMi", rbd.Size)
+	labels := map[string]string{"ceph-broker/image": kubernetesName(rbd.ImageName)}
+
+	pv := model.PersistentVolume{
+		APIVersion: "v1",
+		Kind:       "PersistentVolume",
+		Metadata:   model.ObjectMeta{Name: options.Name, Labels: labels},
+		Spec: model.PersistentVolumeSpec{
+			Capacity:                      map[string]string{"storage": capacity},
+			AccessModes:                   []string{options.AccessMode},
+			PersistentVolumeReclaimPolicy: "Retain",
+			StorageClassName:              options.StorageClass,
+			RBD: &model.RBDVolumeSource{
+				CephMonitors: c.Config.CephMonitors,
+				RBDImage:     rbd.ImageName,
+				FSType:       rbd.FileSystem,
+				RBDPool:      c.Config.CephPool,
+				RadosUser:    options.User,
+				SecretRef:    &model.LocalObjectReference{Name: options.Secret},
+				ReadOnly:     options.AccessMode == model.AccessModeReadOnlyMany,
+			},
+		},
+	}
+	if !options.Claim {
+		return []interface{}{pv}
+	}
+
+	pv.Spec.ClaimRef = &model.ObjectReference{Namespace: options.Namespace, Name: options.Name}
+	pvc := model.PersistentVolumeClaim{
+		APIVersion: "v1",
+		Kind:       "PersistentVolumeClaim",
+		Metadata:   model.ObjectMeta{Name: options.Name, Namespace: options.Namespace, Labels: labels},
+		Spec: model.PersistentVolumeClaimSpec{
+			AccessModes:      []string{options.AccessMode},
+			Resources:        model.ResourceRequirements{Requests: map[string]string{"storage": capacity}},
+			StorageClassName: &options.StorageClass,
+			VolumeName:       options.Name,
+		},
+	}
+	return []interface{}{pv, pvc}
+}
+
+// marshalYAML renders value as YAML. Field names and omitempty are taken from json tags: the value is encoded
+// as JSON, which is valid YAML, and decoded to ordered mapping, so field order is preserved as well.
+func marshalYAML(v interface{}) ([]byte, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	ordered := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &ordered); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(ordered)
+}
+
+func encodeManifests(manifests []interface{}, format string) ([]byte, string, error) {
+	if format == manifestFormatJSON {
+		var v interface{} = manifests[0]
+		if len(manifests) > 1 {
+			v = model.KubernetesList{APIVersion: "v1", Kind: "List", Items: manifests}
+		}
+		output, err := json.MarshalIndent(v, "", "  ")
+		return append(output, '\n'), "application/json", err
+	}
+
+	documents := []string{}
+	for _, manifest := range manifests {
+		output, err := marshalYAML(manifest)
+		if err != nil {
+			return nil, "", err
+		}
+		documents = append(documents, string(output))
+	}
+	return []byte(strings.Join(documents, "---\n")), "application/yaml", nil
+}
+
+// GetManifest renders Kubernetes PersistentVolume manifest for existing RBD image
+func (c *Context) GetManifest(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	options, err := parseManifestOptions(name, req.URL.Query())
+	if err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	ctx := requestContext(req)
+	info, err := c.Storage.ImageInfo(ctx, name)
+	if err != nil {
+		errNew := fmt.Errorf("cannot get RBD image info: %v", err)
+		if err == storage.ErrNotFound {
+			respond404(rw, req, errNew)
+			return
+		}
+		respond500(rw, req, errNew)
+		return
+	}
+	metadata, err := c.Storage.ImageMetadata(ctx, name)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get RBD image metadata: %v", err))
+		return
+	}
+
+	// file system recorded when the image was formatted by the broker takes precedence over the parameter
+	fileSystem := metadata.FileSystem
+	switch {
+	case fileSystem != "" && options.FileSystem != "" && options.FileSystem != fileSystem:
+		respondError(rw, req, http.StatusConflict, fmt.Errorf("RBD %q is formatted with %s, not %s", name, fileSystem, options.FileSystem))
+		return
+	case fileSystem == "" && options.FileSystem == "":
+		respond400(rw, req, fmt.Errorf("file system of RBD %q is not known, give it with fileSystem parameter", name))
+		return
+	case fileSystem == "":
+		fileSystem = options.FileSystem
+	}
+
+	rbd := model.RBD{ImageName: name, Size: info.Size / mebibyte, FileSystem: fileSystem}
+	output, contentType, err := encodeManifests(c.persistentVolumeManifests(rbd, options), options.Format)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot render manifest: %v", err))
+		return
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(output)
+}
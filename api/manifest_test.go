@@ -0,0 +1,153 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const expectedManifestYAML = `apiVersion: v1
+kind: PersistentVolume
+metadata:
+  name: sample-rbd
+  labels:
+    ceph-broker/image: sample-rbd
+spec:
+  capacity:
+    storage: 1024Mi
+  accessModes:
+  - ReadWriteOnce
+  persistentVolumeReclaimPolicy: Retain
+  claimRef:
+    namespace: apps
+    name: sample-rbd
+  rbd:
+    monitors:
+    - 10.0.0.1:6789
+    - 10.0.0.2:6789
+    image: sample_RBD
+    fsType: xfs
+    pool: rbd
+    user: admin
+    secretRef:
+      name: ceph-secret
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: sample-rbd
+  namespace: apps
+  labels:
+    ceph-broker/image: sample-rbd
+spec:
+  accessModes:
+  - ReadWriteOnce
+  resources:
+    requests:
+      storage: 1024Mi
+  storageClassName: ""
+  volumeName: sample-rbd
+`
+
+func TestGetManifest(t *testing.T) {
+	Convey("Testing GetManifest", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		c.Config.CephMonitors = []string{"10.0.0.1:6789", "10.0.0.2:6789"}
+		router := SetupRouter(&c)
+		sampleName := "sample_RBD"
+		path := "/api/v1/rbd/" + sampleName + "/manifest"
+		backend.AddImage(sampleName, 1024, model.XFS)
+		_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", sampleName, "filesystem", model.XFS)
+		So(err, ShouldBeNil)
+
+		Convey("When YAML manifest with claim is requested", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", path+"?fileSystem=xfs&claim=true&namespace=apps", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Header().Get("Content-Type"), ShouldEqual, "application/yaml")
+			So(rr.Body.String(), ShouldEqual, expectedManifestYAML)
+		})
+
+		Convey("When JSON manifest is requested", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", path+"?format=json&accessMode=ReadOnlyMany&name=data", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			pv := model.PersistentVolume{}
+			So(json.Unmarshal(rr.Body.Bytes(), &pv), ShouldBeNil)
+			So(pv.Kind, ShouldEqual, "PersistentVolume")
+			So(pv.Metadata.Name, ShouldEqual, "data")
+			So(pv.Spec.Capacity["storage"], ShouldEqual, "1024Mi")
+			So(pv.Spec.RBD.FSType, ShouldEqual, model.XFS)
+			So(pv.Spec.RBD.ReadOnly, ShouldBeTrue)
+			So(pv.Spec.ClaimRef, ShouldBeNil)
+		})
+
+		Convey("When file system parameter conflicts with file system of image", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", path+"?fileSystem=ext4", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When file system of image is not known", func() {
+			backend.AddImage("legacy", 100, model.EXT4)
+			legacyPath := "/api/v1/rbd/legacy/manifest?format=json"
+
+			So(commonHttp.SendRequestWithHeaders("GET", legacyPath, nil, router, authorizedHeader(), t).Code, ShouldEqual, http.StatusBadRequest)
+
+			rr := commonHttp.SendRequestWithHeaders("GET", legacyPath+"&fileSystem=ext4", nil, router, authorizedHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			pv := model.PersistentVolume{}
+			So(json.Unmarshal(rr.Body.Bytes(), &pv), ShouldBeNil)
+			So(pv.Spec.RBD.FSType, ShouldEqual, model.EXT4)
+		})
+
+		Convey("When image does not exist", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/rbd/other/manifest", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When options are invalid", func() {
+			for _, query := range []string{"format=xml", "fileSystem=ntfs", "accessMode=ReadWriteMany", "name=Bad_Name"} {
+				rr := commonHttp.SendRequestWithHeaders("GET", path+"?"+query, nil, router, authorizedHeader(), t)
+
+				So(rr.Code, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
+
+func TestKubernetesName(t *testing.T) {
+	testCases := map[string]string{
+		"volume":       "volume",
+		"test_Volume":  "test-volume",
+		"_data.1_":     "data.1",
+		"osb-instance": "osb-instance",
+	}
+	for input, expected := range testCases {
+		if name := kubernetesName(input); name != expected {
+			t.Errorf("kubernetesName(%q) = %q, expected %q", input, name, expected)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+// mapping describes image mapped by the broker. Orphaned mappings are no longer used by any operation.
+type mapping struct {
+	Device   string `json:"device"`
+	Orphaned bool   `json:"orphaned"`
+}
+
+// imageOperationRetention is time for which state of finished rename or copy can be queried
+const imageOperationRetention = time.Hour
+
+// OperationTracker keeps track of in-flight mutating operations, RBD images mapped by the broker,
+// images used by operations which have to be serialized, state of rename and copy operations
+// and of asynchronous provisioning of OSB service instances
+type OperationTracker struct {
+	mutex           sync.Mutex
+	inFlight        int
+	mapped          map[string]mapping
+	stateFile       string
+	lastReconcile   model.ReconcileReport
+	locked          map[string]string
+	imageOperations map[string]model.ImageOperation
+	osbOperations   map[string]osbOperation
+}
+
+// NewOperationTracker returns empty OperationTracker
+func NewOperationTracker() *OperationTracker {
+	return &OperationTracker{
+		mapped:          map[string]mapping{},
+		locked:          map[string]string{},
+		imageOperations: map[string]model.ImageOperation{},
+		osbOperations:   map[string]osbOperation{},
+	}
+}
+
+// NewPersistentOperationTracker returns OperationTracker which stores mapped images in stateFile.
+// Images found in existing state file were mapped by previous broker process and are marked as orphaned.
+func NewPersistentOperationTracker(stateFile string) (*OperationTracker, error) {
+	t := NewOperationTracker()
+	t.stateFile = stateFile
+
+	content, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err = json.Unmarshal(content, &t.mapped); err != nil {
+			return nil, err
+		}
+	}
+	for image, m := range t.mapped {
+		m.Orphaned = true
+		t.mapped[image] = m
+	}
+	return t, nil
+}
+
+// save has to be called with mutex locked
+func (t *OperationTracker) save() {
+	if t.stateFile == "" {
+		return
+	}
+	content, err := json.Marshal(t.mapped)
+	if err != nil {
+		logger.Errorf("cannot marshal mapped images state: %v", err)
+		return
+	}
+	tmpFile := filepath.Join(filepath.Dir(t.stateFile), "."+filepath.Base(t.stateFile)+".tmp")
+	if err = ioutil.WriteFile(tmpFile, content, 0600); err != nil {
+		logger.Errorf("cannot write mapped images state to %q: %v", tmpFile, err)
+		return
+	}
+	if err = os.Rename(tmpFile, t.stateFile); err != nil {
+		logger.Errorf("cannot replace mapped images state file %q: %v", t.stateFile, err)
+	}
+}
+
+func (t *OperationTracker) begin() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inFlight++
+}
+
+func (t *OperationTracker) end() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.inFlight--
+}
+
+// MappedImage records image mapped by the broker
+func (t *OperationTracker) MappedImage(imageName, device string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.mapped[imageName] = mapping{Device: device}
+	t.save()
+}
+
+// UnmappedImage forgets image unmapped by the broker
+func (t *OperationTracker) UnmappedImage(imageName string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.mapped, imageName)
+	t.save()
+}
+
+// unmappedOrphan forgets image unmapped by reconciliation, unless it was mapped again by another operation
+// after its orphaned device was listed
+func (t *OperationTracker) unmappedOrphan(imageName, device string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if m, ok := t.mapped[imageName]; ok && m.Orphaned && m.Device == device {
+		delete(t.mapped, imageName)
+		t.save()
+	}
+}
+
+// ReleaseImage marks image as orphaned if operation which mapped it did not unmap it
+func (t *OperationTracker) ReleaseImage(imageName string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if m, ok := t.mapped[imageName]; ok {
+		m.Orphaned = true
+		t.mapped[imageName] = m
+		t.save()
+	}
+}
+
+// InFlight returns number of mutating operations in progress
+func (t *OperationTracker) InFlight() int {
+	if t == nil {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.inFlight
+}
+
+// MappedImages returns sorted names of images currently mapped by the broker
+func (t *OperationTracker) MappedImages() []string {
+	images := []string{}
+	if t == nil {
+		return images
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for image := range t.mapped {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	return images
+}
+
+// orphanedImages returns devices of images which were mapped by the broker and are not used by any operation
+func (t *OperationTracker) orphanedImages() map[string]string {
+	orphaned := map[string]string{}
+	if t == nil {
+		return orphaned
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for image, m := range t.mapped {
+		if m.Orphaned {
+			orphaned[image] = m.Device
+		}
+	}
+	return orphaned
+}
+
+func (t *OperationTracker) setLastReconcile(report model.ReconcileReport) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastReconcile = report
+}
+
+func (t *OperationTracker) getLastReconcile() model.ReconcileReport {
+	if t == nil {
+		return model.ReconcileReport{}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.lastReconcile
+}
+
+// lockImages reserves images for operation, so that other operations on them fail until returned function is called.
+// Error is returned if any of the images is used by another operation.
+func (t *OperationTracker) lockImages(operation string, images ...string) (func(), error) {
+	if t == nil {
+		return func() {}, nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, image := range images {
+		if holder, ok := t.locked[image]; ok {
+			return nil, fmt.Errorf("RBD %q is used by %s", image, holder)
+		}
+	}
+	for _, image := range images {
+		t.locked[image] = operation
+	}
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		for _, image := range images {
+			delete(t.locked, image)
+		}
+	}, nil
+}
+
+// lockImage reserves image for the request, responding with status 409 if it is used by another operation
+func (c *Context) lockImage(rw web.ResponseWriter, req *web.Request, name string) (func(), bool) {
+	unlock, err := c.Operations.lockImages("request "+RequestIDFromRequest(req), name)
+	if err != nil {
+		respondError(rw, req, http.StatusConflict, err)
+		return nil, false
+	}
+	return unlock, true
+}
+
+// setImageOperation records state of rename or copy, forgetting operations finished before retention period
+func (t *OperationTracker) setImageOperation(operation model.ImageOperation) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for id, o := range t.imageOperations {
+		if o.FinishedAt != nil && time.Since(*o.FinishedAt) > imageOperationRetention {
+			delete(t.imageOperations, id)
+		}
+	}
+	t.imageOperations[operation.ID] = operation
+}
+
+func (t *OperationTracker) getImageOperation(id string) (model.ImageOperation, bool) {
+	if t == nil {
+		return model.ImageOperation{}, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	operation, ok := t.imageOperations[id]
+	return operation, ok
+}
+
+func (t *OperationTracker) setOSBOperation(instanceID string, operation osbOperation) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.osbOperations[instanceID] = operation
+}
+
+func (t *OperationTracker) getOSBOperation(instanceID string) (osbOperation, bool) {
+	if t == nil {
+		return osbOperation{}, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	operation, ok := t.osbOperations[instanceID]
+	return operation, ok
+}
+
+func (t *OperationTracker) removeOSBOperation(instanceID string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.osbOperations, instanceID)
+}
+
+// TrackOperationsMiddleware counts in-flight mutating requests
+func (c *Context) TrackOperationsMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if req.Method == http.MethodGet {
+		next(rw, req)
+		return
+	}
+	c.Operations.begin()
+	defer c.Operations.end()
+	next(rw, req)
+}
+
+// UnmapAll unmaps every image left mapped by the broker. It returns names of unmapped images
+// and errors for images which could not be unmapped.
+func (c *Context) UnmapAll() ([]string, map[string]error) {
+	unmapped := []string{}
+	failed := map[string]error{}
+	ctx := backgroundContext()
+	for _, image := range c.Operations.MappedImages() {
+		if err := c.Storage.UnmapImage(ctx, image); err != nil {
+			failed[image] = err
+			continue
+		}
+		unmapped = append(unmapped, image)
+	}
+	return unmapped, failed
+}
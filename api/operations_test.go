@@ -0,0 +1,61 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func TestOperationTracker(t *testing.T) {
+	Convey("Testing tracking of mapped images", t, func() {
+		c, backend, client := prepareFakeAndClient(t)
+		sampleName := "sampleRBD"
+		device := model.RBD{ImageName: sampleName, Size: 100, FileSystem: model.EXT4}
+
+		Convey("When RBD is created successfully no image is left mapped", func() {
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(c.Operations.MappedImages(), ShouldBeEmpty)
+			So(c.Operations.InFlight(), ShouldEqual, 0)
+		})
+
+		Convey("When format fails image is left mapped and unmapped by UnmapAll", func() {
+			backend.Fail("", fmt.Errorf("some error"), "mkfs."+model.EXT4)
+
+			client.CreateRBD(device)
+			So(c.Operations.MappedImages(), ShouldResemble, []string{sampleName})
+			image, _ := backend.Image(sampleName)
+			So(image.Device, ShouldNotBeEmpty)
+
+			unmapped, failed := c.UnmapAll()
+
+			So(unmapped, ShouldResemble, []string{sampleName})
+			So(failed, ShouldBeEmpty)
+			So(c.Operations.MappedImages(), ShouldBeEmpty)
+			image, _ = backend.Image(sampleName)
+			So(image.Device, ShouldBeEmpty)
+		})
+	})
+}
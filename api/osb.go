@@ -0,0 +1,394 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const (
+	osbAPIVersionHeader   = "X-Broker-API-Version"
+	osbServiceID          = "5b4ec0f6-4b3f-4a7c-9a7e-3d2f1c6e8a01"
+	osbImagePrefix        = "osb-"
+	osbCephUserPrefix     = "client.osb-"
+	osbOperationProvision = "provision"
+)
+
+// osbPlan binds Open Service Broker API plan to RBD size and file system preset
+type osbPlan struct {
+	model.Plan
+	Size       uint64
+	FileSystem string
+}
+
+var osbPlans = []osbPlan{
+	newOSBPlan("b6a1a0b5-1c55-4d5e-8f3a-6f0e2c9d7a11", "ext4-1gb", 1024, model.EXT4),
+	newOSBPlan("c2d4e6f8-2a4c-4e6a-8c0e-7a1b3c5d7e22", "ext4-10gb", 10*1024, model.EXT4),
+	newOSBPlan("d3e5f7a9-3b5d-4f7b-9d1f-8b2c4d6e8f33", "xfs-10gb", 10*1024, model.XFS),
+	newOSBPlan("e4f6a8b0-4c6e-4a8c-ae2a-9c3d5e7f9a44", "xfs-100gb", 100*1024, model.XFS),
+}
+
+func newOSBPlan(id, name string, size uint64, fs string) osbPlan {
+	return osbPlan{
+		Plan: model.Plan{
+			ID:          id,
+			Name:        name,
+			Description: fmt.Sprintf("%d MB RBD volume formatted with %s", size, fs),
+			Free:        true,
+			Metadata:    map[string]interface{}{"size": size, "fileSystem": fs},
+		},
+		Size:       size,
+		FileSystem: fs,
+	}
+}
+
+func findOSBPlan(id string) (osbPlan, bool) {
+	for _, plan := range osbPlans {
+		if plan.ID == id {
+			return plan, true
+		}
+	}
+	return osbPlan{}, false
+}
+
+var osbIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,99}$`)
+
+func validateOSBID(id string) error {
+	if !osbIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid identifier %q", id)
+	}
+	return nil
+}
+
+func osbImageName(instanceID string) string {
+	return osbImagePrefix + instanceID
+}
+
+func osbCephUser(bindingID string) string {
+	return osbCephUserPrefix + bindingID
+}
+
+// osbOperation is state of asynchronous provisioning of service instance with plan
+type osbOperation struct {
+	model.LastOperation
+	PlanID string
+}
+
+// checkProvisioned returns whether image of service instance exists and matches the plan, it fails if the image does
+// not match. Image without recorded file system is left by provisioning which failed before formatting, it is moved
+// to trash so that the instance can be provisioned again.
+func (c *Context) checkProvisioned(ctx context.Context, imageName string, plan osbPlan) (bool, int, error) {
+	info, err := c.Storage.ImageInfo(ctx, imageName)
+	if err == storage.ErrNotFound {
+		return false, http.StatusOK, nil
+	}
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+	metadata, err := c.Storage.ImageMetadata(ctx, imageName)
+	if err != nil {
+		return false, http.StatusInternalServerError, err
+	}
+	if metadata.FileSystem == "" {
+		if err = c.unmapOrphaned(ctx, imageName); err != nil {
+			return false, http.StatusInternalServerError, err
+		}
+		if err = c.Storage.CheckNotInUse(ctx, imageName); err != nil {
+			if _, ok := err.(*storage.BusyError); ok {
+				return false, http.StatusConflict, err
+			}
+			return false, http.StatusInternalServerError, err
+		}
+		if err = c.Storage.TrashImage(ctx, imageName, c.Config.TrashDeferment); err != nil {
+			return false, http.StatusInternalServerError, fmt.Errorf("cannot remove unformatted image of service instance: %v", err)
+		}
+		return false, http.StatusOK, nil
+	}
+	if size := info.Size / mebibyte; size != plan.Size {
+		return false, http.StatusConflict, fmt.Errorf("service instance exists with size %d MB, plan %q requires %d MB", size, plan.Name, plan.Size)
+	}
+	if metadata.FileSystem != plan.FileSystem {
+		return false, http.StatusConflict, fmt.Errorf("service instance exists formatted with %s, plan %q requires %s", metadata.FileSystem, plan.Name, plan.FileSystem)
+	}
+	return true, http.StatusOK, nil
+}
+
+// lockOSBInstance reserves image of service instance for the request, responding with status 422 if it is used by
+// another operation. Repeated provisioning with the same plan is accepted while asynchronous provisioning is running.
+func (c *Context) lockOSBInstance(rw web.ResponseWriter, req *web.Request, instanceID, planID string) (func(), bool) {
+	unlock, err := c.Operations.lockImages("request "+RequestIDFromRequest(req), osbImageName(instanceID))
+	if err == nil {
+		return unlock, true
+	}
+	if operation, ok := c.Operations.getOSBOperation(instanceID); ok && operation.State == model.OperationInProgress {
+		switch {
+		case planID == "":
+			respondOSB(rw, req, http.StatusUnprocessableEntity, "ConcurrencyError", errors.New("provisioning is in progress"))
+		case operation.PlanID != planID:
+			respondOSB(rw, req, http.StatusConflict, "", fmt.Errorf("service instance is being provisioned with plan %q", operation.PlanID))
+		default:
+			commonHttp.WriteJson(rw, model.ProvisionResponse{Operation: osbOperationProvision}, http.StatusAccepted)
+		}
+		return nil, false
+	}
+	respondOSB(rw, req, http.StatusUnprocessableEntity, "ConcurrencyError", err)
+	return nil, false
+}
+
+func respondOSB(rw web.ResponseWriter, req *web.Request, code int, errorCode string, err error) {
+	code = requestStatus(code, err)
+	newRequestLogger(RequestIDFromRequest(req)).Errorf("OSB respond %d, reason: %v", code, err)
+	commonHttp.WriteJson(rw, model.OSBError{Error: errorCode, Description: err.Error()}, code)
+}
+
+// OSBVersionMiddleware rejects requests without supported Open Service Broker API version
+func (c *Context) OSBVersionMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	version := req.Header.Get(osbAPIVersionHeader)
+	if !strings.HasPrefix(version, "2.") {
+		respondOSB(rw, req, http.StatusPreconditionFailed, "", fmt.Errorf("unsupported %s: %q, 2.x is required", osbAPIVersionHeader, version))
+		return
+	}
+	next(rw, req)
+}
+
+// GetCatalog returns service and plans offered by the broker
+func (c *Context) GetCatalog(rw web.ResponseWriter, req *web.Request) {
+	plans := []model.Plan{}
+	for _, plan := range osbPlans {
+		plans = append(plans, plan.Plan)
+	}
+	catalog := model.Catalog{Services: []model.Service{{
+		ID:          osbServiceID,
+		Name:        "ceph-rbd",
+		Description: "Ceph RBD volume formatted with a file system",
+		Bindable:    true,
+		Tags:        []string{"ceph", "rbd", "volume"},
+		Plans:       plans,
+	}}}
+	commonHttp.WriteJson(rw, catalog, http.StatusOK)
+}
+
+// Provision creates and formats RBD image for service instance. Image left unformatted by failed provisioning is
+// replaced.
+func (c *Context) Provision(rw web.ResponseWriter, req *web.Request) {
+	instanceID := req.PathParams["instanceID"]
+	if err := validateOSBID(instanceID); err != nil {
+		respondOSB(rw, req, http.StatusBadRequest, "", err)
+		return
+	}
+	input := model.ProvisionRequest{}
+	if err := commonHttp.ReadJson(req, &input); err != nil {
+		respondOSB(rw, req, http.StatusBadRequest, "", err)
+		return
+	}
+	if input.ServiceID != osbServiceID {
+		respondOSB(rw, req, http.StatusBadRequest, "", fmt.Errorf("unknown service %q", input.ServiceID))
+		return
+	}
+	plan, ok := findOSBPlan(input.PlanID)
+	if !ok {
+		respondOSB(rw, req, http.StatusBadRequest, "", fmt.Errorf("unknown plan %q", input.PlanID))
+		return
+	}
+
+	ctx := requestContext(req)
+	rbd := model.RBD{ImageName: osbImageName(instanceID), Size: plan.Size, FileSystem: plan.FileSystem}
+	unlock, ok := c.lockOSBInstance(rw, req, instanceID, plan.ID)
+	if !ok {
+		return
+	}
+	provisioned, code, err := c.checkProvisioned(ctx, rbd.ImageName, plan)
+	if err != nil {
+		unlock()
+		respondOSB(rw, req, code, "", err)
+		return
+	}
+	if provisioned {
+		unlock()
+		commonHttp.WriteJson(rw, model.ProvisionResponse{}, http.StatusOK)
+		return
+	}
+
+	if req.URL.Query().Get("accepts_incomplete") != "true" {
+		defer unlock()
+		if _, err := c.Storage.CreateImage(ctx, rbd); err != nil {
+			if _, ok := err.(*storage.CapacityError); ok {
+				respondOSB(rw, req, http.StatusInsufficientStorage, "", err)
+				return
+			}
+			respondOSB(rw, req, http.StatusInternalServerError, "", err)
+			return
+		}
+		commonHttp.WriteJson(rw, model.ProvisionResponse{}, http.StatusCreated)
+		return
+	}
+
+	c.Operations.setOSBOperation(instanceID, osbOperation{model.LastOperation{State: model.OperationInProgress}, plan.ID})
+	c.Operations.begin()
+	log := newRequestLogger(RequestIDFromRequest(req))
+	go func() {
+		defer c.Operations.end()
+		defer unlock()
+		// request context is cancelled when the response is sent
+		if _, err := c.Storage.CreateImage(storage.WithLogger(context.Background(), log), rbd); err != nil {
+			log.Errorf("Provision: instance %q FAILED: %v", instanceID, err)
+			c.Operations.setOSBOperation(instanceID, osbOperation{model.LastOperation{State: model.OperationFailed, Description: err.Error()}, plan.ID})
+			return
+		}
+		c.Operations.setOSBOperation(instanceID, osbOperation{model.LastOperation{State: model.OperationSucceeded}, plan.ID})
+	}()
+	commonHttp.WriteJson(rw, model.ProvisionResponse{Operation: osbOperationProvision}, http.StatusAccepted)
+}
+
+// Deprovision moves RBD image of service instance to trash, unless it is used by any client
+func (c *Context) Deprovision(rw web.ResponseWriter, req *web.Request) {
+	instanceID := req.PathParams["instanceID"]
+	if err := validateOSBID(instanceID); err != nil {
+		respondOSB(rw, req, http.StatusBadRequest, "", err)
+		return
+	}
+	unlock, ok := c.lockOSBInstance(rw, req, instanceID, "")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	ctx := requestContext(req)
+	imageName := osbImageName(instanceID)
+	err := c.Storage.CheckNotInUse(ctx, imageName)
+	if _, ok := err.(*storage.BusyError); ok {
+		respondOSB(rw, req, http.StatusConflict, "", err)
+		return
+	}
+	if err == nil {
+		err = c.Storage.TrashImage(ctx, imageName, c.Config.TrashDeferment)
+	}
+	if err != nil {
+		if err == storage.ErrNotFound {
+			commonHttp.WriteJson(rw, struct{}{}, http.StatusGone)
+			return
+		}
+		respondOSB(rw, req, http.StatusInternalServerError, "", err)
+		return
+	}
+	c.Operations.removeOSBOperation(instanceID)
+	commonHttp.WriteJson(rw, struct{}{}, http.StatusOK)
+}
+
+// GetLastOperation returns state of asynchronous provisioning of service instance. The state is kept in memory only,
+// so after restart of the broker it is derived from the image: provisioning succeeded if the image was formatted.
+func (c *Context) GetLastOperation(rw web.ResponseWriter, req *web.Request) {
+	instanceID := req.PathParams["instanceID"]
+	if operation, ok := c.Operations.getOSBOperation(instanceID); ok {
+		commonHttp.WriteJson(rw, operation.LastOperation, http.StatusOK)
+		return
+	}
+
+	metadata, err := c.Storage.ImageMetadata(requestContext(req), osbImageName(instanceID))
+	if err == storage.ErrNotFound {
+		respondOSB(rw, req, http.StatusGone, "", errors.New("no operation for service instance"))
+		return
+	}
+	if err != nil {
+		respondOSB(rw, req, http.StatusInternalServerError, "", err)
+		return
+	}
+	operation := model.LastOperation{State: model.OperationSucceeded}
+	if metadata.FileSystem == "" {
+		operation = model.LastOperation{State: model.OperationFailed, Description: "provisioning was interrupted before the image was formatted"}
+	}
+	commonHttp.WriteJson(rw, operation, http.StatusOK)
+}
+
+// Bind creates Ceph user scoped to RBD image and returns credentials for the RBD image of service instance
+func (c *Context) Bind(rw web.ResponseWriter, req *web.Request) {
+	instanceID := req.PathParams["instanceID"]
+	bindingID := req.PathParams["bindingID"]
+	for _, id := range []string{instanceID, bindingID} {
+		if err := validateOSBID(id); err != nil {
+			respondOSB(rw, req, http.StatusBadRequest, "", err)
+			return
+		}
+	}
+	input := model.BindRequest{}
+	if err := commonHttp.ReadJson(req, &input); err != nil {
+		respondOSB(rw, req, http.StatusBadRequest, "", err)
+		return
+	}
+	plan, ok := findOSBPlan(input.PlanID)
+	if !ok {
+		respondOSB(rw, req, http.StatusBadRequest, "", fmt.Errorf("unknown plan %q", input.PlanID))
+		return
+	}
+
+	ctx := requestContext(req)
+	imageName := osbImageName(instanceID)
+	if _, err := c.Storage.ImageInfo(ctx, imageName); err == storage.ErrNotFound {
+		respondOSB(rw, req, http.StatusNotFound, "", fmt.Errorf("service instance %q does not exist", instanceID))
+		return
+	} else if err != nil {
+		respondOSB(rw, req, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	user := osbCephUser(bindingID)
+	key, err := c.Storage.CreateCephUser(ctx, user, imageName)
+	if err != nil {
+		respondOSB(rw, req, http.StatusInternalServerError, "", fmt.Errorf("cannot create Ceph user %q: %v", user, err))
+		return
+	}
+
+	credentials := model.RBDCredentials{
+		Pool:       c.Config.CephPool,
+		ImageName:  imageName,
+		FileSystem: plan.FileSystem,
+		Monitors:   c.Config.CephMonitors,
+		User:       strings.TrimPrefix(user, "client."),
+		Key:        key,
+	}
+	commonHttp.WriteJson(rw, model.BindResponse{Credentials: credentials}, http.StatusCreated)
+}
+
+// Unbind removes Ceph user of service binding
+func (c *Context) Unbind(rw web.ResponseWriter, req *web.Request) {
+	bindingID := req.PathParams["bindingID"]
+	if err := validateOSBID(bindingID); err != nil {
+		respondOSB(rw, req, http.StatusBadRequest, "", err)
+		return
+	}
+
+	if err := c.Storage.DeleteCephUser(requestContext(req), osbCephUser(bindingID)); err != nil {
+		if err == storage.ErrNotFound {
+			commonHttp.WriteJson(rw, struct{}{}, http.StatusGone)
+			return
+		}
+		respondOSB(rw, req, http.StatusInternalServerError, "", err)
+		return
+	}
+	commonHttp.WriteJson(rw, struct{}{}, http.StatusOK)
+}
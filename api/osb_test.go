@@ -0,0 +1,326 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func osbHeader() http.Header {
+	header := authorizedHeader()
+	header.Set(osbAPIVersionHeader, "2.11")
+	return header
+}
+
+func osbBody(v interface{}) []byte {
+	body, _ := json.Marshal(v)
+	return body
+}
+
+func recordFileSystem(backend *fake.OS, name, fs string) {
+	_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", name, "filesystem", fs)
+	So(err, ShouldBeNil)
+}
+
+func TestOSBCatalog(t *testing.T) {
+	Convey("Testing OSB catalog", t, func() {
+		c, _, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+
+		Convey("When API version header is given", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/v2/catalog", nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			catalog := model.Catalog{}
+			So(json.Unmarshal(rr.Body.Bytes(), &catalog), ShouldBeNil)
+			So(catalog.Services, ShouldHaveLength, 1)
+			So(catalog.Services[0].ID, ShouldEqual, osbServiceID)
+			So(catalog.Services[0].Plans, ShouldHaveLength, len(osbPlans))
+		})
+
+		Convey("When API version header is missing", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/v2/catalog", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusPreconditionFailed)
+		})
+
+		Convey("When credentials are missing", func() {
+			header := http.Header{}
+			header.Set(osbAPIVersionHeader, "2.11")
+			rr := commonHttp.SendRequestWithHeaders("GET", "/v2/catalog", nil, router, header, t)
+
+			So(rr.Code, ShouldEqual, http.StatusUnauthorized)
+		})
+	})
+}
+
+func TestOSBProvision(t *testing.T) {
+	Convey("Testing OSB provision", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		plan := osbPlans[0]
+		imageName := osbImageName("instance-1")
+		path := "/v2/service_instances/instance-1"
+		body := osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: plan.ID})
+
+		Convey("When image is created synchronously", func() {
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+			image, ok := backend.Image(imageName)
+			So(ok, ShouldBeTrue)
+			So(image.Size, ShouldEqual, plan.Size)
+			So(image.FileSystem, ShouldEqual, plan.FileSystem)
+		})
+
+		Convey("When image is created asynchronously", func() {
+			backend.Fail("", fmt.Errorf("some error!"), "rbd", "create")
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path+"?accepts_incomplete=true", body, router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusAccepted)
+
+			operation := model.LastOperation{}
+			for i := 0; i < 100; i++ {
+				rr = commonHttp.SendRequestWithHeaders("GET", path+"/last_operation", nil, router, osbHeader(), t)
+				So(rr.Code, ShouldEqual, http.StatusOK)
+				So(json.Unmarshal(rr.Body.Bytes(), &operation), ShouldBeNil)
+				if operation.State != model.OperationInProgress {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			So(operation.State, ShouldEqual, model.OperationFailed)
+			So(operation.Description, ShouldContainSubstring, "some error!")
+		})
+
+		Convey("When image already exists", func() {
+			backend.AddImage(imageName, plan.Size, plan.FileSystem)
+			recordFileSystem(backend, imageName, plan.FileSystem)
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("When image exists with other size", func() {
+			backend.AddImage(imageName, osbPlans[1].Size, plan.FileSystem)
+			recordFileSystem(backend, imageName, plan.FileSystem)
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When image exists with other file system", func() {
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: osbPlans[1].ID}), router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+
+			rr = commonHttp.SendRequestWithHeaders("PUT", path, osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: osbPlans[2].ID}), router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("When formatting failed", func() {
+			backend.Fail("", fmt.Errorf("some error!"), "mkfs."+plan.FileSystem)
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			image, _ := backend.Image(imageName)
+			So(image.FileSystem, ShouldBeEmpty)
+
+			Convey("Provisioning is repeated", func() {
+				rr = commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+				So(rr.Code, ShouldEqual, http.StatusCreated)
+				image, _ = backend.Image(imageName)
+				So(image.FileSystem, ShouldEqual, plan.FileSystem)
+				So(image.Meta["filesystem"], ShouldEqual, plan.FileSystem)
+			})
+		})
+
+		Convey("When provisioning is in progress", func() {
+			unlock, err := c.Operations.lockImages("test operation", imageName)
+			So(err, ShouldBeNil)
+			defer unlock()
+			c.Operations.setOSBOperation("instance-1", osbOperation{model.LastOperation{State: model.OperationInProgress}, plan.ID})
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path+"?accepts_incomplete=true", body, router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusAccepted)
+
+			rr = commonHttp.SendRequestWithHeaders("PUT", path+"?accepts_incomplete=true", osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: osbPlans[1].ID}), router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+
+			rr = commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			_, ok := backend.Image(imageName)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When image is used by another operation", func() {
+			unlock, err := c.Operations.lockImages("test operation", imageName)
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			_, ok := backend.Image(imageName)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When broker restarted after provisioning", func() {
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+			c.Operations = NewOperationTracker()
+			router = SetupRouter(&c)
+
+			rr = commonHttp.SendRequestWithHeaders("GET", path+"/last_operation", nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			operation := model.LastOperation{}
+			So(json.Unmarshal(rr.Body.Bytes(), &operation), ShouldBeNil)
+			So(operation.State, ShouldEqual, model.OperationSucceeded)
+		})
+
+		Convey("When plan is unknown", func() {
+			body := osbBody(model.ProvisionRequest{ServiceID: osbServiceID, PlanID: "unknown"})
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When instance id is invalid", func() {
+			rr := commonHttp.SendRequestWithHeaders("PUT", "/v2/service_instances/bad%20id", body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestOSBDeprovision(t *testing.T) {
+	Convey("Testing OSB deprovision", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		imageName := osbImageName("instance-1")
+		path := "/v2/service_instances/instance-1"
+
+		Convey("When image is removed", func() {
+			backend.AddImage(imageName, 1024, model.EXT4)
+
+			rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			_, ok := backend.Image(imageName)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When image is mapped", func() {
+			backend.AddImage(imageName, 1024, model.EXT4)
+			backend.MapImage(imageName)
+
+			rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+			_, ok := backend.Image(imageName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When image is used by another operation", func() {
+			backend.AddImage(imageName, 1024, model.EXT4)
+			unlock, err := c.Operations.lockImages("test operation", imageName)
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusUnprocessableEntity)
+			_, ok := backend.Image(imageName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When image does not exist", func() {
+			rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusGone)
+		})
+	})
+}
+
+func TestOSBBind(t *testing.T) {
+	Convey("Testing OSB bind", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		c.Storage.(*storage.RBDService).Pool = fake.Pool
+		c.Config.CephMonitors = []string{"10.0.0.1:6789"}
+		router := SetupRouter(&c)
+		plan := osbPlans[0]
+		imageName := osbImageName("instance-1")
+		path := "/v2/service_instances/instance-1/service_bindings/binding-1"
+		body := osbBody(model.BindRequest{ServiceID: osbServiceID, PlanID: plan.ID})
+
+		Convey("When Ceph user is created", func() {
+			backend.AddImage(imageName, plan.Size, plan.FileSystem)
+
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusCreated)
+			response := model.BindResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+			So(response.Credentials.Key, ShouldNotBeEmpty)
+			response.Credentials.Key = ""
+			So(response.Credentials, ShouldResemble, model.RBDCredentials{
+				Pool:       "rbd",
+				ImageName:  imageName,
+				FileSystem: plan.FileSystem,
+				Monitors:   []string{"10.0.0.1:6789"},
+				User:       "osb-binding-1",
+			})
+			image, _ := backend.Image(imageName)
+			caps := fmt.Sprintf("allow rwx pool=rbd object_prefix rbd_data.%[1]s, allow rwx pool=rbd object_prefix rbd_header.%[1]s, "+
+				"allow rwx pool=rbd object_prefix rbd_object_map.%[1]s, allow rx pool=rbd object_prefix rbd_id.%[2]s", image.ID, imageName)
+			So(backend.Commands(), ShouldContain, "ceph auth get-or-create client.osb-binding-1 mon profile rbd osd "+caps+" --format json")
+
+			Convey("And binding is removed", func() {
+				rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+				So(rr.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When service instance does not exist", func() {
+			rr := commonHttp.SendRequestWithHeaders("PUT", path, body, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When binding does not exist", func() {
+			rr := commonHttp.SendRequestWithHeaders("DELETE", path, nil, router, osbHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusGone)
+		})
+	})
+}
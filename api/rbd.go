@@ -20,149 +20,180 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 
 	"github.com/gocraft/web"
 	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
 	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
 )
 
-const rbdPath = "/usr/bin/rbd"
-
-var errNotFound = errors.New("not found")
-
-func validateSize(size uint64) error {
-	if size == 0 {
-		return errors.New("rbd size cannot be null")
+// CreateRBD creates and formats RBD
+func (c *Context) CreateRBD(rw web.ResponseWriter, req *web.Request) {
+	input := model.RBD{}
+	err := commonHttp.ReadJson(req, &input)
+	if err != nil {
+		respond400(rw, req, err)
+		return
 	}
-	return nil
-}
-
-func validateImageName(name string) error {
-	if len(name) == 0 {
-		return errors.New("rbd image name is empty")
+	if err = storage.ValidateRBD(input); err != nil {
+		respond400(rw, req, err)
+		return
 	}
-	return nil
-}
 
-func validateFileSystem(input string) error {
-	allowedFS := []string{model.EXT4, model.XFS}
-	for _, fs := range allowedFS {
-		if fs == input {
-			return nil
-		}
+	principal, _ := PrincipalFromRequest(req)
+	input.Owner = principal.Name
+
+	unlock, ok := c.lockImage(rw, req, input.ImageName)
+	if !ok {
+		return
 	}
-	return fmt.Errorf("file system %q is not allowed", input)
-}
+	defer unlock()
 
-func validateRBD(rbd model.RBD) error {
-	if err := validateSize(rbd.Size); err != nil {
-		return err
+	rbd, err := c.Storage.CreateImage(requestContext(req), input)
+	if err == storage.ErrUnknownSeed {
+		respond400(rw, req, fmt.Errorf("seed %q not found in seed catalog", input.InitialContent.Seed))
+		return
 	}
-	if err := validateFileSystem(rbd.FileSystem); err != nil {
-		return err
+	if capacityErr, ok := err.(*storage.CapacityError); ok {
+		respondInsufficientCapacity(rw, req, capacityErr)
+		return
 	}
-	return validateImageName(rbd.ImageName)
-}
-
-func rbdNotFound(message string) bool {
-	const notFound = "NO SUCH FILE"
-	return strings.Contains(strings.ToUpper(message), notFound)
-}
-
-func (c *Context) rbdCreate(name string, size uint64) error {
-	_, err := c.OS.ExecuteCommand(rbdPath, "create", name, fmt.Sprintf("--size=%d", size), "--image-feature=layering")
-	return err
-}
-
-func (c *Context) rbdMap(name string) (string, error) {
-	out, err := c.OS.ExecuteCommand(rbdPath, "map", name)
 	if err != nil {
-		return "", err
+		respond500(rw, req, err)
+		return
 	}
-	return strings.TrimSpace(string(out)), nil
-}
-
-func (c *Context) rbdUnmap(name string) error {
-	_, err := c.OS.ExecuteCommand(rbdPath, "unmap", name)
-	return err
-}
 
-func (c *Context) rbdRemove(name string) error {
-	if output, err := c.OS.ExecuteCommandCombinedOutput(rbdPath, "remove", name); err != nil {
-		if rbdNotFound(string(output)) {
-			return errNotFound
-		}
-		return err
+	if err = commonHttp.WriteJson(rw, rbd, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
 	}
-	return nil
 }
 
-func (c *Context) formatDevice(device string, fs string) error {
-	_, err := c.OS.ExecuteCommand("/sbin/mkfs."+fs, device)
-	return err
-}
-
-func (c *Context) createAndFormatRBD(input model.RBD) (model.RBD, error) {
-	if err := c.rbdCreate(input.ImageName, input.Size); err != nil {
-		return model.RBD{}, fmt.Errorf("cannot create RBD image with name %q and size %d: %v", input.ImageName, input.Size, err)
-	}
-	device, err := c.rbdMap(input.ImageName)
+// ListRBDs returns images in the pool with their labels and file systems recorded at creation, sizes are given in MB.
+// Images can be filtered with label selector given in "selector" query parameter.
+func (c *Context) ListRBDs(rw web.ResponseWriter, req *web.Request) {
+	selector, err := storage.ParseLabelSelector(req.URL.Query().Get("selector"))
 	if err != nil {
-		return model.RBD{}, fmt.Errorf("cannot map RBD image %q: %v", input.ImageName, err)
+		respond400(rw, req, err)
+		return
 	}
-	if err = c.formatDevice(device, input.FileSystem); err != nil {
-		return model.RBD{}, fmt.Errorf("cannot format device %q: %v", device, err)
+
+	ctx := requestContext(req)
+	images, err := c.Storage.ListImagesInfo(ctx)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot list RBDs: %v", err))
+		return
 	}
-	if err = c.rbdUnmap(input.ImageName); err != nil {
-		return model.RBD{}, fmt.Errorf("cannot unmap RBD image %q: %v", input.ImageName, err)
+
+	result := []model.RBD{}
+	for _, image := range images {
+		metadata, err := c.Storage.ImageMetadata(ctx, image.Name)
+		if err == storage.ErrNotFound {
+			// removed after listing
+			continue
+		}
+		if err != nil {
+			respond500(rw, req, fmt.Errorf("cannot get labels of RBD %q: %v", image.Name, err))
+			return
+		}
+		if selector.Matches(metadata.Labels) {
+			result = append(result, model.RBD{ImageName: image.Name, Size: image.Size / mebibyte, FileSystem: metadata.FileSystem, Labels: metadata.Labels})
+		}
 	}
 
-	return input, nil
+	if err = commonHttp.WriteJson(rw, result, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
 }
 
-// CreateRBD creates and formats RBD
-func (c *Context) CreateRBD(rw web.ResponseWriter, req *web.Request) {
-	input := model.RBD{}
-	err := commonHttp.ReadJson(req, &input)
-	if err != nil {
-		commonHttp.Respond400(rw, err)
+// GetRBD returns details of RBD with its labels and file system recorded at creation, size is given in MB
+func (c *Context) GetRBD(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
 		return
 	}
-	if err = validateRBD(input); err != nil {
-		commonHttp.Respond400(rw, err)
+
+	ctx := requestContext(req)
+	info, err := c.Storage.ImageInfo(ctx, name)
+	if err != nil {
+		errNew := fmt.Errorf("cannot get RBD: %v", err)
+		if err == storage.ErrNotFound {
+			respond404(rw, req, errNew)
+			return
+		}
+		respond500(rw, req, errNew)
 		return
 	}
-
-	rbd, err := c.createAndFormatRBD(input)
+	metadata, err := c.Storage.ImageMetadata(ctx, name)
 	if err != nil {
-		commonHttp.Respond500(rw, err)
+		respond500(rw, req, fmt.Errorf("cannot get labels of RBD: %v", err))
 		return
 	}
 
+	rbd := model.RBD{ImageName: info.Name, Size: info.Size / mebibyte, FileSystem: metadata.FileSystem, Labels: metadata.Labels}
 	if err = commonHttp.WriteJson(rw, rbd, http.StatusOK); err != nil {
 		err = fmt.Errorf("cannot parse response: %v", err)
-		commonHttp.Respond500(rw, err)
+		respond500(rw, req, err)
 		return
 	}
 }
 
-// DeleteRBD deletes RBD
+// DeleteRBD moves RBD to trash, from which it can be restored during deferment period.
+// Admins can remove it immediately with "hard=true" query parameter. RBD which is watched or locked is not deleted,
+// unless "force=true" is given and all locks are broken, and its watchers and locks are returned with status 409.
 func (c *Context) DeleteRBD(rw web.ResponseWriter, req *web.Request) {
 	name := req.PathParams["imageName"]
 
-	if err := validateImageName(name); err != nil {
-		commonHttp.Respond400(rw, err)
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	query := req.URL.Query()
+	hard := query.Get("hard") == "true"
+	if hard && !c.isAdmin(req) {
+		respondError(rw, req, http.StatusForbidden, errors.New("only admins can delete RBD bypassing trash"))
+		return
+	}
+
+	unlock, ok := c.lockImage(rw, req, name)
+	if !ok {
 		return
 	}
+	defer unlock()
 
-	if err := c.rbdRemove(name); err != nil {
+	ctx := requestContext(req)
+	err := c.Storage.CheckNotInUse(ctx, name)
+	if busyErr, ok := err.(*storage.BusyError); ok && len(busyErr.Locks) > 0 && query.Get("force") == "true" {
+		if _, err = c.Storage.BreakLocks(ctx, name); err != nil {
+			respond500(rw, req, fmt.Errorf("cannot delete RBD: %v", err))
+			return
+		}
+		err = c.Storage.CheckNotInUse(ctx, name)
+	}
+	if busyErr, ok := err.(*storage.BusyError); ok {
+		respondBusy(rw, req, busyErr)
+		return
+	}
+
+	if err == nil {
+		if hard {
+			err = c.Storage.DeleteImage(ctx, name)
+		} else {
+			err = c.Storage.TrashImage(ctx, name, c.Config.TrashDeferment)
+		}
+	}
+	if err != nil {
 		errNew := fmt.Errorf("cannot delete RBD: %v", err)
-		if err == errNotFound {
-			commonHttp.Respond404(rw, errNew)
+		if err == storage.ErrNotFound {
+			respond404(rw, req, errNew)
 			return
 		}
-		commonHttp.Respond500(rw, errNew)
+		respond500(rw, req, errNew)
 		return
 	}
 
@@ -17,79 +17,270 @@
 package api
 
 import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
-	"github.com/golang/mock/gomock"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
 )
 
 func TestCreateRBD(t *testing.T) {
 	Convey("Testing CreateRBD", t, func() {
-		mockCtrl, _, mock, client := prepareMocksAndClient(t)
+		c, backend, client := prepareFakeAndClient(t)
 		sampleName := "sampleRBD"
 		var sampleSize uint64 = 1000
 		sampleFS := model.XFS
 		device := model.RBD{ImageName: sampleName, Size: sampleSize, FileSystem: sampleFS}
-		sampleDevice := "/dev/rbd1"
 
 		Convey("When os commands are executed correctly", func() {
-			gomock.InOrder(
-				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "create", sampleName, fmt.Sprintf("--size=%d", sampleSize), "--image-feature=layering").Return("", nil),
-				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "map", sampleName).Return(sampleDevice, nil),
-				mock.osMock.EXPECT().ExecuteCommand("/sbin/mkfs."+sampleFS, sampleDevice).Return("", nil),
-				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "unmap", sampleName).Return("", nil),
-			)
-
 			status, err := client.CreateRBD(device)
 
 			So(status, ShouldEqual, http.StatusOK)
 			So(err, ShouldBeNil)
+			image, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+			So(image.Size, ShouldEqual, sampleSize)
+			So(image.FileSystem, ShouldEqual, sampleFS)
+			So(image.Device, ShouldBeEmpty)
 		})
 
 		Convey("When format command goes wrong", func() {
-			gomock.InOrder(
-				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "create", sampleName, fmt.Sprintf("--size=%d", sampleSize), "--image-feature=layering").Return("", nil),
-				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "map", sampleName).Return(sampleDevice, nil),
-				mock.osMock.EXPECT().ExecuteCommand("/sbin/mkfs."+sampleFS, sampleDevice).Return("", fmt.Errorf("some error!")),
-			)
+			backend.Fail("", fmt.Errorf("some error!"), "mkfs."+sampleFS)
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(err, ShouldNotBeNil)
+			image, _ := backend.Image(sampleName)
+			So(image.FileSystem, ShouldBeEmpty)
+		})
+
+		Convey("When image already exists", func() {
+			backend.AddImage(sampleName, 10, model.EXT4)
 
 			status, err := client.CreateRBD(device)
 
 			So(status, ShouldEqual, http.StatusInternalServerError)
 			So(err, ShouldNotBeNil)
+			image, _ := backend.Image(sampleName)
+			So(image.Size, ShouldEqual, 10)
+		})
+
+		Convey("When name is used by another operation", func() {
+			unlock, err := c.Operations.lockImages("test operation", sampleName)
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusConflict)
+			So(err, ShouldNotBeNil)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When mkfs options are given", func() {
+			device.FileSystem = model.EXT3
+			device.MkfsOptions = &model.MkfsOptions{Label: "legacy", DisableLazyInit: true}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(backend.Commands(), ShouldContain, "mkfs.ext3 -L legacy -E lazy_itable_init=0,lazy_journal_init=0 /dev/rbd0")
+			image, _ := backend.Image(sampleName)
+			So(image.FileSystem, ShouldEqual, model.EXT3)
+		})
+
+		Convey("When mkfs option is not supported by file system", func() {
+			device.MkfsOptions = &model.MkfsOptions{DisableLazyInit: true}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(err, ShouldNotBeNil)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func tarArchive(t *testing.T, names ...string) []byte {
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			header.Mode, header.Typeflag = 0755, tar.TypeDir
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCreateRBDWithInitialContent(t *testing.T) {
+	Convey("Testing CreateRBD with initial content", t, func() {
+		c, backend, client := prepareFakeAndClient(t)
+		uid, gid := uint32(1000), uint32(100)
+		device := model.RBD{ImageName: "sampleRBD", Size: 100, FileSystem: model.EXT4}
+
+		Convey("When archive is uploaded", func() {
+			device.InitialContent = &model.InitialContent{Archive: tarArchive(t, "data/", "data/config.yml"), UID: &uid, GID: &gid, Mode: "0750"}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			image, _ := backend.Image("sampleRBD")
+			So(image.Files, ShouldResemble, []string{"data", "data/config.yml"})
+			So(image.RootOwner, ShouldEqual, "1000:100")
+			So(image.RootMode, ShouldEqual, "0750")
+			So(image.MountPoint, ShouldBeEmpty)
+			So(image.Device, ShouldBeEmpty)
+		})
+
+		Convey("When seed from catalog is used", func() {
+			catalog, err := ioutil.TempDir("", "seeds")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(catalog)
+			So(ioutil.WriteFile(filepath.Join(catalog, "skeleton.tar"), tarArchive(t, "logs/", "www/"), 0644), ShouldBeNil)
+			c.Storage.(*storage.RBDService).SeedCatalog = catalog
+			device.InitialContent = &model.InitialContent{Seed: "skeleton"}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			image, _ := backend.Image("sampleRBD")
+			So(image.Files, ShouldResemble, []string{"logs", "www"})
+		})
+
+		Convey("When seed does not exist", func() {
+			device.InitialContent = &model.InitialContent{Seed: "missing"}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusBadRequest)
+			So(err, ShouldNotBeNil)
+			_, ok := backend.Image("sampleRBD")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When initial content is invalid", func() {
+			device.InitialContent = &model.InitialContent{Seed: "../etc/passwd"}
+
+			status, _ := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusBadRequest)
 		})
 
-		Reset(func() {
-			mockCtrl.Finish()
+		Convey("When archive cannot be unpacked", func() {
+			device.InitialContent = &model.InitialContent{Archive: []byte("not an archive")}
+
+			status, err := client.CreateRBD(device)
+
+			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(err, ShouldNotBeNil)
+			image, _ := backend.Image("sampleRBD")
+			So(image.MountPoint, ShouldBeEmpty)
 		})
 	})
 }
 
 func TestDeleteRBD(t *testing.T) {
 	Convey("Testing DeleteRBD", t, func() {
-		mockCtrl, _, mock, client := prepareMocksAndClient(t)
+		c, backend, client := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
 		sampleName := "sampleRBD"
+		backend.AddImage(sampleName, 100, model.EXT4)
 
 		Convey("When os commands are executed correctly", func() {
-			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "remove", sampleName).Return("", nil)
-
 			status, err := client.DeleteRBD(sampleName)
 
 			So(status, ShouldEqual, http.StatusNoContent)
 			So(err, ShouldBeNil)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeFalse)
 		})
 
-		Convey("When format command goes wrong", func() {
-			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "remove", sampleName).Return("", fmt.Errorf("some error!"))
+		Convey("When image does not exist", func() {
+			status, err := client.DeleteRBD("otherRBD")
+
+			So(status, ShouldEqual, http.StatusNotFound)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When image is mapped", func() {
+			backend.MapImage(sampleName)
 
 			status, err := client.DeleteRBD(sampleName)
 
-			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(status, ShouldEqual, http.StatusConflict)
 			So(err, ShouldNotBeNil)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When image is in use", func() {
+			deleteRBD := func(query string) (int, model.ImageInUseResponse) {
+				rr := commonHttp.SendRequestWithHeaders("DELETE", "/api/v1/rbd/"+sampleName+query, nil, router, authorizedHeader(), t)
+				response := model.ImageInUseResponse{}
+				if rr.Code == http.StatusConflict {
+					So(json.Unmarshal(rr.Body.Bytes(), &response), ShouldBeNil)
+				}
+				return rr.Code, response
+			}
+			lock := fake.Lock{ID: "kubelet_lock_magic_node-1", Locker: "client.4100", Address: "10.0.0.1:0/1"}
+
+			Convey("locked image should not be deleted", func() {
+				backend.AddLock(sampleName, lock)
+
+				status, response := deleteRBD("")
+
+				So(status, ShouldEqual, http.StatusConflict)
+				So(response.Watchers, ShouldBeEmpty)
+				So(response.Locks, ShouldResemble, []model.Lock{{ImageName: sampleName, LockName: lock.ID, Locker: lock.Locker, Address: lock.Address}})
+				So(response.Message, ShouldContainSubstring, "locked by client.4100")
+			})
+
+			Convey("locks should be broken when deletion is forced", func() {
+				backend.AddLock(sampleName, lock)
+
+				status, _ := deleteRBD("?force=true")
+
+				So(status, ShouldEqual, http.StatusNoContent)
+				_, ok := backend.Image(sampleName)
+				So(ok, ShouldBeFalse)
+			})
+
+			Convey("watched image should not be deleted even if forced", func() {
+				backend.AddWatcher(sampleName, fake.Watcher{Address: "10.0.0.2:0/2", Client: 4200, Cookie: 1})
+				backend.AddLock(sampleName, lock)
+
+				status, response := deleteRBD("?force=true")
+
+				So(status, ShouldEqual, http.StatusConflict)
+				So(response.Watchers, ShouldResemble, []model.Watcher{{Client: "client.4200", Address: "10.0.0.2:0/2", Cookie: 1}})
+				So(response.Locks, ShouldBeEmpty)
+				image, _ := backend.Image(sampleName)
+				So(image.Locks, ShouldBeEmpty)
+			})
 		})
 
 		Convey("When empty name is passed", func() {
@@ -98,55 +289,63 @@ func TestDeleteRBD(t *testing.T) {
 			So(status, ShouldEqual, http.StatusNotFound)
 			So(err, ShouldNotBeNil)
 		})
+	})
+}
+
+func TestListRBDs(t *testing.T) {
+	Convey("Testing ListRBDs", t, func() {
+		_, backend, client := prepareFakeAndClient(t)
+
+		Convey("When pool is empty", func() {
+			images, status, err := client.ListRBDs()
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(images, ShouldBeEmpty)
+		})
 
-		Reset(func() {
-			mockCtrl.Finish()
+		Convey("When pool contains images with snapshots", func() {
+			backend.AddImage("first", 100, model.EXT4)
+			backend.AddImage("second", 2048, model.XFS)
+			_, err := backend.ExecuteCommand(rbdPath, "snap", "create", "first@snap")
+			So(err, ShouldBeNil)
+
+			images, status, err := client.ListRBDs()
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, []model.RBD{{ImageName: "first", Size: 100}, {ImageName: "second", Size: 2048}})
+		})
+
+		Convey("When rbd fails", func() {
+			backend.Fail("", fmt.Errorf("exit status 1"), "rbd", "list")
+
+			_, status, err := client.ListRBDs()
+
+			So(status, ShouldEqual, http.StatusInternalServerError)
+			So(err, ShouldNotBeNil)
 		})
 	})
 }
 
-func TestValidateRBD(t *testing.T) {
-	testCases := []struct {
-		rbd     model.RBD
-		isError bool
-	}{
-		{model.RBD{ImageName: "", Size: 100, FileSystem: model.XFS}, true},
-		{model.RBD{ImageName: "", Size: 0, FileSystem: model.XFS}, true},
-		{model.RBD{ImageName: "someimage", Size: 0, FileSystem: model.EXT4}, true},
-		{model.RBD{ImageName: "someimage", Size: 200, FileSystem: "wrongFS"}, true},
-		{model.RBD{ImageName: "some image", Size: 100, FileSystem: model.EXT4}, false},
-		{model.RBD{ImageName: "some image", Size: 1024 * 1024, FileSystem: model.XFS}, false},
-		{model.RBD{ImageName: "some image_123", Size: 1024 * 1024 * 1000 * 9, FileSystem: model.XFS}, false},
-	}
+func TestGetRBD(t *testing.T) {
+	Convey("Testing GetRBD", t, func() {
+		_, backend, client := prepareFakeAndClient(t)
+		backend.AddImage("sampleRBD", 100, model.EXT4)
 
-	for _, tc := range testCases {
-		err := validateRBD(tc.rbd)
-		if (err == nil && tc.isError) || (err != nil && !tc.isError) {
-			t.Errorf("validateRBD(%v) returned error: %v; error expected: %v", tc.rbd, err != nil, tc.isError)
-		}
-	}
-}
+		Convey("When image exists", func() {
+			image, status, err := client.GetRBD("sampleRBD")
 
-func TestRbdNotFound(t *testing.T) {
-	testCases := []struct {
-		message string
-		output  bool
-	}{
-		{"rbd: delete error: (2) No such file or directory", true},
-		{"No such file or directory", true},
-		{"No such file", true},
-		{"no such file", true},
-		{"NO SUCH FILE", true},
-		{"there is such file", false},
-		{"OK", false},
-		{"file removed", false},
-		{"", false},
-	}
+			So(status, ShouldEqual, http.StatusOK)
+			So(err, ShouldBeNil)
+			So(image, ShouldResemble, model.RBD{ImageName: "sampleRBD", Size: 100})
+		})
 
-	for _, tc := range testCases {
-		output := rbdNotFound(tc.message)
-		if output != tc.output {
-			t.Errorf("rbdNotFound(%s) = %v; want %v", tc.message, output, tc.output)
-		}
-	}
+		Convey("When image does not exist", func() {
+			_, status, err := client.GetRBD("otherRBD")
+
+			So(status, ShouldEqual, http.StatusNotFound)
+			So(err, ShouldNotBeNil)
+		})
+	})
 }
@@ -0,0 +1,116 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// Reconcile unmaps devices which were mapped by the broker and are no longer used by any operation. Images mapped
+// again while reconciliation runs stay tracked.
+func (c *Context) Reconcile() model.ReconcileReport {
+	report := model.ReconcileReport{Time: time.Now().UTC(), Unmapped: []model.MappedDevice{}, Failed: []model.MappedDevice{}}
+	orphaned := c.Operations.orphanedImages()
+	if len(orphaned) == 0 {
+		c.Operations.setLastReconcile(report)
+		return report
+	}
+
+	log := newRequestLogger("")
+	ctx := storage.WithLogger(context.Background(), log)
+	devices, err := c.Storage.ListMappedDevices(ctx)
+	if err != nil {
+		log.Errorf("Reconcile: cannot list mapped devices: %v", err)
+		report.Error = err.Error()
+		c.Operations.setLastReconcile(report)
+		return report
+	}
+
+	stillMapped := map[string]bool{}
+	for _, device := range devices {
+		if orphaned[device.ImageName] != device.Device {
+			continue
+		}
+		stillMapped[device.ImageName] = true
+		if err := c.Storage.UnmapDevice(ctx, device.Device); err != nil {
+			log.Errorf("Reconcile: cannot unmap device %q of image %q: %v", device.Device, device.ImageName, err)
+			device.Error = err.Error()
+			report.Failed = append(report.Failed, device)
+			continue
+		}
+		log.Infof("Reconcile: unmapped device %q of image %q", device.Device, device.ImageName)
+		c.Operations.unmappedOrphan(device.ImageName, device.Device)
+		report.Unmapped = append(report.Unmapped, device)
+	}
+	for image, device := range orphaned {
+		if !stillMapped[image] {
+			log.Infof("Reconcile: image %q is no longer mapped", image)
+			c.Operations.unmappedOrphan(image, device)
+		}
+	}
+
+	c.Operations.setLastReconcile(report)
+	return report
+}
+
+// unmapOrphaned unmaps device of the image which was left mapped by the broker and is not used by any operation
+func (c *Context) unmapOrphaned(ctx context.Context, imageName string) error {
+	device, ok := c.Operations.orphanedImages()[imageName]
+	if !ok {
+		return nil
+	}
+	if err := c.Storage.UnmapDevice(ctx, device); err != nil {
+		return fmt.Errorf("cannot unmap device %q of image %q: %v", device, imageName, err)
+	}
+	c.Operations.unmappedOrphan(imageName, device)
+	return nil
+}
+
+// StartReconciliation runs Reconcile immediately and then every interval until stop is closed
+func (c *Context) StartReconciliation(interval time.Duration, stop <-chan struct{}) {
+	c.Reconcile()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Reconcile()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// GetReconcileStatus returns report of the last reconciliation pass
+func (c *Context) GetReconcileStatus(rw web.ResponseWriter, req *web.Request) {
+	if err := commonHttp.WriteJson(rw, c.Operations.getLastReconcile(), http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
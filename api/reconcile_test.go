@@ -0,0 +1,102 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestReconcile(t *testing.T) {
+	Convey("Testing Reconcile", t, func() {
+		mockCtrl, c, mock, _ := prepareMocksAndClient(t)
+		dir, err := ioutil.TempDir("", "state")
+		So(err, ShouldBeNil)
+		stateFile := filepath.Join(dir, "state.json")
+		So(ioutil.WriteFile(stateFile, []byte(`{"leftover":{"device":"/dev/rbd3"},"unmapped":{"device":"/dev/rbd4"}}`), 0600), ShouldBeNil)
+		c.Operations, err = NewPersistentOperationTracker(stateFile)
+		So(err, ShouldBeNil)
+		c.Storage = storage.New(mock.osMock, c.Operations)
+		router := SetupRouter(&c)
+		showmapped := `[{"id":"3","pool":"rbd","name":"leftover","snap":"-","device":"/dev/rbd3"},{"id":"5","pool":"rbd","name":"other","snap":"-","device":"/dev/rbd5"}]`
+
+		Convey("When leftover image is still mapped it is unmapped", func() {
+			gomock.InOrder(
+				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "showmapped", "--format", "json").Return(showmapped, nil),
+				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "unmap", "/dev/rbd3").Return("", nil),
+			)
+
+			report := c.Reconcile()
+
+			So(report.Unmapped, ShouldResemble, []model.MappedDevice{{ImageName: "leftover", Pool: "rbd", Device: "/dev/rbd3"}})
+			So(report.Failed, ShouldBeEmpty)
+			So(c.Operations.MappedImages(), ShouldBeEmpty)
+
+			state, err := ioutil.ReadFile(stateFile)
+			So(err, ShouldBeNil)
+			So(string(state), ShouldEqual, "{}")
+
+			header := authorizedHeader()
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/reconcile", nil, router, header, t)
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			status := model.ReconcileReport{}
+			So(json.Unmarshal(rr.Body.Bytes(), &status), ShouldBeNil)
+			So(status.Unmapped, ShouldHaveLength, 1)
+		})
+
+		Convey("When leftover image is mapped again during reconciliation it stays tracked", func() {
+			gomock.InOrder(
+				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "showmapped", "--format", "json").Return(showmapped, nil).
+					Do(func(string, string, string, string) { c.Operations.MappedImage("unmapped", "/dev/rbd8") }),
+				mock.osMock.EXPECT().ExecuteCommand(rbdPath, "unmap", "/dev/rbd3").Return("", nil).
+					Do(func(string, string, string) { c.Operations.MappedImage("leftover", "/dev/rbd7") }),
+			)
+
+			report := c.Reconcile()
+
+			So(report.Unmapped, ShouldHaveLength, 1)
+			So(c.Operations.MappedImages(), ShouldResemble, []string{"leftover", "unmapped"})
+			So(c.Operations.orphanedImages(), ShouldBeEmpty)
+		})
+
+		Convey("When image mapped by in-flight operation is found it is not unmapped", func() {
+			c.Operations.MappedImage("inflight", "/dev/rbd6")
+			mock.osMock.EXPECT().ExecuteCommand(rbdPath, "showmapped", "--format", "json").Return(showmapped, nil)
+			mock.osMock.EXPECT().ExecuteCommand(rbdPath, "unmap", "/dev/rbd3").Return("", nil)
+
+			c.Reconcile()
+
+			So(c.Operations.MappedImages(), ShouldResemble, []string{"inflight"})
+		})
+
+		Reset(func() {
+			mockCtrl.Finish()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,210 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gocraft/web"
+	logging "github.com/op/go-logging"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const maxRequestIDLength = 128
+
+// maxRequestBodySize limits request bodies, the largest of which carry initial content archives of RBDs
+const maxRequestBodySize = 64 * 1024 * 1024
+
+type requestIDKey struct{}
+
+// RequestIDFromRequest returns request identifier attached by RequestIDMiddleware
+func RequestIDFromRequest(req *web.Request) string {
+	requestID, _ := req.Context().Value(requestIDKey{}).(string)
+	return requestID
+}
+
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range requestID {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware accepts request identifier sent by the client or generates a new one,
+// and returns it in response headers
+func (c *Context) RequestIDMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	requestID := req.Header.Get(model.RequestIDHeader)
+	if !isValidRequestID(requestID) {
+		requestID = model.NewRequestID()
+	}
+	req.Request = req.Request.WithContext(context.WithValue(req.Context(), requestIDKey{}, requestID))
+	rw.Header().Set(model.RequestIDHeader, requestID)
+	next(rw, req)
+}
+
+// LimitRequestBodyMiddleware rejects requests with bodies larger than maxRequestBodySize with status 413.
+// Bodies without declared length are cut at the limit and handlers respond 413 when reading them fails.
+func (c *Context) LimitRequestBodyMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	if req.ContentLength > maxRequestBodySize {
+		respondError(rw, req, http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", maxRequestBodySize))
+		return
+	}
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(rw, req.Body, maxRequestBodySize)
+	}
+	next(rw, req)
+}
+
+// requestStatus returns 413 instead of 400 when request is rejected because its body exceeds maxRequestBodySize
+func requestStatus(code int, err error) int {
+	var tooLarge *http.MaxBytesError
+	if code == http.StatusBadRequest && errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return code
+}
+
+// LoggerMiddleware logs status and duration of every request together with its identifier
+func (c *Context) LoggerMiddleware(rw web.ResponseWriter, req *web.Request, next web.NextMiddlewareFunc) {
+	startTime := time.Now()
+
+	next(rw, req)
+
+	web.Logger.Printf("[%v] %d '%s' request_id=%s\n", time.Since(startTime), rw.StatusCode(), req.URL.Path, RequestIDFromRequest(req))
+}
+
+// requestLogger prefixes log messages with request identifier
+type requestLogger struct {
+	logger    *logging.Logger
+	requestID string
+}
+
+func newRequestLogger(requestID string) *requestLogger {
+	requestScoped := *logger
+	requestScoped.ExtraCalldepth++
+	return &requestLogger{logger: &requestScoped, requestID: requestID}
+}
+
+func (l *requestLogger) prefix() string {
+	return "request_id=" + l.requestID
+}
+
+func (l *requestLogger) Debug(args ...interface{}) {
+	l.logger.Debug(append([]interface{}{l.prefix()}, args...)...)
+}
+
+func (l *requestLogger) Debugf(format string, args ...interface{}) {
+	l.logger.Debugf(l.prefix()+" "+format, args...)
+}
+
+func (l *requestLogger) Info(args ...interface{}) {
+	l.logger.Info(append([]interface{}{l.prefix()}, args...)...)
+}
+
+func (l *requestLogger) Infof(format string, args ...interface{}) {
+	l.logger.Infof(l.prefix()+" "+format, args...)
+}
+
+func (l *requestLogger) Warningf(format string, args ...interface{}) {
+	l.logger.Warningf(l.prefix()+" "+format, args...)
+}
+
+func (l *requestLogger) Error(args ...interface{}) {
+	l.logger.Error(append([]interface{}{l.prefix()}, args...)...)
+}
+
+func (l *requestLogger) Errorf(format string, args ...interface{}) {
+	l.logger.Errorf(l.prefix()+" "+format, args...)
+}
+
+// requestContext returns context of the request making storage service log with its identifier
+func requestContext(req *web.Request) context.Context {
+	return storage.WithLogger(req.Context(), newRequestLogger(RequestIDFromRequest(req)))
+}
+
+// backgroundContext is used for operations not started by a request
+func backgroundContext() context.Context {
+	return storage.WithLogger(context.Background(), newRequestLogger(""))
+}
+
+func respondError(rw web.ResponseWriter, req *web.Request, code int, err error) {
+	code = requestStatus(code, err)
+	requestID := RequestIDFromRequest(req)
+	logger.Errorf("request_id=%s Respond %d, reason: %v", requestID, code, err)
+	if writeErr := commonHttp.WriteJson(rw, model.ErrorResponse{Message: err.Error(), RequestID: requestID}, code); writeErr != nil {
+		logger.Errorf("request_id=%s cannot write error response: %v", requestID, writeErr)
+	}
+}
+
+// respondInsufficientCapacity explains why request exceeding pool capacity is rejected
+func respondInsufficientCapacity(rw web.ResponseWriter, req *web.Request, err *storage.CapacityError) {
+	requestID := RequestIDFromRequest(req)
+	logger.Errorf("request_id=%s Respond %d, reason: %v", requestID, http.StatusInsufficientStorage, err)
+	response := model.InsufficientCapacityResponse{
+		Message:          err.Error(),
+		RequestID:        requestID,
+		Pool:             err.Pool,
+		CapacityBytes:    err.CapacityBytes,
+		OvercommitRatio:  err.OvercommitRatio,
+		ProvisionedBytes: err.ProvisionedBytes,
+		AvailableBytes:   err.AvailableBytes(),
+		RequestedBytes:   err.RequestedBytes,
+	}
+	if writeErr := commonHttp.WriteJson(rw, response, http.StatusInsufficientStorage); writeErr != nil {
+		logger.Errorf("request_id=%s cannot write error response: %v", requestID, writeErr)
+	}
+}
+
+// respondBusy returns watchers and locks of RBD which is in use
+func respondBusy(rw web.ResponseWriter, req *web.Request, err *storage.BusyError) {
+	requestID := RequestIDFromRequest(req)
+	logger.Errorf("request_id=%s Respond %d, reason: %v", requestID, http.StatusConflict, err)
+	response := model.ImageInUseResponse{Message: err.Error(), RequestID: requestID, Watchers: err.Watchers, Locks: err.Locks}
+	if response.Watchers == nil {
+		response.Watchers = []model.Watcher{}
+	}
+	if response.Locks == nil {
+		response.Locks = []model.Lock{}
+	}
+	if writeErr := commonHttp.WriteJson(rw, response, http.StatusConflict); writeErr != nil {
+		logger.Errorf("request_id=%s cannot write error response: %v", requestID, writeErr)
+	}
+}
+
+func respond400(rw web.ResponseWriter, req *web.Request, err error) {
+	respondError(rw, req, http.StatusBadRequest, err)
+}
+
+func respond404(rw web.ResponseWriter, req *web.Request, err error) {
+	respondError(rw, req, http.StatusNotFound, err)
+}
+
+func respond500(rw web.ResponseWriter, req *web.Request, err error) {
+	respondError(rw, req, http.StatusInternalServerError, err)
+}
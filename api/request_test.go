@@ -0,0 +1,157 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestIsValidRequestID(t *testing.T) {
+	testCases := []struct {
+		requestID string
+		output    bool
+	}{
+		{"5f0c1a9e2b7d4c3e8a6b1d2c3e4f5a6b", true},
+		{"node-agent/42", true},
+		{"", false},
+		{"with space", false},
+		{"new\nline", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tc := range testCases {
+		output := isValidRequestID(tc.requestID)
+		if output != tc.output {
+			t.Errorf("isValidRequestID(%q) = %v; want %v", tc.requestID, output, tc.output)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	Convey("Testing request ID propagation", t, func() {
+		mockCtrl, c, mock, brokerClient := prepareMocksAndClient(t)
+		router := SetupRouter(&c)
+		sampleName := "sampleRBD"
+
+		Convey("When client sends request ID it is returned in response", func() {
+			header := http.Header{}
+			header.Set(model.RequestIDHeader, "sample-request")
+
+			rr := commonHttp.SendRequestWithHeaders("GET", "/healthz", nil, router, header, t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			So(rr.Header().Get(model.RequestIDHeader), ShouldEqual, "sample-request")
+		})
+
+		Convey("When client sends no request ID new one is generated", func() {
+			rr := commonHttp.SendRequest("GET", "/healthz", nil, router, t)
+
+			So(rr.Header().Get(model.RequestIDHeader), ShouldNotBeEmpty)
+		})
+
+		Convey("When request fails request ID is returned in error body", func() {
+			mock.expectNotInUse(sampleName)
+			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "trash", "mv", sampleName, "--expires-at", gomock.Any()).Return("", fmt.Errorf("some error"))
+			header := authorizedHeader()
+			header.Set(model.RequestIDHeader, "sample-request")
+
+			rr := commonHttp.SendRequestWithHeaders("DELETE", "/api/v1/rbd/"+sampleName, nil, router, header, t)
+
+			So(rr.Code, ShouldEqual, http.StatusInternalServerError)
+			errorResponse := model.ErrorResponse{}
+			So(json.Unmarshal(rr.Body.Bytes(), &errorResponse), ShouldBeNil)
+			So(errorResponse.RequestID, ShouldEqual, "sample-request")
+		})
+
+		Convey("When request fails client surfaces request ID", func() {
+			mock.expectNotInUse(sampleName)
+			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "trash", "mv", sampleName, "--expires-at", gomock.Any()).Return("", fmt.Errorf("some error"))
+			brokerClient.(*client.CephBrokerConnector).RequestID = "client-request"
+
+			_, err := brokerClient.DeleteRBD(sampleName)
+
+			So(err, ShouldNotBeNil)
+			So(client.RequestIDFromError(err), ShouldEqual, "client-request")
+		})
+
+		Reset(func() {
+			mockCtrl.Finish()
+		})
+	})
+}
+
+// repeatedByte is endless reader of the same byte
+type repeatedByte byte
+
+func (b repeatedByte) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	Convey("Testing request body limit", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		send := func(method, path string, body io.Reader, contentLength int64, header http.Header) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, body)
+			req.ContentLength = contentLength
+			for key := range header {
+				req.Header.Set(key, header.Get(key))
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			return rr
+		}
+		tooLarge := func() io.Reader {
+			return io.MultiReader(strings.NewReader(`{"imageName":"`), io.LimitReader(repeatedByte('a'), maxRequestBodySize))
+		}
+
+		Convey("When declared body length exceeds the limit request is rejected before reading it", func() {
+			rr := send("POST", "/api/v1/rbd", strings.NewReader("{}"), maxRequestBodySize+1, authorizedHeader())
+
+			So(rr.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+
+		Convey("When body without declared length exceeds the limit REST API responds 413", func() {
+			rr := send("POST", "/api/v1/rbd", tooLarge(), -1, authorizedHeader())
+
+			So(rr.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			So(backend.Commands(), ShouldBeEmpty)
+		})
+
+		Convey("When body without declared length exceeds the limit OSB API responds 413", func() {
+			rr := send("PUT", "/v2/service_instances/instance-1", tooLarge(), -1, osbHeader())
+
+			So(rr.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+		})
+	})
+}
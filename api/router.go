@@ -26,7 +26,9 @@ import (
 
 func SetupRouter(context *Context) *web.Router {
 	router := web.New(*context)
-	router.Middleware(web.LoggerMiddleware)
+	router.Middleware(context.RequestIDMiddleware)
+	router.Middleware(context.LoggerMiddleware)
+	router.Middleware(context.LimitRequestBodyMiddleware)
 
 	router.Get("/healthz", context.GetHealthz)
 
@@ -35,6 +37,9 @@ func SetupRouter(context *Context) *web.Router {
 	v1AliasRouter := router.Subrouter(*context, "/api/v1.0")
 	route(v1AliasRouter, context)
 
+	osbRouter := router.Subrouter(*context, "/v2")
+	routeOSB(osbRouter, context)
+
 	router.Get("/", context.Index)
 	router.Error(context.Error)
 
@@ -43,12 +48,47 @@ func SetupRouter(context *Context) *web.Router {
 
 func route(router *web.Router, context *Context) {
 	router.Middleware(context.BasicAuthorizeMiddleware)
+	router.Middleware(context.AuditMiddleware)
+	router.Middleware(context.TrackOperationsMiddleware)
 
+	router.Get("/rbd", (*context).ListRBDs)
 	router.Post("/rbd", (*context).CreateRBD)
+	router.Get("/rbd/:imageName", (*context).GetRBD)
 	router.Delete("/rbd/:imageName", (*context).DeleteRBD)
+	router.Patch("/rbd/:imageName/labels", (*context).UpdateLabels)
+	router.Post("/rbd/:imageName/rename", (*context).RenameRBD)
+	router.Post("/rbd/:imageName/copy", (*context).CopyRBD)
+	router.Get("/rbd/:imageName/manifest", (*context).GetManifest)
+	router.Post("/rbd/:imageName/fsck", (*context).CheckFileSystem)
+	router.Get("/rbd/:imageName/usage", (*context).GetImageUsage)
+	router.Get("/usage", (*context).GetUsage)
+
+	router.Get("/trash", (*context).ListTrash)
+	router.Post("/trash/purge", (*context).PurgeTrash)
+	router.Post("/trash/:imageID/restore", (*context).RestoreRBD)
+	router.Delete("/trash/:imageID", (*context).PurgeTrashedRBD)
+
+	router.Get("/operations/:operationID", (*context).GetImageOperation)
 
 	router.Get("/lock", (*context).ListLocks)
 	router.Delete("/lock/:imageName/:lockName/:locker", (*context).DeleteLock)
+
+	router.Get("/audit", (*context).ListAuditEntries)
+	router.Get("/reconcile", (*context).GetReconcileStatus)
+}
+
+func routeOSB(router *web.Router, context *Context) {
+	router.Middleware(context.BasicAuthorizeMiddleware)
+	router.Middleware(context.OSBVersionMiddleware)
+	router.Middleware(context.AuditMiddleware)
+	router.Middleware(context.TrackOperationsMiddleware)
+
+	router.Get("/catalog", (*context).GetCatalog)
+	router.Put("/service_instances/:instanceID", (*context).Provision)
+	router.Delete("/service_instances/:instanceID", (*context).Deprovision)
+	router.Get("/service_instances/:instanceID/last_operation", (*context).GetLastOperation)
+	router.Put("/service_instances/:instanceID/service_bindings/:bindingID", (*context).Bind)
+	router.Delete("/service_instances/:instanceID/service_bindings/:bindingID", (*context).Unbind)
 }
 
 func (c *Context) Index(rw web.ResponseWriter, req *web.Request) {
@@ -56,6 +96,6 @@ func (c *Context) Index(rw web.ResponseWriter, req *web.Request) {
 }
 
 func (c *Context) Error(rw web.ResponseWriter, r *web.Request, err interface{}) {
-	logger.Errorf("Respond500: reason: %v", err)
+	logger.Errorf("request_id=%s Respond500: reason: %v", RequestIDFromRequest(r), err)
 	rw.WriteHeader(http.StatusInternalServerError)
 }
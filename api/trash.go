@@ -0,0 +1,167 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+// trashLockName returns name under which RBD with id is reserved while in trash, it cannot collide with image names
+func trashLockName(id string) string {
+	return "trash" + storage.SnapshotSeparator + id
+}
+
+// ListTrash returns RBDs moved to trash
+func (c *Context) ListTrash(rw web.ResponseWriter, req *web.Request) {
+	entries, err := c.Storage.ListTrash(requestContext(req))
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot list trash: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, entries, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
+
+// RestoreRBD moves RBD with id from trash back to the pool and returns it
+func (c *Context) RestoreRBD(rw web.ResponseWriter, req *web.Request) {
+	id := req.PathParams["imageID"]
+	if err := storage.ValidateTrashID(id); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	ctx := requestContext(req)
+	entry, err := c.trashEntry(rw, req, id)
+	if err != nil {
+		return
+	}
+	unlock, err := c.Operations.lockImages("request "+RequestIDFromRequest(req), trashLockName(id), entry.ImageName)
+	if err != nil {
+		respondError(rw, req, http.StatusConflict, err)
+		return
+	}
+	defer unlock()
+	if err = c.Storage.RestoreImage(ctx, id); err != nil {
+		errNew := fmt.Errorf("cannot restore RBD %q: %v", entry.ImageName, err)
+		switch err {
+		case storage.ErrNotFound:
+			respond404(rw, req, errNew)
+		case storage.ErrImageExists:
+			respondError(rw, req, http.StatusConflict, errNew)
+		default:
+			respond500(rw, req, errNew)
+		}
+		return
+	}
+
+	info, err := c.Storage.ImageInfo(ctx, entry.ImageName)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get restored RBD: %v", err))
+		return
+	}
+	labels, err := c.Storage.ImageLabels(ctx, entry.ImageName)
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get labels of restored RBD: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, model.RBD{ImageName: info.Name, Size: info.Size / mebibyte, Labels: labels}, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
+
+// trashEntry returns trashed RBD with id, or responds 404 if there is no such RBD in trash
+func (c *Context) trashEntry(rw web.ResponseWriter, req *web.Request, id string) (model.TrashEntry, error) {
+	entries, err := c.Storage.ListTrash(requestContext(req))
+	if err != nil {
+		err = fmt.Errorf("cannot list trash: %v", err)
+		respond500(rw, req, err)
+		return model.TrashEntry{}, err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+	err = fmt.Errorf("RBD with id %q not found in trash", id)
+	respond404(rw, req, err)
+	return model.TrashEntry{}, err
+}
+
+// PurgeTrashedRBD removes RBD with id from trash. Admins can remove it before deferment period ends
+// with "force=true" query parameter.
+func (c *Context) PurgeTrashedRBD(rw web.ResponseWriter, req *web.Request) {
+	id := req.PathParams["imageID"]
+	if err := storage.ValidateTrashID(id); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+	force := req.URL.Query().Get("force") == "true"
+	if force && !c.isAdmin(req) {
+		respondError(rw, req, http.StatusForbidden, errors.New("only admins can purge RBD before deferment period ends"))
+		return
+	}
+
+	unlock, ok := c.lockImage(rw, req, trashLockName(id))
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := c.Storage.PurgeImage(requestContext(req), id, force); err != nil {
+		errNew := fmt.Errorf("cannot purge RBD: %v", err)
+		switch err {
+		case storage.ErrNotFound:
+			respond404(rw, req, errNew)
+		case storage.ErrDefermentNotExpired:
+			respondError(rw, req, http.StatusConflict, errNew)
+		default:
+			respond500(rw, req, errNew)
+		}
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// PurgeTrash removes RBDs whose deferment period has ended from trash and returns them
+func (c *Context) PurgeTrash(rw web.ResponseWriter, req *web.Request) {
+	purged, err := c.Storage.PurgeTrash(requestContext(req))
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot purge trash: %v", err))
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, purged, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
@@ -0,0 +1,177 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestTrash(t *testing.T) {
+	Convey("Testing RBD trash", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		c.Config.TrashDeferment = time.Hour
+		router := SetupRouter(&c)
+		sampleName := "sampleRBD"
+		backend.AddImage(sampleName, 100, model.EXT4)
+
+		send := func(method, path string) *httptest.ResponseRecorder {
+			return commonHttp.SendRequestWithHeaders(method, path, nil, router, authorizedHeader(), t)
+		}
+		listTrash := func() []model.TrashEntry {
+			rr := send("GET", "/api/v1/trash")
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			entries := []model.TrashEntry{}
+			So(json.Unmarshal(rr.Body.Bytes(), &entries), ShouldBeNil)
+			return entries
+		}
+		trashed := func() model.TrashEntry {
+			So(send("DELETE", "/api/v1/rbd/"+sampleName).Code, ShouldEqual, http.StatusNoContent)
+			entries := listTrash()
+			So(entries, ShouldHaveLength, 1)
+			return entries[0]
+		}
+
+		Convey("When RBD is deleted it is moved to trash", func() {
+			entry := trashed()
+
+			So(entry.ImageName, ShouldEqual, sampleName)
+			So(entry.Expired, ShouldBeFalse)
+			So(entry.DeferredUntil, ShouldHappenWithin, 2*time.Second, entry.DeletedAt.Add(time.Hour))
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("When RBD is restored", func() {
+			_, err := backend.ExecuteCommand(rbdPath, "image-meta", "set", sampleName, "label.env", "prod")
+			So(err, ShouldBeNil)
+			entry := trashed()
+
+			rr := send("POST", "/api/v1/trash/"+entry.ID+"/restore")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			rbd := model.RBD{}
+			So(json.Unmarshal(rr.Body.Bytes(), &rbd), ShouldBeNil)
+			So(rbd, ShouldResemble, model.RBD{ImageName: sampleName, Size: 100, Labels: map[string]string{"env": "prod"}})
+			So(listTrash(), ShouldBeEmpty)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When RBD with the same name was created in the meantime", func() {
+			entry := trashed()
+			backend.AddImage(sampleName, 200, model.XFS)
+
+			rr := send("POST", "/api/v1/trash/"+entry.ID+"/restore")
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+			So(listTrash(), ShouldHaveLength, 1)
+		})
+
+		Convey("When name of RBD is used by another operation", func() {
+			entry := trashed()
+			unlock, err := c.Operations.lockImages("test operation", sampleName)
+			So(err, ShouldBeNil)
+			defer unlock()
+
+			rr := send("POST", "/api/v1/trash/"+entry.ID+"/restore")
+
+			So(rr.Code, ShouldEqual, http.StatusConflict)
+			So(listTrash(), ShouldHaveLength, 1)
+		})
+
+		Convey("When RBD is not in trash", func() {
+			So(send("POST", "/api/v1/trash/abc123/restore").Code, ShouldEqual, http.StatusNotFound)
+			So(send("DELETE", "/api/v1/trash/abc123").Code, ShouldEqual, http.StatusNotFound)
+			So(send("POST", "/api/v1/trash/not-an-id/restore").Code, ShouldEqual, http.StatusBadRequest)
+		})
+
+		Convey("When RBD is purged before deferment period ends", func() {
+			entry := trashed()
+
+			So(send("DELETE", "/api/v1/trash/"+entry.ID).Code, ShouldEqual, http.StatusConflict)
+			So(send("DELETE", "/api/v1/trash/"+entry.ID+"?force=true").Code, ShouldEqual, http.StatusForbidden)
+			So(listTrash(), ShouldHaveLength, 1)
+		})
+
+		Convey("When trash is purged only expired RBDs are removed", func() {
+			trashed()
+			backend.AddImage("expired", 100, model.EXT4)
+			_, err := backend.ExecuteCommand(rbdPath, "trash", "mv", "expired")
+			So(err, ShouldBeNil)
+
+			rr := send("POST", "/api/v1/trash/purge")
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			purged := []model.TrashEntry{}
+			So(json.Unmarshal(rr.Body.Bytes(), &purged), ShouldBeNil)
+			So(purged, ShouldHaveLength, 1)
+			So(purged[0].ImageName, ShouldEqual, "expired")
+			So(purged[0].Expired, ShouldBeTrue)
+			entries := listTrash()
+			So(entries, ShouldHaveLength, 1)
+			So(entries[0].ImageName, ShouldEqual, sampleName)
+		})
+
+		Convey("When user who is not admin deletes RBD immediately", func() {
+			rr := send("DELETE", "/api/v1/rbd/"+sampleName+"?hard=true")
+
+			So(rr.Code, ShouldEqual, http.StatusForbidden)
+			_, ok := backend.Image(sampleName)
+			So(ok, ShouldBeTrue)
+		})
+
+		Convey("When admin", func() {
+			c.Config.Admins = []string{testUser}
+			router = SetupRouter(&c)
+
+			Convey("deletes RBD immediately it is not moved to trash", func() {
+				rr := send("DELETE", "/api/v1/rbd/"+sampleName+"?hard=true")
+
+				So(rr.Code, ShouldEqual, http.StatusNoContent)
+				_, ok := backend.Image(sampleName)
+				So(ok, ShouldBeFalse)
+				So(listTrash(), ShouldBeEmpty)
+			})
+
+			Convey("purges RBD before deferment period ends", func() {
+				entry := trashed()
+
+				So(send("DELETE", "/api/v1/trash/"+entry.ID+"?force=true").Code, ShouldEqual, http.StatusNoContent)
+				So(listTrash(), ShouldBeEmpty)
+			})
+
+			Convey("cannot purge RBD which is being restored", func() {
+				entry := trashed()
+				unlock, err := c.Operations.lockImages("test operation", trashLockName(entry.ID))
+				So(err, ShouldBeNil)
+				defer unlock()
+
+				So(send("DELETE", "/api/v1/trash/"+entry.ID+"?force=true").Code, ShouldEqual, http.StatusConflict)
+				So(listTrash(), ShouldHaveLength, 1)
+			})
+		})
+	})
+}
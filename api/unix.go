@@ -0,0 +1,103 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// PeerCredentials identify local process connected over Unix socket
+type PeerCredentials struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+func (p PeerCredentials) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", p.PID, p.UID, p.GID)
+}
+
+type peerCredentialsKey struct{}
+
+// UnixConnContext attaches credentials of the peer process to the context of every request received on
+// Unix socket connection. It is meant to be used as http.Server ConnContext.
+func UnixConnContext(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	credentials, err := getPeerCredentials(unixConn)
+	if err != nil {
+		logger.Errorf("cannot get peer credentials of Unix socket connection: %v", err)
+		return ctx
+	}
+	logger.Infof("Unix socket connection from %v", credentials)
+	return context.WithValue(ctx, peerCredentialsKey{}, credentials)
+}
+
+func peerCredentialsFromContext(ctx context.Context) (PeerCredentials, bool) {
+	credentials, ok := ctx.Value(peerCredentialsKey{}).(PeerCredentials)
+	return credentials, ok
+}
+
+// peerAuthInfo carries credentials of the peer process of gRPC connection received on Unix socket,
+// handlers get it with peer.FromContext
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+	Credentials PeerCredentials
+}
+
+func (peerAuthInfo) AuthType() string {
+	return "unix"
+}
+
+// unixTransportCredentials attaches credentials of the peer process to gRPC connections received on Unix socket.
+// Connections are not secured otherwise, access is controlled by the socket file permissions.
+type unixTransportCredentials struct {
+	credentials.TransportCredentials
+}
+
+func newUnixTransportCredentials() credentials.TransportCredentials {
+	return unixTransportCredentials{insecure.NewCredentials()}
+}
+
+func (c unixTransportCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return conn, info, err
+	}
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, info, nil
+	}
+	peerCredentials, err := getPeerCredentials(unixConn)
+	if err != nil {
+		logger.Errorf("cannot get peer credentials of Unix socket connection: %v", err)
+		return conn, info, nil
+	}
+	logger.Infof("CSI Unix socket connection from %v", peerCredentials)
+	return conn, peerAuthInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}, peerCredentials}, nil
+}
+
+func (c unixTransportCredentials) Clone() credentials.TransportCredentials {
+	return newUnixTransportCredentials()
+}
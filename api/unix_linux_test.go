@@ -0,0 +1,69 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+)
+
+func TestUnixSocket(t *testing.T) {
+	Convey("Testing Unix socket listener", t, func() {
+		mockCtrl, c, mock, _ := prepareMocksAndClient(t)
+		dir, err := ioutil.TempDir("", "socket")
+		So(err, ShouldBeNil)
+		socketPath := filepath.Join(dir, "broker.sock")
+		listener, err := net.Listen("unix", socketPath)
+		So(err, ShouldBeNil)
+		server := &http.Server{Handler: SetupRouter(&c), ConnContext: UnixConnContext}
+		go server.Serve(listener)
+		sampleName := "sampleRBD"
+
+		Convey("When local process connects it is authenticated by peer credentials", func() {
+			mock.expectNotInUse(sampleName)
+			mock.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "trash", "mv", sampleName, "--expires-at", gomock.Any()).Return("", nil)
+			unixClient, err := client.NewCephBrokerUnixSocket(socketPath)
+			So(err, ShouldBeNil)
+
+			status, err := unixClient.DeleteRBD(sampleName)
+
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("When socket does not exist client cannot be created", func() {
+			_, err := client.NewCephBrokerUnixSocket(filepath.Join(dir, "missing.sock"))
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			server.Close()
+			mockCtrl.Finish()
+			os.RemoveAll(dir)
+		})
+	})
+}
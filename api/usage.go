@@ -0,0 +1,153 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/gocraft/web"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const (
+	usageSortName           = "name"
+	usageSortProvisioned    = "provisioned"
+	usageSortUsed           = "used"
+	usageSortUsedPercentage = "usedPercentage"
+
+	usageOrderAsc  = "asc"
+	usageOrderDesc = "desc"
+)
+
+var usageLess = map[string]func(a, b model.ImageUsage) bool{
+	usageSortName:           func(a, b model.ImageUsage) bool { return a.ImageName < b.ImageName },
+	usageSortProvisioned:    func(a, b model.ImageUsage) bool { return a.ProvisionedBytes < b.ProvisionedBytes },
+	usageSortUsed:           func(a, b model.ImageUsage) bool { return a.UsedBytes < b.UsedBytes },
+	usageSortUsedPercentage: func(a, b model.ImageUsage) bool { return a.UsedPercentage < b.UsedPercentage },
+}
+
+// usageOptions select and order images of usage report
+type usageOptions struct {
+	Sort              string
+	Order             string
+	MinUsedPercentage float64
+	MaxUsedPercentage float64
+}
+
+func parseUsageOptions(query url.Values) (usageOptions, error) {
+	options := usageOptions{Sort: usageSortName, Order: usageOrderAsc, MinUsedPercentage: 0, MaxUsedPercentage: 100}
+	if value := query.Get("sort"); value != "" {
+		if _, ok := usageLess[value]; !ok {
+			return options, fmt.Errorf("cannot sort by %q, use %s, %s, %s or %s", value, usageSortName, usageSortProvisioned, usageSortUsed, usageSortUsedPercentage)
+		}
+		options.Sort = value
+	}
+	if value := query.Get("order"); value != "" {
+		if value != usageOrderAsc && value != usageOrderDesc {
+			return options, fmt.Errorf("order %q is not supported, use %s or %s", value, usageOrderAsc, usageOrderDesc)
+		}
+		options.Order = value
+	}
+	for key, threshold := range map[string]*float64{"minUsedPercentage": &options.MinUsedPercentage, "maxUsedPercentage": &options.MaxUsedPercentage} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 100 {
+			return options, fmt.Errorf("%s has to be a number between 0 and 100, got %q", key, value)
+		}
+		*threshold = parsed
+	}
+	return options, nil
+}
+
+func (o usageOptions) apply(images []model.ImageUsage) []model.ImageUsage {
+	selected := []model.ImageUsage{}
+	for _, image := range images {
+		if image.UsedPercentage >= o.MinUsedPercentage && image.UsedPercentage <= o.MaxUsedPercentage {
+			selected = append(selected, image)
+		}
+	}
+	less := usageLess[o.Sort]
+	sort.SliceStable(selected, func(i, j int) bool {
+		if o.Order == usageOrderDesc {
+			return less(selected[j], selected[i])
+		}
+		return less(selected[i], selected[j])
+	})
+	return selected
+}
+
+// GetUsage returns provisioned and used space of images in the pool. Images can be filtered by used percentage
+// thresholds and sorted by name, provisioned or used space, or used percentage.
+func (c *Context) GetUsage(rw web.ResponseWriter, req *web.Request) {
+	options, err := parseUsageOptions(req.URL.Query())
+	if err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	report, err := c.Storage.DiskUsage(requestContext(req), "")
+	if err != nil {
+		respond500(rw, req, fmt.Errorf("cannot get usage: %v", err))
+		return
+	}
+	report.Images = options.apply(report.Images)
+
+	if err = commonHttp.WriteJson(rw, report, http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
+
+// GetImageUsage returns provisioned and used space of RBD and its snapshots
+func (c *Context) GetImageUsage(rw web.ResponseWriter, req *web.Request) {
+	name := req.PathParams["imageName"]
+	if err := storage.ValidateImageName(name); err != nil {
+		respond400(rw, req, err)
+		return
+	}
+
+	report, err := c.Storage.DiskUsage(requestContext(req), name)
+	if err == nil && len(report.Images) != 1 {
+		err = fmt.Errorf("unexpected usage report of %d images", len(report.Images))
+	}
+	if err != nil {
+		errNew := fmt.Errorf("cannot get usage of RBD: %v", err)
+		if err == storage.ErrNotFound {
+			respond404(rw, req, errNew)
+			return
+		}
+		respond500(rw, req, errNew)
+		return
+	}
+
+	if err = commonHttp.WriteJson(rw, report.Images[0], http.StatusOK); err != nil {
+		err = fmt.Errorf("cannot parse response: %v", err)
+		respond500(rw, req, err)
+		return
+	}
+}
@@ -0,0 +1,114 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+func TestUsage(t *testing.T) {
+	Convey("Testing usage reports", t, func() {
+		c, backend, _ := prepareFakeAndClient(t)
+		router := SetupRouter(&c)
+		backend.AddImage("full", 100, model.EXT4)
+		backend.SetUsed("full", 95)
+		_, err := backend.ExecuteCommand(rbdPath, "snap", "create", "full@daily")
+		So(err, ShouldBeNil)
+		backend.AddImage("empty", 1000, model.XFS)
+		backend.AddImage("half", 200, model.EXT4)
+		backend.SetUsed("half", 100)
+
+		getReport := func(query string) (int, model.UsageReport) {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/usage"+query, nil, router, authorizedHeader(), t)
+			report := model.UsageReport{}
+			if rr.Code == http.StatusOK {
+				So(json.Unmarshal(rr.Body.Bytes(), &report), ShouldBeNil)
+			}
+			return rr.Code, report
+		}
+		names := func(report model.UsageReport) []string {
+			result := []string{}
+			for _, image := range report.Images {
+				result = append(result, image.ImageName)
+			}
+			return result
+		}
+
+		Convey("When image usage is requested", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/rbd/full/usage", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusOK)
+			usage := model.ImageUsage{}
+			So(json.Unmarshal(rr.Body.Bytes(), &usage), ShouldBeNil)
+			So(usage, ShouldResemble, model.ImageUsage{
+				ImageName:        "full",
+				ProvisionedBytes: 100 * mebibyte,
+				UsedBytes:        95 * mebibyte,
+				UsedPercentage:   95,
+				Snapshots:        []model.SnapshotUsage{{Name: "daily", ProvisionedBytes: 100 * mebibyte, UsedBytes: 95 * mebibyte}},
+			})
+		})
+
+		Convey("When usage of missing image is requested", func() {
+			rr := commonHttp.SendRequestWithHeaders("GET", "/api/v1/rbd/missing/usage", nil, router, authorizedHeader(), t)
+
+			So(rr.Code, ShouldEqual, http.StatusNotFound)
+		})
+
+		Convey("When pool usage is requested", func() {
+			status, report := getReport("")
+
+			So(status, ShouldEqual, http.StatusOK)
+			So(names(report), ShouldResemble, []string{"empty", "full", "half"})
+			So(report.TotalProvisionedBytes, ShouldEqual, 1300*mebibyte)
+			So(report.TotalUsedBytes, ShouldEqual, 290*mebibyte)
+		})
+
+		Convey("When pool usage is sorted in descending order", func() {
+			_, report := getReport("?sort=usedPercentage&order=desc")
+
+			So(names(report), ShouldResemble, []string{"full", "half", "empty"})
+		})
+
+		Convey("When nearly full images are requested", func() {
+			_, report := getReport("?minUsedPercentage=90")
+
+			So(names(report), ShouldResemble, []string{"full"})
+			So(report.TotalProvisionedBytes, ShouldEqual, 1300*mebibyte)
+		})
+
+		Convey("When wasted images are requested", func() {
+			_, report := getReport("?maxUsedPercentage=10&sort=provisioned")
+
+			So(names(report), ShouldResemble, []string{"empty"})
+		})
+
+		Convey("When options are invalid", func() {
+			for _, query := range []string{"?sort=size", "?order=up", "?minUsedPercentage=abc", "?maxUsedPercentage=101"} {
+				status, _ := getReport(query)
+				So(status, ShouldEqual, http.StatusBadRequest)
+			}
+		})
+	})
+}
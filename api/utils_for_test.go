@@ -17,44 +17,78 @@
 package api
 
 import (
+	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
 	"github.com/gocraft/web"
 	"github.com/golang/mock/gomock"
 
 	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+	commonHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const (
+	testUser     = "user"
+	testPassword = "password"
+
+	rbdPath = "/usr/bin/rbd"
 )
 
 type MockPack struct {
 	osMock *MockOS
 }
 
+// expectNotInUse expects pre-delete check of image without watchers and locks
+func (m MockPack) expectNotInUse(imageName string) {
+	m.osMock.EXPECT().ExecuteCommandCombinedOutput(rbdPath, "status", imageName, "--format", "json").Return(`{"watchers":[]}`, nil)
+	m.osMock.EXPECT().ExecuteCommand(rbdPath, "lock", "list", imageName).Return("", nil)
+}
+
 func prepareMocksAndClient(t *testing.T) (mockCtrl *gomock.Controller, c Context, mocks MockPack, client client.CephBroker) {
 	mockCtrl = gomock.NewController(t)
 	mocks = MockPack{
 		osMock: NewMockOS(mockCtrl),
 	}
+	operations := NewOperationTracker()
 	c = Context{
-		OS: mocks.osMock,
+		Storage:    storage.New(mocks.osMock, operations),
+		Operations: operations,
+		Config:     config.Config{User: testUser, Password: testPassword},
 	}
 	router := SetupRouter(&c)
 	client = getCatalogClient(router, t)
 	return
 }
 
-func getCatalogClient(router *web.Router, t *testing.T) client.CephBroker {
-	const user = "user"
-	const password = "password"
-
-	os.Setenv("CEPH_BROKER_USER", user)
-	os.Setenv("CEPH_BROKER_PASS", password)
+// prepareFakeAndClient returns Context backed by in-memory fake of rbd and ceph commands
+func prepareFakeAndClient(t *testing.T) (c Context, backend *fake.OS, client client.CephBroker) {
+	backend = fake.New()
+	operations := NewOperationTracker()
+	c = Context{
+		Storage:    storage.New(backend, operations),
+		Operations: operations,
+		Config:     config.Config{User: testUser, Password: testPassword, CephPool: "rbd"},
+	}
+	router := SetupRouter(&c)
+	client = getCatalogClient(router, t)
+	return
+}
 
+func getCatalogClient(router *web.Router, t *testing.T) client.CephBroker {
 	testServer := httptest.NewServer(router)
-	catalogClient, err := client.NewCephBrokerBasicAuth(testServer.URL, user, password)
+	catalogClient, err := client.NewCephBrokerBasicAuth(testServer.URL, testUser, testPassword)
 	if err != nil {
 		t.Fatal("Catalog client error: ", err)
 	}
 	return catalogClient
 }
+
+func authorizedHeader() http.Header {
+	header := http.Header{}
+	header.Set("Authorization", commonHttp.GetBasicAuthHeader(&commonHttp.BasicAuth{User: testUser, Password: testPassword}))
+	return header
+}
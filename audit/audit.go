@@ -0,0 +1,162 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+// SyslogDestination selects syslog instead of a file as audit log destination
+const SyslogDestination = "syslog"
+
+// ErrQueryNotSupported is returned by audit logs which cannot be read back
+var ErrQueryNotSupported = errors.New("audit log query is not supported for this destination")
+
+// Filter narrows audit log query results. Zero values match everything.
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	Principal string
+}
+
+// Matches checks if entry satisfies the filter
+func (f Filter) Matches(entry model.AuditEntry) bool {
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	if f.Principal != "" && entry.Principal != f.Principal {
+		return false
+	}
+	return true
+}
+
+// Log records mutating operations
+type Log interface {
+	Record(entry model.AuditEntry) error
+	Query(filter Filter) ([]model.AuditEntry, error)
+}
+
+// New returns audit log writing to syslog or appending to file, depending on destination
+func New(destination string) (Log, error) {
+	if destination == SyslogDestination {
+		return NewSyslogLog()
+	}
+	return NewFileLog(destination)
+}
+
+// FileLog appends audit entries as JSON lines to a file
+type FileLog struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewFileLog opens file in append-only mode, creating it if necessary
+func NewFileLog(path string) (*FileLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log %q: %v", path, err)
+	}
+	return &FileLog{path: path, file: file}, nil
+}
+
+// Record appends entry to the file
+func (l *FileLog) Record(entry model.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Query reads the file and returns entries matching the filter
+func (l *FileLog) Query(filter Filter) ([]model.AuditEntry, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entries := []model.AuditEntry{}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return entries, err
+	}
+	defer file.Close()
+
+	// entries are decoded one by one, as lines recording large request bodies can be of any length
+	decoder := json.NewDecoder(file)
+	for n := 1; ; n++ {
+		entry := model.AuditEntry{}
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, fmt.Errorf("malformed audit log entry %d: %v", n, err)
+		}
+		if filter.Matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// Close closes underlying file
+func (l *FileLog) Close() error {
+	return l.file.Close()
+}
+
+// SyslogLog sends audit entries as JSON to local syslog
+type SyslogLog struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogLog connects to local syslog daemon
+func NewSyslogLog() (*SyslogLog, error) {
+	writer, err := syslog.New(syslog.LOG_NOTICE|syslog.LOG_AUTH, "tap-ceph-broker")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to syslog: %v", err)
+	}
+	return &SyslogLog{writer: writer}, nil
+}
+
+// Record sends entry to syslog
+func (l *SyslogLog) Record(entry model.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return l.writer.Notice(string(line))
+}
+
+// Query is not supported, syslog entries have to be read from syslog storage
+func (l *SyslogLog) Query(filter Filter) ([]model.AuditEntry, error) {
+	return []model.AuditEntry{}, ErrQueryNotSupported
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func TestFileLog(t *testing.T) {
+	Convey("Testing FileLog", t, func() {
+		dir, err := ioutil.TempDir("", "audit")
+		So(err, ShouldBeNil)
+		path := filepath.Join(dir, "audit.log")
+		auditLog, err := NewFileLog(path)
+		So(err, ShouldBeNil)
+
+		now := time.Now().UTC().Truncate(time.Second)
+		first := model.AuditEntry{Time: now.Add(-time.Hour), Principal: "admin", Operation: "DELETE /api/v1/rbd/:imageName", ImageName: "image1", Outcome: model.AuditOutcomeSuccess}
+		second := model.AuditEntry{Time: now, Principal: "agent", Operation: "POST /api/v1/rbd", ImageName: "image2", Outcome: model.AuditOutcomeFailure}
+		So(auditLog.Record(first), ShouldBeNil)
+		So(auditLog.Record(second), ShouldBeNil)
+
+		Convey("When no filter is given all entries are returned", func() {
+			entries, err := auditLog.Query(Filter{})
+
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, []model.AuditEntry{first, second})
+		})
+
+		Convey("When principal filter is given", func() {
+			entries, err := auditLog.Query(Filter{Principal: "agent"})
+
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, []model.AuditEntry{second})
+		})
+
+		Convey("When time filter is given", func() {
+			entries, err := auditLog.Query(Filter{Until: now.Add(-time.Minute)})
+
+			So(err, ShouldBeNil)
+			So(entries, ShouldResemble, []model.AuditEntry{first})
+		})
+
+		Convey("When log is reopened entries are appended", func() {
+			So(auditLog.Close(), ShouldBeNil)
+			auditLog, err = NewFileLog(path)
+			So(err, ShouldBeNil)
+			So(auditLog.Record(first), ShouldBeNil)
+
+			entries, err := auditLog.Query(Filter{})
+
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 3)
+		})
+
+		Reset(func() {
+			auditLog.Close()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,207 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package cephbrokertest provides in-process ceph-broker for tests of its clients.
+// Server runs the broker API over in-memory emulation of rbd and ceph commands,
+// so clients observe the same statuses and bodies as in production.
+package cephbrokertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/api"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+)
+
+const (
+	// User and Password are credentials accepted by Server
+	User     = "user"
+	Password = "password"
+	// Pool is the Ceph pool reported by Server
+	Pool = "rbd"
+)
+
+// Call is a request received by Server
+type Call struct {
+	Method    string
+	Path      string
+	Body      []byte
+	RequestID string
+	Status    int
+}
+
+// Failure is injected into requests matching Method and Path. Empty Method matches any method, Path is a pattern
+// in path.Match syntax, empty Path matches any path. Server waits for Delay, then responds with Status and Message,
+// or handles the request normally if Status is 0. Failure is applied Times times, or to all requests if Times is 0.
+type Failure struct {
+	Method  string
+	Path    string
+	Status  int
+	Message string
+	Delay   time.Duration
+	Times   int
+}
+
+// Server is ceph-broker listening on local address
+type Server struct {
+	*httptest.Server
+	// Backend emulates rbd and ceph commands; it can be used to prepare images and locks or to inspect them
+	Backend *fake.OS
+
+	mutex    sync.Mutex
+	calls    []Call
+	failures []*Failure
+}
+
+// NewServer starts Server, which has to be closed by the caller
+func NewServer() *Server {
+	s := &Server{Backend: fake.New()}
+	operations := api.NewOperationTracker()
+	c := api.Context{
+		Storage:    storage.New(s.Backend, operations),
+		Operations: operations,
+		Config:     config.Config{User: User, Password: Password, CephPool: Pool, CephMonitors: []string{"127.0.0.1:6789"}},
+	}
+	router := api.SetupRouter(&c)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.serve(router, rw, req)
+	}))
+	return s
+}
+
+// Client returns connector authenticated with Server credentials; retries are disabled
+func (s *Server) Client() *client.CephBrokerConnector {
+	return &client.CephBrokerConnector{Address: s.URL, Username: User, Password: Password, Client: s.Server.Client()}
+}
+
+// Inject adds failure applied to subsequent requests
+func (s *Server) Inject(failure Failure) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = append(s.failures, &failure)
+}
+
+// FailNotFound makes next request matching method and path fail with 404
+func (s *Server) FailNotFound(method, pathPattern string) {
+	s.Inject(Failure{Method: method, Path: pathPattern, Status: http.StatusNotFound, Message: "not found", Times: 1})
+}
+
+// FailTimeout makes next request matching method and path wait for delay and fail with 504.
+// Clients with shorter timeout give up before.
+func (s *Server) FailTimeout(method, pathPattern string, delay time.Duration) {
+	s.Inject(Failure{Method: method, Path: pathPattern, Status: http.StatusGatewayTimeout, Message: "timeout", Delay: delay, Times: 1})
+}
+
+// MarkBusy maps the image, as if it was used by a node, so that it cannot be deleted
+func (s *Server) MarkBusy(imageName string) {
+	s.Backend.MapImage(imageName)
+}
+
+// ClearFailures removes all injected failures
+func (s *Server) ClearFailures() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = nil
+}
+
+// Calls returns requests received so far
+func (s *Server) Calls() []Call {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Call{}, s.calls...)
+}
+
+// ClearCalls forgets recorded requests
+func (s *Server) ClearCalls() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = nil
+}
+
+func (s *Server) serve(router http.Handler, rw http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+
+	if failure, ok := s.matchingFailure(req); ok {
+		select {
+		case <-time.After(failure.Delay):
+		case <-req.Context().Done():
+		}
+		if failure.Status != 0 {
+			recorder.Header().Set("Content-Type", "application/json")
+			recorder.Header().Set(model.RequestIDHeader, req.Header.Get(model.RequestIDHeader))
+			recorder.WriteHeader(failure.Status)
+			json.NewEncoder(recorder).Encode(model.ErrorResponse{Message: failure.Message, RequestID: req.Header.Get(model.RequestIDHeader)})
+		} else {
+			router.ServeHTTP(recorder, req)
+		}
+	} else {
+		router.ServeHTTP(recorder, req)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, Call{
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Body:      body,
+		RequestID: recorder.Header().Get(model.RequestIDHeader),
+		Status:    recorder.status,
+	})
+}
+
+func (s *Server) matchingFailure(req *http.Request) (Failure, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, failure := range s.failures {
+		if failure.Method != "" && failure.Method != req.Method {
+			continue
+		}
+		if matched, _ := path.Match(failure.Path, req.URL.Path); failure.Path != "" && !matched {
+			continue
+		}
+		if failure.Times > 0 {
+			failure.Times--
+			if failure.Times == 0 {
+				s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			}
+		}
+		return *failure, true
+	}
+	return Failure{}, false
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,120 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cephbrokertest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+)
+
+func TestServer(t *testing.T) {
+	Convey("Testing Server", t, func() {
+		server := NewServer()
+		defer server.Close()
+		broker := server.Client()
+
+		Convey("images should be created and deleted in memory", func() {
+			status, err := broker.CreateRBD(model.RBD{ImageName: "sample", Size: 100, FileSystem: model.EXT4})
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			image, ok := server.Backend.Image("sample")
+			So(ok, ShouldBeTrue)
+			So(image.FileSystem, ShouldEqual, model.EXT4)
+
+			status, err = broker.DeleteRBD("sample")
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusNoContent)
+		})
+
+		Convey("calls should be recorded", func() {
+			broker.RequestID = "test-request"
+			broker.CreateRBD(model.RBD{ImageName: "sample", Size: 100, FileSystem: model.EXT4})
+			broker.DeleteRBD("missing")
+
+			calls := server.Calls()
+			So(len(calls), ShouldEqual, 2)
+			So(calls[0].Method, ShouldEqual, http.MethodPost)
+			So(calls[0].Path, ShouldEqual, "/api/v1/rbd")
+			So(string(calls[0].Body), ShouldContainSubstring, `"imageName":"sample"`)
+			So(calls[0].Status, ShouldEqual, http.StatusOK)
+			So(calls[0].RequestID, ShouldEqual, "test-request")
+			So(calls[1].Status, ShouldEqual, http.StatusNotFound)
+
+			server.ClearCalls()
+			So(server.Calls(), ShouldBeEmpty)
+		})
+
+		Convey("busy image should not be deleted", func() {
+			server.Backend.AddImage("sample", 100, model.EXT4)
+			server.MarkBusy("sample")
+
+			status, err := broker.DeleteRBD("sample")
+
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, http.StatusConflict)
+		})
+
+		Convey("injected not found should be returned once", func() {
+			server.Backend.AddImage("sample", 100, model.EXT4)
+			server.FailNotFound(http.MethodGet, "/api/v1/rbd/*")
+
+			_, err := broker.V2().GetRBD(context.Background(), "sample")
+			So(client.IsNotFound(err), ShouldBeTrue)
+
+			_, err = broker.V2().GetRBD(context.Background(), "sample")
+			So(err, ShouldBeNil)
+		})
+
+		Convey("injected timeout should exceed client deadline", func() {
+			server.FailTimeout("", "/api/v1/lock", time.Second)
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			_, err := broker.V2().ListLocks(ctx)
+
+			So(err, ShouldHaveSameTypeAs, &client.RequestError{})
+		})
+
+		Convey("injected failure should be applied until cleared", func() {
+			server.Inject(Failure{Path: "/healthz", Status: http.StatusServiceUnavailable, Message: "maintenance"})
+
+			So(broker.V2().Health(context.Background()), ShouldNotBeNil)
+			So(broker.V2().Health(context.Background()), ShouldNotBeNil)
+
+			server.ClearFailures()
+			So(broker.V2().Health(context.Background()), ShouldBeNil)
+		})
+
+		Convey("locks prepared in backend should be listed", func() {
+			server.Backend.AddImage("sample", 100, model.EXT4)
+			server.Backend.AddLock("sample", fake.Lock{ID: "lock1", Locker: "client.4100", Address: "10.0.0.1:0/1"})
+
+			locks, err := broker.V2().ListLocks(context.Background())
+
+			So(err, ShouldBeNil)
+			So(locks, ShouldResemble, []model.Lock{{ImageName: "sample", LockName: "lock1", Locker: "client.4100", Address: "10.0.0.1:0/1"}})
+		})
+	})
+}
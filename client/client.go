@@ -17,20 +17,30 @@
 package client
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
 	brokerHttp "github.com/trustedanalytics-ng/tap-go-common/http"
 )
 
+// unixSocketAddress is used as address of broker reached over Unix socket; host part is ignored by the dialer
+const unixSocketAddress = "http://unix"
+
 // CephBroker delivers an interface to access ceph-broker functionality to the client
 type CephBroker interface {
 	CreateRBD(device model.RBD) (int, error)
 	DeleteRBD(name string) (int, error)
+	ListRBDs() ([]model.RBD, int, error)
+	GetRBD(name string) (model.RBD, int, error)
 
 	ListLocks() ([]model.Lock, int, error)
 	DeleteLock(lock model.Lock) (int, error)
@@ -44,6 +54,43 @@ type CephBrokerConnector struct {
 	Username string
 	Password string
 	Client   *http.Client
+
+	// RequestID is sent with every request if set, otherwise new identifier is generated for each request
+	RequestID string
+	// Retry controls retrying of idempotent calls of CephBrokerV2 returned by V2; zero value disables retries.
+	// Calls of CephBroker interface are never retried.
+	Retry RetryPolicy
+}
+
+// ResponseError is returned when ceph-broker responds with unexpected status
+type ResponseError struct {
+	Status    int
+	Message   string
+	RequestID string
+}
+
+func (e *ResponseError) Error() string {
+	message := "bad response status: " + strconv.Itoa(e.Status)
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		message += " (request id: " + e.RequestID + ")"
+	}
+	return message
+}
+
+// RequestIDFromError returns identifier of the failed request, if err was returned by the client
+func RequestIDFromError(err error) string {
+	switch typedErr := err.(type) {
+	case *ResponseError:
+		return typedErr.RequestID
+	case *RequestError:
+		return typedErr.RequestID
+	case *DecodeError:
+		return typedErr.RequestID
+	}
+	return ""
 }
 
 // NewCephBrokerBasicAuth returns initialized CephBrokerConnector structure for basic auth
@@ -52,7 +99,7 @@ func NewCephBrokerBasicAuth(address, username, password string) (*CephBrokerConn
 	if err != nil {
 		return nil, err
 	}
-	return &CephBrokerConnector{address, username, password, client}, nil
+	return &CephBrokerConnector{Address: address, Username: username, Password: password, Client: client}, nil
 }
 
 // NewCephBrokerCa returns initialized CephBrokerConnector structure for basic auth using certificate
@@ -61,51 +108,91 @@ func NewCephBrokerCa(address, username, password, certPemFile, keyPemFile, caPem
 	if err != nil {
 		return nil, err
 	}
-	return &CephBrokerConnector{address, username, password, client}, nil
+	return &CephBrokerConnector{Address: address, Username: username, Password: password, Client: client}, nil
 }
 
-// CreateRBD calls api/v1/rbd POST method and verifies response status code
-func (t *CephBrokerConnector) CreateRBD(device model.RBD) (int, error) {
-	url := fmt.Sprintf("%s/api/v1/rbd", t.Address)
+// NewCephBrokerUnixSocket returns initialized CephBrokerConnector structure connecting over Unix socket of a local broker.
+// Caller is authenticated by credentials of its process, so no username and password are needed.
+func NewCephBrokerUnixSocket(socketPath string) (*CephBrokerConnector, error) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{Timeout: brokerHttp.ConnectionTimeout}).DialContext(ctx, "unix", socketPath)
+		},
+	}
+	client := &http.Client{Transport: transport, Timeout: brokerHttp.ConnectionTimeout}
+	return &CephBrokerConnector{Address: unixSocketAddress, Client: client}, nil
+}
+
+func newResponseError(status int, body []byte, requestID string) *ResponseError {
+	errorResponse := model.ErrorResponse{}
+	if err := json.Unmarshal(body, &errorResponse); err != nil {
+		errorResponse.Message = strings.TrimSpace(string(body))
+	}
+	if errorResponse.RequestID != "" {
+		requestID = errorResponse.RequestID
+	}
+	return &ResponseError{Status: status, Message: errorResponse.Message, RequestID: requestID}
+}
 
-	b, err := json.Marshal(&device)
+// call sends single request with basic auth and request identifier; it returns response status, body and request identifier
+func (t *CephBrokerConnector) call(ctx context.Context, method, url, requestID string, body []byte) (int, []byte, string, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
-		return 400, err
+		return -1, nil, requestID, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(t.Username, t.Password)
+	req.Header.Set(model.RequestIDHeader, requestID)
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
 	}
 
-	auth := brokerHttp.BasicAuth{User: t.Username, Password: t.Password}
-	status, _, err := brokerHttp.RestPOST(url, string(b), brokerHttp.GetBasicAuthHeader(&auth), t.Client)
+	resp, err := t.Client.Do(req)
 	if err != nil {
-		return status, err
+		return -1, nil, requestID, &RequestError{Op: "sending request", Method: method, URL: url, RequestID: requestID, Err: err}
 	}
-	if status != http.StatusOK {
-		return status, errors.New("bad response status: " + strconv.Itoa(status))
+	defer resp.Body.Close()
+
+	if responseRequestID := resp.Header.Get(model.RequestIDHeader); responseRequestID != "" {
+		requestID = responseRequestID
 	}
-	return status, nil
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return -1, nil, requestID, &RequestError{Op: "reading response of", Method: method, URL: url, RequestID: requestID, Err: err}
+	}
+	return resp.StatusCode, data, requestID, nil
+}
+
+// CreateRBD calls api/v1/rbd POST method and verifies response status code
+func (t *CephBrokerConnector) CreateRBD(device model.RBD) (int, error) {
+	return t.do(context.Background(), RetryPolicy{}, http.MethodPost, "/api/v1/rbd", &device, http.StatusOK, false, nil)
 }
 
 // DeleteRBD calls api/v1/rbd DELETE method and verifies response status code
 func (t *CephBrokerConnector) DeleteRBD(name string) (int, error) {
-	url := fmt.Sprintf("%s/api/v1/rbd/%s", t.Address, name)
+	return t.do(context.Background(), RetryPolicy{}, http.MethodDelete, "/api/v1/rbd/"+name, nil, http.StatusNoContent, true, nil)
+}
 
-	auth := brokerHttp.BasicAuth{User: t.Username, Password: t.Password}
-	status, _, err := brokerHttp.RestDELETE(url, "", brokerHttp.GetBasicAuthHeader(&auth), t.Client)
-	if err != nil {
-		return status, err
-	}
-	if status != http.StatusNoContent {
-		return status, errors.New("bad response status: " + strconv.Itoa(status))
-	}
-	return status, nil
+// ListRBDs calls api/v1/rbd GET method and returns images in the pool
+func (t *CephBrokerConnector) ListRBDs() ([]model.RBD, int, error) {
+	ret := []model.RBD{}
+	status, err := t.do(context.Background(), RetryPolicy{}, http.MethodGet, "/api/v1/rbd", nil, http.StatusOK, true, &ret)
+	return ret, status, err
+}
+
+// GetRBD calls api/v1/rbd/:imageName GET method and returns details of the image
+func (t *CephBrokerConnector) GetRBD(name string) (model.RBD, int, error) {
+	ret := model.RBD{}
+	status, err := t.do(context.Background(), RetryPolicy{}, http.MethodGet, "/api/v1/rbd/"+name, nil, http.StatusOK, true, &ret)
+	return ret, status, err
 }
 
 // GetCephBrokerHealth calls healthz and verifies response status code
 func (t *CephBrokerConnector) GetCephBrokerHealth() (int, error) {
-	url := fmt.Sprintf("%s/healthz", t.Address)
-
-	auth := brokerHttp.BasicAuth{User: t.Username, Password: t.Password}
-	status, _, err := brokerHttp.RestGET(url, brokerHttp.GetBasicAuthHeader(&auth), t.Client)
-	if status != http.StatusOK {
+	if _, err := t.do(context.Background(), RetryPolicy{}, http.MethodGet, "/healthz", nil, http.StatusOK, true, nil); err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("invalid health status: %v", err)
 	}
 	return http.StatusOK, nil
@@ -113,34 +200,11 @@ func (t *CephBrokerConnector) GetCephBrokerHealth() (int, error) {
 
 func (t *CephBrokerConnector) ListLocks() ([]model.Lock, int, error) {
 	ret := []model.Lock{}
-
-	url := fmt.Sprintf("%s/api/v1/lock", t.Address)
-
-	auth := brokerHttp.BasicAuth{User: t.Username, Password: t.Password}
-	status, body, err := brokerHttp.RestGET(url, brokerHttp.GetBasicAuthHeader(&auth), t.Client)
-	if err != nil {
-		return ret, status, err
-	}
-	if status != http.StatusOK {
-		return ret, status, errors.New("bad response status: " + strconv.Itoa(status))
-	}
-
-	if err := json.Unmarshal(body, &ret); err != nil {
-		panic(err)
-	}
-
-	return ret, status, nil
+	status, err := t.do(context.Background(), RetryPolicy{}, http.MethodGet, "/api/v1/lock", nil, http.StatusOK, true, &ret)
+	return ret, status, err
 }
 
 func (t *CephBrokerConnector) DeleteLock(lock model.Lock) (int, error) {
-	url := fmt.Sprintf("%s/api/v1/lock/%s/%s/%s", t.Address, lock.ImageName, lock.LockName, lock.Locker)
-	auth := brokerHttp.BasicAuth{User: t.Username, Password: t.Password}
-	status, _, err := brokerHttp.RestDELETE(url, "", brokerHttp.GetBasicAuthHeader(&auth), t.Client)
-	if err != nil {
-		return status, err
-	}
-	if status != http.StatusNoContent {
-		return status, errors.New("bad response status: " + strconv.Itoa(status))
-	}
-	return status, nil
+	path := fmt.Sprintf("/api/v1/lock/%s/%s/%s", lock.ImageName, lock.LockName, lock.Locker)
+	return t.do(context.Background(), RetryPolicy{}, http.MethodDelete, path, nil, http.StatusNoContent, true, nil)
 }
@@ -0,0 +1,264 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+// CephBrokerV2 is context-aware interface to ceph-broker returning created and fetched models.
+// Failures are reported as *ResponseError, *RequestError or *DecodeError.
+type CephBrokerV2 interface {
+	CreateRBD(ctx context.Context, device model.RBD) (model.RBD, error)
+	DeleteRBD(ctx context.Context, name string) error
+	ListRBDs(ctx context.Context) ([]model.RBD, error)
+	GetRBD(ctx context.Context, name string) (model.RBD, error)
+	// ListRBDsBySelector returns images with labels matching selector, e.g. "env=prod,team!=qa"
+	ListRBDsBySelector(ctx context.Context, selector string) ([]model.RBD, error)
+	// UpdateLabels sets labels given with values and removes ones given with nil
+	UpdateLabels(ctx context.Context, name string, patch map[string]*string) (model.RBD, error)
+
+	// ListTrash returns deleted images which can be restored
+	ListTrash(ctx context.Context) ([]model.TrashEntry, error)
+	// RestoreRBD moves image with id from trash back to the pool
+	RestoreRBD(ctx context.Context, id string) (model.RBD, error)
+	// RenameRBD renames image and returns it when the operation ends
+	RenameRBD(ctx context.Context, imageName, newName string) (model.RBD, error)
+	// CopyRBD copies image and returns the copy when the operation ends
+	CopyRBD(ctx context.Context, imageName string, request model.CopyRequest) (model.RBD, error)
+
+	ListLocks(ctx context.Context) ([]model.Lock, error)
+	DeleteLock(ctx context.Context, lock model.Lock) error
+
+	Health(ctx context.Context) error
+}
+
+// RetryPolicy describes retrying of idempotent calls which failed to reach the broker or got 503 or 504 response.
+// Delay before n-th retry is InitialBackoff doubled n-1 times, limited by MaxBackoff. DELETE retried after an attempt
+// which may have been executed by the broker succeeds on 404, as the resource may have been removed by that attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one; values lower than 2 disable retries
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy can be set as Retry of the connector to retry idempotent calls of CephBrokerV2
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, InitialBackoff: 200 * time.Millisecond, MaxBackoff: 2 * time.Second}
+
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || delay < p.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// RequestError is returned when request could not be sent or its response could not be read
+type RequestError struct {
+	Op        string
+	Method    string
+	URL       string
+	RequestID string
+	Err       error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s %s %s (request id: %s) failed: %v", e.Op, e.Method, e.URL, e.RequestID, e.Err)
+}
+
+// DecodeError is returned when response of ceph-broker cannot be parsed
+type DecodeError struct {
+	RequestID string
+	Err       error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cannot parse response (request id: %s): %v", e.RequestID, e.Err)
+}
+
+// IsNotFound tells whether err was returned because requested image or lock does not exist
+func IsNotFound(err error) bool {
+	responseErr, ok := err.(*ResponseError)
+	return ok && responseErr.Status == http.StatusNotFound
+}
+
+// V2 returns context-aware interface sharing address, credentials and retry policy of the connector
+func (t *CephBrokerConnector) V2() CephBrokerV2 {
+	return connectorV2{t}
+}
+
+type connectorV2 struct {
+	connector *CephBrokerConnector
+}
+
+func (c connectorV2) CreateRBD(ctx context.Context, device model.RBD) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodPost, "/api/v1/rbd", &device, http.StatusOK, false, &ret)
+	return ret, err
+}
+
+func (c connectorV2) DeleteRBD(ctx context.Context, name string) error {
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodDelete, "/api/v1/rbd/"+name, nil, http.StatusNoContent, true, nil)
+	return err
+}
+
+func (c connectorV2) ListRBDs(ctx context.Context) ([]model.RBD, error) {
+	ret := []model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/api/v1/rbd", nil, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) GetRBD(ctx context.Context, name string) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/api/v1/rbd/"+name, nil, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) ListRBDsBySelector(ctx context.Context, selector string) ([]model.RBD, error) {
+	ret := []model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/api/v1/rbd?selector="+url.QueryEscape(selector), nil, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) UpdateLabels(ctx context.Context, name string, patch map[string]*string) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodPatch, "/api/v1/rbd/"+name+"/labels", patch, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) ListTrash(ctx context.Context) ([]model.TrashEntry, error) {
+	ret := []model.TrashEntry{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/api/v1/trash", nil, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) RestoreRBD(ctx context.Context, id string) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodPost, "/api/v1/trash/"+id+"/restore", nil, http.StatusOK, false, &ret)
+	return ret, err
+}
+
+func (c connectorV2) RenameRBD(ctx context.Context, imageName, newName string) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodPost, "/api/v1/rbd/"+imageName+"/rename", model.RenameRequest{NewName: newName}, http.StatusOK, false, &ret)
+	return ret, err
+}
+
+func (c connectorV2) CopyRBD(ctx context.Context, imageName string, request model.CopyRequest) (model.RBD, error) {
+	ret := model.RBD{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodPost, "/api/v1/rbd/"+imageName+"/copy", request, http.StatusOK, false, &ret)
+	return ret, err
+}
+
+func (c connectorV2) ListLocks(ctx context.Context) ([]model.Lock, error) {
+	ret := []model.Lock{}
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/api/v1/lock", nil, http.StatusOK, true, &ret)
+	return ret, err
+}
+
+func (c connectorV2) DeleteLock(ctx context.Context, lock model.Lock) error {
+	path := fmt.Sprintf("/api/v1/lock/%s/%s/%s", lock.ImageName, lock.LockName, lock.Locker)
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodDelete, path, nil, http.StatusNoContent, true, nil)
+	return err
+}
+
+func (c connectorV2) Health(ctx context.Context) error {
+	_, err := c.connector.do(ctx, c.connector.Retry, http.MethodGet, "/healthz", nil, http.StatusOK, true, nil)
+	return err
+}
+
+// do sends request to path with input encoded as JSON and decodes response into output, if given.
+// Idempotent requests are retried according to retry policy; the same request identifier is used for all attempts.
+func (t *CephBrokerConnector) do(ctx context.Context, retry RetryPolicy, method, path string, input interface{}, expected int, idempotent bool, output interface{}) (int, error) {
+	var body []byte
+	if input != nil {
+		var err error
+		if body, err = json.Marshal(input); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+	requestID := t.RequestID
+	if requestID == "" {
+		requestID = model.NewRequestID()
+	}
+	url := t.Address + path
+
+	mayHaveBeenApplied := false
+	for attempt := 1; ; attempt++ {
+		status, data, responseRequestID, err := t.call(ctx, method, url, requestID, body)
+		if err == nil && method == http.MethodDelete && status == http.StatusNotFound && mayHaveBeenApplied {
+			return expected, nil
+		}
+		if err == nil && status != expected {
+			err = newResponseError(status, data, responseRequestID)
+		}
+		if err == nil {
+			if output != nil {
+				if err = json.Unmarshal(data, output); err != nil {
+					return status, &DecodeError{RequestID: responseRequestID, Err: err}
+				}
+			}
+			return status, nil
+		}
+		if !idempotent || attempt >= retry.MaxAttempts || !retryable(status, err) || ctx.Err() != nil {
+			return status, err
+		}
+		mayHaveBeenApplied = mayHaveBeenApplied || mayHaveReachedBroker(status, err)
+
+		timer := time.NewTimer(retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status, err
+		case <-timer.C:
+		}
+	}
+}
+
+// mayHaveReachedBroker tells whether failed attempt could have been executed by the broker: only connections which
+// could not be established and 503 responses of the broker itself guarantee that it was not
+func mayHaveReachedBroker(status int, err error) bool {
+	if requestErr, ok := err.(*RequestError); ok {
+		if urlErr, ok := requestErr.Err.(*url.Error); ok {
+			if opErr, ok := urlErr.Err.(*net.OpError); ok && opErr.Op == "dial" {
+				return false
+			}
+		}
+		return true
+	}
+	return status == http.StatusGatewayTimeout
+}
+
+// retryable tells whether request may succeed if repeated
+func retryable(status int, err error) bool {
+	if _, ok := err.(*RequestError); ok {
+		return true
+	}
+	return status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
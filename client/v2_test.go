@@ -0,0 +1,210 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+// prepareServer returns connector to server responding with statuses in turn, the last one is repeated
+func prepareServer(body string, statuses ...int) (*CephBrokerConnector, *int32, func()) {
+	attempts := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		attempt := int(atomic.AddInt32(attempts, 1))
+		if attempt > len(statuses) {
+			attempt = len(statuses)
+		}
+		rw.Header().Set(model.RequestIDHeader, req.Header.Get(model.RequestIDHeader))
+		rw.WriteHeader(statuses[attempt-1])
+		rw.Write([]byte(body))
+	}))
+	connector, _ := NewCephBrokerBasicAuth(server.URL, "user", "password")
+	connector.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	return connector, attempts, server.Close
+}
+
+func TestCephBrokerV2(t *testing.T) {
+	Convey("Testing CephBrokerV2", t, func() {
+		ctx := context.Background()
+
+		Convey("CreateRBD should return created image", func() {
+			connector, _, closeServer := prepareServer(`{"imageName":"sample","size":100,"fileSystem":"xfs"}`, http.StatusOK)
+			defer closeServer()
+
+			rbd, err := connector.V2().CreateRBD(ctx, model.RBD{ImageName: "sample", Size: 100, FileSystem: model.XFS})
+
+			So(err, ShouldBeNil)
+			So(rbd, ShouldResemble, model.RBD{ImageName: "sample", Size: 100, FileSystem: model.XFS})
+		})
+
+		Convey("idempotent call should be retried on 503 until it succeeds", func() {
+			connector, attempts, closeServer := prepareServer(`[]`, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusOK)
+			defer closeServer()
+
+			locks, err := connector.V2().ListLocks(ctx)
+
+			So(err, ShouldBeNil)
+			So(locks, ShouldBeEmpty)
+			So(*attempts, ShouldEqual, 3)
+		})
+
+		Convey("retries should stop after MaxAttempts", func() {
+			connector, attempts, closeServer := prepareServer(`{"message":"busy"}`, http.StatusServiceUnavailable)
+			defer closeServer()
+
+			err := connector.V2().DeleteRBD(ctx, "sample")
+
+			So(err, ShouldHaveSameTypeAs, &ResponseError{})
+			So(err.(*ResponseError).Status, ShouldEqual, http.StatusServiceUnavailable)
+			So(err.(*ResponseError).Message, ShouldEqual, "busy")
+			So(*attempts, ShouldEqual, 3)
+		})
+
+		Convey("calls of CephBroker interface should not be retried", func() {
+			connector, attempts, closeServer := prepareServer(`{"message":"busy"}`, http.StatusServiceUnavailable)
+			defer closeServer()
+
+			_, status, err := connector.ListLocks()
+
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, http.StatusServiceUnavailable)
+			So(*attempts, ShouldEqual, 1)
+		})
+
+		Convey("connectors should not retry unless retry policy is set", func() {
+			connector, err := NewCephBrokerBasicAuth("http://127.0.0.1", "user", "password")
+
+			So(err, ShouldBeNil)
+			So(connector.Retry, ShouldResemble, RetryPolicy{})
+		})
+
+		Convey("creation should not be retried", func() {
+			connector, attempts, closeServer := prepareServer(``, http.StatusServiceUnavailable)
+			defer closeServer()
+
+			_, err := connector.V2().CreateRBD(ctx, model.RBD{ImageName: "sample", Size: 100})
+
+			So(err, ShouldNotBeNil)
+			So(*attempts, ShouldEqual, 1)
+		})
+
+		Convey("other statuses should not be retried", func() {
+			connector, attempts, closeServer := prepareServer(`{"message":"no such image"}`, http.StatusNotFound)
+			defer closeServer()
+
+			_, err := connector.V2().GetRBD(ctx, "sample")
+
+			So(IsNotFound(err), ShouldBeTrue)
+			So(*attempts, ShouldEqual, 1)
+		})
+
+		Convey("DELETE retried after gateway timeout should succeed on 404", func() {
+			connector, attempts, closeServer := prepareServer(`{"message":"no such image"}`, http.StatusGatewayTimeout, http.StatusNotFound)
+			defer closeServer()
+
+			err := connector.V2().DeleteRBD(ctx, "sample")
+
+			So(err, ShouldBeNil)
+			So(*attempts, ShouldEqual, 2)
+		})
+
+		Convey("DELETE retried after lost response should succeed on 404", func() {
+			attempts := new(int32)
+			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				if atomic.AddInt32(attempts, 1) == 1 {
+					connection, _, _ := rw.(http.Hijacker).Hijack()
+					connection.Close()
+					return
+				}
+				rw.WriteHeader(http.StatusNotFound)
+			}))
+			defer server.Close()
+			connector, _ := NewCephBrokerBasicAuth(server.URL, "user", "password")
+			connector.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+			err := connector.V2().DeleteLock(ctx, model.Lock{ImageName: "sample", LockName: "lock", Locker: "client.1"})
+
+			So(err, ShouldBeNil)
+			So(*attempts, ShouldEqual, 2)
+		})
+
+		Convey("DELETE of missing image should fail when no attempt reached the broker", func() {
+			connector, attempts, closeServer := prepareServer(`{"message":"no such image"}`, http.StatusServiceUnavailable, http.StatusNotFound)
+			defer closeServer()
+
+			err := connector.V2().DeleteRBD(ctx, "sample")
+
+			So(IsNotFound(err), ShouldBeTrue)
+			So(*attempts, ShouldEqual, 2)
+		})
+
+		Convey("cancelled context should stop retries", func() {
+			connector, attempts, closeServer := prepareServer(``, http.StatusServiceUnavailable)
+			defer closeServer()
+			connector.Retry.InitialBackoff = time.Hour
+			connector.Retry.MaxBackoff = time.Hour
+			cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			err := connector.V2().Health(cancelled)
+
+			So(err, ShouldNotBeNil)
+			So(*attempts, ShouldEqual, 1)
+		})
+
+		Convey("unreachable broker should be reported as RequestError", func() {
+			connector, _, closeServer := prepareServer(``, http.StatusOK)
+			closeServer()
+
+			err := connector.V2().Health(ctx)
+
+			So(err, ShouldHaveSameTypeAs, &RequestError{})
+			So(RequestIDFromError(err), ShouldNotBeEmpty)
+		})
+
+		Convey("malformed response should be reported as DecodeError", func() {
+			connector, _, closeServer := prepareServer(`not json`, http.StatusOK)
+			defer closeServer()
+
+			_, err := connector.V2().ListLocks(ctx)
+
+			So(err, ShouldHaveSameTypeAs, &DecodeError{})
+		})
+	})
+}
+
+func TestListLocksMalformedResponse(t *testing.T) {
+	Convey("ListLocks should return error instead of panicking on malformed response", t, func() {
+		connector, _, closeServer := prepareServer(`not json`, http.StatusOK)
+		defer closeServer()
+
+		locks, status, err := connector.ListLocks()
+
+		So(err, ShouldNotBeNil)
+		So(status, ShouldEqual, http.StatusOK)
+		So(locks, ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	// parse validates command arguments; nil means positional arguments are passed as they are
+	parse func(args []string, stderr io.Writer) ([]string, error)
+	run   func(broker client.CephBroker, args []string, out printer) error
+}
+
+var commands = []command{
+	{"create", "-fs ext4|ext3|xfs|btrfs <image> <size MB>", "create image formatted with file system", parseCreate, createImage},
+	{"delete", "<image>", "delete image", positionalArgs(1), deleteImage},
+	{"list", "", "list images", positionalArgs(0), listImages},
+	{"info", "<image>", "show details of image", positionalArgs(1), imageInfo},
+	{"locks", "", "list locks of all images", positionalArgs(0), listLocks},
+	{"unlock", "<image> <lock> <locker>", "remove lock of image", positionalArgs(3), removeLock},
+	{"health", "", "check broker health", positionalArgs(0), health},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func positionalArgs(count int) func(args []string, stderr io.Writer) ([]string, error) {
+	return func(args []string, _ io.Writer) ([]string, error) {
+		if len(args) != count {
+			return nil, fmt.Errorf("expected %d arguments, got %d", count, len(args))
+		}
+		return args, nil
+	}
+}
+
+// parseCreate returns image name, size and file system
+func parseCreate(args []string, stderr io.Writer) ([]string, error) {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	fileSystem := flags.String("fs", "", "file system: ext4, ext3, xfs or btrfs")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 2 {
+		return nil, fmt.Errorf("expected 2 arguments, got %d", flags.NArg())
+	}
+	if *fileSystem == "" {
+		return nil, errors.New("file system is required, use -fs")
+	}
+	return []string{flags.Arg(0), flags.Arg(1), *fileSystem}, nil
+}
+
+func createImage(broker client.CephBroker, args []string, out printer) error {
+	var size uint64
+	if _, err := fmt.Sscan(args[1], &size); err != nil || size == 0 {
+		return fmt.Errorf("invalid size %q", args[1])
+	}
+	rbd := model.RBD{ImageName: args[0], Size: size, FileSystem: args[2]}
+	if _, err := broker.CreateRBD(rbd); err != nil {
+		return err
+	}
+	return out.images([]model.RBD{rbd})
+}
+
+func deleteImage(broker client.CephBroker, args []string, out printer) error {
+	_, err := broker.DeleteRBD(args[0])
+	return err
+}
+
+func listImages(broker client.CephBroker, _ []string, out printer) error {
+	images, _, err := broker.ListRBDs()
+	if err != nil {
+		return err
+	}
+	return out.images(images)
+}
+
+func imageInfo(broker client.CephBroker, args []string, out printer) error {
+	image, _, err := broker.GetRBD(args[0])
+	if err != nil {
+		return err
+	}
+	return out.image(image)
+}
+
+func listLocks(broker client.CephBroker, _ []string, out printer) error {
+	locks, _, err := broker.ListLocks()
+	if err != nil {
+		return err
+	}
+	return out.locks(locks)
+}
+
+func removeLock(broker client.CephBroker, args []string, out printer) error {
+	_, err := broker.DeleteLock(model.Lock{ImageName: args[0], LockName: args[1], Locker: args[2]})
+	return err
+}
+
+func health(broker client.CephBroker, _ []string, out printer) error {
+	if _, err := broker.GetCephBrokerHealth(); err != nil {
+		return errors.New("broker is unhealthy: " + err.Error())
+	}
+	return out.health("OK")
+}
+
+// printer writes results as JSON or as table
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) printer {
+	return printer{format: format, w: w}
+}
+
+func (p printer) json(v interface{}) error {
+	output, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(p.w, string(output))
+	return err
+}
+
+func (p printer) table(header string, rows [][]interface{}) error {
+	w := tabwriter.NewWriter(p.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			fmt.Fprint(w, cell)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func (p printer) images(images []model.RBD) error {
+	if p.format == outputJSON {
+		return p.json(images)
+	}
+	rows := [][]interface{}{}
+	for _, image := range images {
+		rows = append(rows, []interface{}{image.ImageName, image.Size, image.FileSystem})
+	}
+	return p.table("NAME\tSIZE (MB)\tFILE SYSTEM", rows)
+}
+
+func (p printer) image(image model.RBD) error {
+	if p.format == outputJSON {
+		return p.json(image)
+	}
+	return p.table("NAME\tSIZE (MB)\tFILE SYSTEM", [][]interface{}{{image.ImageName, image.Size, image.FileSystem}})
+}
+
+func (p printer) locks(locks []model.Lock) error {
+	if p.format == outputJSON {
+		return p.json(locks)
+	}
+	rows := [][]interface{}{}
+	for _, lock := range locks {
+		rows = append(rows, []interface{}{lock.ImageName, lock.LockName, lock.Locker, lock.Address})
+	}
+	return p.table("IMAGE\tLOCK\tLOCKER\tADDRESS", rows)
+}
+
+func (p printer) health(status string) error {
+	if p.format == outputJSON {
+		return p.json(map[string]string{"status": status})
+	}
+	_, err := fmt.Fprintln(p.w, status)
+	return err
+}
@@ -0,0 +1,279 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// tap-ceph-broker-cli is a command-line client of tap-ceph-broker
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/client"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	brokerHttp "github.com/trustedanalytics-ng/tap-go-common/http"
+)
+
+const (
+	// configEnvVarName names variable with path of configuration file in tap-ceph-broker.conf format
+	configEnvVarName = "CEPH_BROKER_CLI_CONFIG"
+
+	outputTable = "table"
+	outputJSON  = "json"
+
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// options of connection to the broker and output format
+type options struct {
+	Address    string
+	User       string
+	Password   string
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	UnixSocket string
+	Output     string
+}
+
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	field func(o *options) *string
+}
+
+var settings = []setting{
+	{"CEPH_BROKER_ADDRESS", "address", "broker address, e.g. https://localhost:8443",
+		func(o *options) *string { return &o.Address }},
+	{"CEPH_BROKER_USER", "user", "user name for basic auth",
+		func(o *options) *string { return &o.User }},
+	{"CEPH_BROKER_PASS", "password", "password for basic auth",
+		func(o *options) *string { return &o.Password }},
+	{"CEPH_BROKER_CLIENT_CERT", "cert", "client certificate file",
+		func(o *options) *string { return &o.CertFile }},
+	{"CEPH_BROKER_CLIENT_KEY", "key", "client private key file",
+		func(o *options) *string { return &o.KeyFile }},
+	{"CEPH_BROKER_CA_CERT", "ca", "CA bundle used to verify broker certificate",
+		func(o *options) *string { return &o.CAFile }},
+	{"CEPH_BROKER_UNIX_SOCKET", "unix-socket", "Unix socket of a local broker, used instead of address",
+		func(o *options) *string { return &o.UnixSocket }},
+	{"CEPH_BROKER_OUTPUT", "output", "output format: \"table\" or \"json\"",
+		func(o *options) *string { return &o.Output }},
+}
+
+// aliases maps alternative names accepted in configuration files and environment to setting names
+var aliases = map[string]string{
+	"CEPH_BROKER_PASSWORD": "CEPH_BROKER_PASS",
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.LookupEnv, os.Stdout, os.Stderr))
+}
+
+// run executes command given in args and returns exit code
+func run(args []string, lookupEnv func(string) (string, bool), stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("tap-ceph-broker-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { usage(flags, stderr) }
+	configFile := flags.String("config", "", "configuration file in tap-ceph-broker.conf format, also read from "+configEnvVarName)
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		flagValues[s.env] = flags.String(s.flag, "", s.usage+", also read from "+s.env)
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		usage(flags, stderr)
+		return exitUsage
+	}
+
+	explicitFlags := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				explicitFlags[s.env] = *flagValues[s.env]
+			}
+		}
+	})
+	opts, err := loadOptions(*configFile, lookupEnv, explicitFlags)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return exitUsage
+	}
+
+	cmd, ok := findCommand(flags.Arg(0))
+	if !ok {
+		fmt.Fprintf(stderr, "error: unknown command %q\n", flags.Arg(0))
+		usage(flags, stderr)
+		return exitUsage
+	}
+	cmdArgs, err := cmd.parse(flags.Args()[1:], stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		fmt.Fprintf(stderr, "usage: tap-ceph-broker-cli [options] %s %s\n", cmd.name, cmd.args)
+		return exitUsage
+	}
+
+	broker, err := newBroker(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return exitError
+	}
+	if err = cmd.run(broker, cmdArgs, newPrinter(opts.Output, stdout)); err != nil {
+		fmt.Fprintln(stderr, "error:", err)
+		return exitError
+	}
+	return exitOK
+}
+
+func usage(flags *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "usage: tap-ceph-broker-cli [options] <command> [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w, "\noptions:")
+	flags.PrintDefaults()
+}
+
+// loadOptions merges configuration file, environment variables and command-line flags, in order of increasing precedence
+func loadOptions(configFile string, lookupEnv func(string) (string, bool), explicitFlags map[string]string) (options, error) {
+	opts := options{Output: outputTable}
+
+	if configFile == "" {
+		configFile, _ = lookupEnv(configEnvVarName)
+	}
+	if configFile != "" {
+		values, err := config.ReadEnvironmentFile(configFile)
+		if err != nil {
+			return opts, err
+		}
+		opts.apply(values)
+	}
+
+	values := map[string]string{}
+	for alias := range aliases {
+		if value, ok := lookupEnv(alias); ok {
+			values[alias] = value
+		}
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.env); ok {
+			values[s.env] = value
+		}
+	}
+	opts.apply(values)
+	opts.apply(explicitFlags)
+
+	return opts, opts.validate()
+}
+
+func (o *options) apply(values map[string]string) {
+	for alias, name := range aliases {
+		if value, ok := values[alias]; ok {
+			if _, set := values[name]; !set {
+				*o.setting(name) = value
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := values[s.env]; ok {
+			*s.field(o) = value
+		}
+	}
+}
+
+func (o *options) setting(env string) *string {
+	for _, s := range settings {
+		if s.env == env {
+			return s.field(o)
+		}
+	}
+	return nil
+}
+
+func (o options) validate() error {
+	if o.Output != outputTable && o.Output != outputJSON {
+		return fmt.Errorf("unknown output format %q, use %q or %q", o.Output, outputTable, outputJSON)
+	}
+	if o.Address == "" && o.UnixSocket == "" {
+		return errors.New("broker address or Unix socket has to be provided")
+	}
+	if o.UnixSocket != "" {
+		return nil
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return errors.New("client certificate and key have to be provided together")
+	}
+	if o.CertFile == "" && (o.User == "" || o.Password == "") {
+		return errors.New("user and password or client certificate have to be provided")
+	}
+	return nil
+}
+
+// newBroker connects over Unix socket if it is given, otherwise over HTTP(S) with client certificate and CA bundle,
+// if given
+func newBroker(o options) (client.CephBroker, error) {
+	address := strings.TrimSuffix(o.Address, "/")
+	switch {
+	case o.UnixSocket != "":
+		return client.NewCephBrokerUnixSocket(o.UnixSocket)
+	case o.CertFile != "" || o.CAFile != "":
+		httpClient, err := newTLSClient(o)
+		if err != nil {
+			return nil, err
+		}
+		return &client.CephBrokerConnector{Address: address, Username: o.User, Password: o.Password, Client: httpClient}, nil
+	default:
+		return client.NewCephBrokerBasicAuth(address, o.User, o.Password)
+	}
+}
+
+// newTLSClient returns HTTP client presenting client certificate, if given, and verifying broker certificate with
+// CA bundle, if given, or with system roots otherwise
+func newTLSClient(o options) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: brokerHttp.IsInsecureSkipVerifyEnabled()}
+	if o.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	if o.CAFile != "" {
+		caPem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %q", o.CAFile)
+		}
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}
+	return &http.Client{Transport: transport, Timeout: brokerHttp.ConnectionTimeout}, nil
+}
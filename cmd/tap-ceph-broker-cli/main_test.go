@@ -0,0 +1,256 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/api"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
+)
+
+const (
+	testUser     = "user"
+	testPassword = "password"
+)
+
+func prepareBroker() (*httptest.Server, *fake.OS) {
+	backend := fake.New()
+	operations := api.NewOperationTracker()
+	c := api.Context{
+		Storage:    storage.New(backend, operations),
+		Operations: operations,
+		Config:     config.Config{User: testUser, Password: testPassword, CephPool: "rbd"},
+	}
+	return httptest.NewServer(api.SetupRouter(&c)), backend
+}
+
+func environment(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func runCLI(env map[string]string, args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(args, environment(env), stdout, stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	Convey("Testing tap-ceph-broker-cli", t, func() {
+		server, backend := prepareBroker()
+		defer server.Close()
+		env := map[string]string{"CEPH_BROKER_ADDRESS": server.URL, "CEPH_BROKER_USER": testUser, "CEPH_BROKER_PASS": testPassword}
+
+		Convey("create should create and format image", func() {
+			code, stdout, stderr := runCLI(env, "create", "-fs", "xfs", "sample", "100")
+
+			So(stderr, ShouldBeEmpty)
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, "NAME    SIZE (MB)  FILE SYSTEM\nsample  100        xfs\n")
+			image, ok := backend.Image("sample")
+			So(ok, ShouldBeTrue)
+			So(image.FileSystem, ShouldEqual, model.XFS)
+		})
+
+		Convey("create should reject invalid size", func() {
+			code, _, stderr := runCLI(env, "create", "-fs", "xfs", "sample", "big")
+
+			So(code, ShouldEqual, exitError)
+			So(stderr, ShouldContainSubstring, `invalid size "big"`)
+		})
+
+		Convey("create should require file system", func() {
+			code, _, stderr := runCLI(env, "create", "sample", "100")
+
+			So(code, ShouldEqual, exitUsage)
+			So(stderr, ShouldContainSubstring, "file system is required, use -fs")
+			_, ok := backend.Image("sample")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("info should print file system recorded at creation", func() {
+			code, _, _ := runCLI(env, "create", "-fs", "ext4", "sample", "100")
+			So(code, ShouldEqual, exitOK)
+
+			code, stdout, _ := runCLI(env, "info", "sample")
+
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, "NAME    SIZE (MB)  FILE SYSTEM\nsample  100        ext4\n")
+		})
+
+		Convey("list should print images as JSON", func() {
+			backend.AddImage("first", 100, model.EXT4)
+			backend.AddImage("second", 200, model.XFS)
+
+			code, stdout, _ := runCLI(env, "-output", "json", "list")
+
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, `[
+  {
+    "imageName": "first",
+    "size": 100,
+    "fileSystem": ""
+  },
+  {
+    "imageName": "second",
+    "size": 200,
+    "fileSystem": ""
+  }
+]
+`)
+		})
+
+		Convey("info should report missing image", func() {
+			code, _, stderr := runCLI(env, "info", "missing")
+
+			So(code, ShouldEqual, exitError)
+			So(stderr, ShouldContainSubstring, "bad response status: 404")
+		})
+
+		Convey("delete should remove image", func() {
+			backend.AddImage("sample", 100, model.EXT4)
+
+			code, stdout, _ := runCLI(env, "delete", "sample")
+
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldBeEmpty)
+			_, ok := backend.Image("sample")
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("locks and unlock should list and remove locks", func() {
+			backend.AddImage("sample", 100, model.EXT4)
+			backend.AddLock("sample", fake.Lock{ID: "lock1", Locker: "client.4100", Address: "10.0.0.1:0/1"})
+
+			code, stdout, _ := runCLI(env, "locks")
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, "IMAGE   LOCK   LOCKER       ADDRESS\nsample  lock1  client.4100  10.0.0.1:0/1\n")
+
+			code, _, _ = runCLI(env, "unlock", "sample", "lock1", "client.4100")
+			So(code, ShouldEqual, exitOK)
+			image, _ := backend.Image("sample")
+			So(image.Locks, ShouldBeEmpty)
+		})
+
+		Convey("health should print status", func() {
+			code, stdout, _ := runCLI(env, "health")
+
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, "OK\n")
+		})
+
+		Convey("flags should take precedence over environment", func() {
+			code, _, stderr := runCLI(env, "-password", "wrong", "list")
+
+			So(code, ShouldEqual, exitError)
+			So(stderr, ShouldContainSubstring, "bad response status: 401")
+		})
+
+		Convey("credentials should be read from configuration file", func() {
+			dir, err := ioutil.TempDir("", "tap-ceph-broker-cli")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "tap-ceph-broker.conf")
+			content := "CEPH_BROKER_USER=\"" + testUser + "\"\nCEPH_BROKER_PASSWORD=\"" + testPassword + "\"\nPORT=\"8443\"\n"
+			So(ioutil.WriteFile(path, []byte(content), 0600), ShouldBeNil)
+
+			code, _, stderr := runCLI(map[string]string{"CEPH_BROKER_ADDRESS": server.URL, configEnvVarName: path}, "health")
+
+			So(stderr, ShouldBeEmpty)
+			So(code, ShouldEqual, exitOK)
+		})
+
+		Convey("CA bundle should verify broker certificate without client certificate", func() {
+			tlsServer := httptest.NewTLSServer(server.Config.Handler)
+			defer tlsServer.Close()
+			dir, err := ioutil.TempDir("", "tap-ceph-broker-cli")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			caPath := filepath.Join(dir, "ca.pem")
+			caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+			So(ioutil.WriteFile(caPath, caPem, 0600), ShouldBeNil)
+			tlsEnv := map[string]string{"CEPH_BROKER_ADDRESS": tlsServer.URL, "CEPH_BROKER_USER": testUser, "CEPH_BROKER_PASS": testPassword}
+
+			code, stdout, stderr := runCLI(tlsEnv, "-ca", caPath, "health")
+
+			So(stderr, ShouldBeEmpty)
+			So(code, ShouldEqual, exitOK)
+			So(stdout, ShouldEqual, "OK\n")
+		})
+
+		Convey("unknown command should be rejected", func() {
+			code, _, stderr := runCLI(env, "resize")
+
+			So(code, ShouldEqual, exitUsage)
+			So(stderr, ShouldContainSubstring, `unknown command "resize"`)
+		})
+
+		Convey("wrong number of arguments should be rejected", func() {
+			code, _, stderr := runCLI(env, "delete")
+
+			So(code, ShouldEqual, exitUsage)
+			So(stderr, ShouldContainSubstring, "usage: tap-ceph-broker-cli [options] delete <image>")
+		})
+	})
+}
+
+func TestLoadOptions(t *testing.T) {
+	Convey("Testing loadOptions", t, func() {
+		env := environment(map[string]string{"CEPH_BROKER_ADDRESS": "https://localhost"})
+
+		Convey("should require address or Unix socket", func() {
+			_, err := loadOptions("", environment(nil), map[string]string{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should require certificate and key together", func() {
+			_, err := loadOptions("", env, map[string]string{"CEPH_BROKER_CLIENT_CERT": "cert.pem", "CEPH_BROKER_CA_CERT": "ca.pem"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("should accept CA bundle with basic auth", func() {
+			opts, err := loadOptions("", env, map[string]string{"CEPH_BROKER_USER": "u", "CEPH_BROKER_PASS": "p", "CEPH_BROKER_CA_CERT": "ca.pem"})
+			So(err, ShouldBeNil)
+			So(opts.CAFile, ShouldEqual, "ca.pem")
+		})
+
+		Convey("should accept certificates without basic auth", func() {
+			opts, err := loadOptions("", env, map[string]string{"CEPH_BROKER_CLIENT_CERT": "cert.pem", "CEPH_BROKER_CLIENT_KEY": "key.pem", "CEPH_BROKER_CA_CERT": "ca.pem"})
+			So(err, ShouldBeNil)
+			So(opts.CAFile, ShouldEqual, "ca.pem")
+		})
+
+		Convey("should reject unknown output format", func() {
+			_, err := loadOptions("", env, map[string]string{"CEPH_BROKER_USER": "u", "CEPH_BROKER_PASS": "p", "CEPH_BROKER_OUTPUT": "yaml"})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
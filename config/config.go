@@ -0,0 +1,592 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FileEnvVarName points to configuration file, when it is not provided with -config flag
+	FileEnvVarName = "CEPH_BROKER_CONFIG"
+
+	defaultBindAddress        = "0.0.0.0"
+	defaultPort               = "80"
+	defaultShutdownTimeout    = 60 * time.Second
+	defaultTrashDeferment     = 7 * 24 * time.Hour
+	defaultReconcileInterval  = 5 * time.Minute
+	defaultTLSMinVersion      = "1.2"
+	defaultCertReloadInterval = time.Minute
+	defaultUnixSocketMode     = 0660
+	defaultCephPool           = "rbd"
+
+	// BackendRBD executes rbd and ceph commands against Ceph cluster
+	BackendRBD = "rbd"
+	// BackendFake emulates rbd and ceph commands in memory, for tests and local development
+	BackendFake = "fake"
+
+	// ClientAuthNone disables client certificates
+	ClientAuthNone = "none"
+	// ClientAuthRequest requests client certificate without verifying it
+	ClientAuthRequest = "request"
+	// ClientAuthVerifyIfGiven verifies client certificate if presented, otherwise basic auth is used
+	ClientAuthVerifyIfGiven = "verify-if-given"
+	// ClientAuthRequire requires verified client certificate from every client
+	ClientAuthRequire = "require"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	ClientAuthNone:          tls.NoClientCert,
+	ClientAuthRequest:       tls.RequestClientCert,
+	ClientAuthVerifyIfGiven: tls.VerifyClientCertIfGiven,
+	ClientAuthRequire:       tls.RequireAndVerifyClientCert,
+}
+
+// Config keeps ceph-broker settings
+type Config struct {
+	User     string
+	Password string
+
+	BindAddress string
+	Port        string
+
+	UnixSocket      string
+	UnixSocketMode  os.FileMode
+	UnixSocketGroup string
+	CSISocket       string
+
+	SSLCertLocation      string
+	SSLKeyLocation       string
+	SSLClientCALocation  string
+	ClientCertPrincipals []string
+
+	TLSMinVersion      string
+	TLSCipherSuites    []string
+	ClientAuth         string
+	CertReloadInterval time.Duration
+
+	CephPool     string
+	CephMonitors []string
+	Backend      string
+	SeedCatalog  string
+	// TrashDeferment is time during which deleted images are kept in trash and can be restored
+	TrashDeferment time.Duration
+	// Admins are principals allowed to delete images immediately and to purge trash before deferment ends
+	Admins []string
+	// OvercommitRatio limits space provisioned in the pool to its capacity multiplied by the ratio, 0 disables the limit
+	OvercommitRatio float64
+
+	AuditLog          string
+	StateFile         string
+	ShutdownTimeout   time.Duration
+	ReconcileInterval time.Duration
+	LogLevel          string
+}
+
+// setting binds single configuration option to its environment variable, YAML key and command-line flag.
+// Environment variable name is also used as a key in tap-ceph-broker.conf files.
+type setting struct {
+	env   string
+	yaml  string
+	flag  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+func stringSetting(field func(c *Config) *string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func durationSetting(field func(c *Config) *time.Duration) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = duration
+		return nil
+	}
+}
+
+func floatSetting(field func(c *Config) *float64) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = number
+		return nil
+	}
+}
+
+func listSetting(field func(c *Config) *[]string) func(c *Config, value string) error {
+	return func(c *Config, value string) error {
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"CEPH_BROKER_USER", "user", "user", "user name for basic auth",
+		stringSetting(func(c *Config) *string { return &c.User })},
+	{"CEPH_BROKER_PASS", "password", "password", "password for basic auth",
+		stringSetting(func(c *Config) *string { return &c.Password })},
+	{"BIND_ADDRESS", "bind_address", "bind-address", "address to listen on",
+		stringSetting(func(c *Config) *string { return &c.BindAddress })},
+	{"PORT", "port", "port", "port to listen on",
+		stringSetting(func(c *Config) *string { return &c.Port })},
+	{"CEPH_BROKER_UNIX_SOCKET", "unix_socket", "unix-socket", "optional Unix socket path for node-local agents",
+		stringSetting(func(c *Config) *string { return &c.UnixSocket })},
+	{"CEPH_BROKER_UNIX_SOCKET_MODE", "unix_socket_mode", "unix-socket-mode", "Unix socket file permissions in octal notation",
+		func(c *Config, value string) error {
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return err
+			}
+			c.UnixSocketMode = os.FileMode(mode)
+			return nil
+		}},
+	{"CEPH_BROKER_UNIX_SOCKET_GROUP", "unix_socket_group", "unix-socket-group", "group owning Unix socket",
+		stringSetting(func(c *Config) *string { return &c.UnixSocketGroup })},
+	{"CEPH_BROKER_CSI_SOCKET", "csi_socket", "csi-socket", "optional Unix socket path of CSI Identity and Controller gRPC services",
+		stringSetting(func(c *Config) *string { return &c.CSISocket })},
+	{"CEPH_BROKER_SSL_CERT_LOCATION", "ssl_cert_location", "ssl-cert", "server certificate file",
+		stringSetting(func(c *Config) *string { return &c.SSLCertLocation })},
+	{"CEPH_BROKER_SSL_KEY_LOCATION", "ssl_key_location", "ssl-key", "server private key file",
+		stringSetting(func(c *Config) *string { return &c.SSLKeyLocation })},
+	{"CEPH_BROKER_SSL_CLIENT_CA_LOCATION", "ssl_client_ca_location", "ssl-client-ca", "CA bundle used to verify client certificates",
+		stringSetting(func(c *Config) *string { return &c.SSLClientCALocation })},
+	{"CEPH_BROKER_CLIENT_CERT_PRINCIPALS", "client_cert_principals", "client-cert-principals", "comma separated client certificate principals allowed to access the API",
+		listSetting(func(c *Config) *[]string { return &c.ClientCertPrincipals })},
+	{"CEPH_BROKER_TLS_MIN_VERSION", "tls_min_version", "tls-min-version", "minimal TLS version: 1.0, 1.1, 1.2 or 1.3",
+		stringSetting(func(c *Config) *string { return &c.TLSMinVersion })},
+	{"CEPH_BROKER_TLS_CIPHER_SUITES", "tls_cipher_suites", "tls-cipher-suites", "comma separated TLS cipher suite names, Go defaults are used if empty",
+		listSetting(func(c *Config) *[]string { return &c.TLSCipherSuites })},
+	{"CEPH_BROKER_CLIENT_AUTH", "client_auth", "client-auth", "client certificate policy: none, request, verify-if-given or require",
+		stringSetting(func(c *Config) *string { return &c.ClientAuth })},
+	{"CEPH_BROKER_CERT_RELOAD_INTERVAL", "cert_reload_interval", "cert-reload-interval", "interval of checking server certificate files for changes",
+		durationSetting(func(c *Config) *time.Duration { return &c.CertReloadInterval })},
+	{"CEPH_BROKER_POOL", "pool", "pool", "Ceph pool of RBD images",
+		stringSetting(func(c *Config) *string { return &c.CephPool })},
+	{"CEPH_BROKER_MONITORS", "monitors", "monitors", "comma separated Ceph monitor addresses returned in binding credentials and manifests",
+		listSetting(func(c *Config) *[]string { return &c.CephMonitors })},
+	{"CEPH_BROKER_BACKEND", "backend", "backend", "storage backend: \"rbd\" or in-memory \"fake\"",
+		stringSetting(func(c *Config) *string { return &c.Backend })},
+	{"CEPH_BROKER_SEED_CATALOG", "seed_catalog", "seed-catalog", "directory of tar archives used as initial content of volumes",
+		stringSetting(func(c *Config) *string { return &c.SeedCatalog })},
+	{"CEPH_BROKER_TRASH_DEFERMENT", "trash_deferment", "trash-deferment", "time during which deleted images can be restored from trash",
+		durationSetting(func(c *Config) *time.Duration { return &c.TrashDeferment })},
+	{"CEPH_BROKER_ADMINS", "admins", "admins", "comma separated principals allowed to delete images immediately, bypassing trash",
+		listSetting(func(c *Config) *[]string { return &c.Admins })},
+	{"CEPH_BROKER_OVERCOMMIT_RATIO", "overcommit_ratio", "overcommit-ratio", "ratio of space which can be provisioned to pool capacity, 0 disables capacity check",
+		floatSetting(func(c *Config) *float64 { return &c.OvercommitRatio })},
+	{"CEPH_BROKER_AUDIT_LOG", "audit_log", "audit-log", "audit log file or \"syslog\"",
+		stringSetting(func(c *Config) *string { return &c.AuditLog })},
+	{"CEPH_BROKER_STATE_FILE", "state_file", "state-file", "file tracking RBD images mapped by the broker",
+		stringSetting(func(c *Config) *string { return &c.StateFile })},
+	{"CEPH_BROKER_SHUTDOWN_TIMEOUT", "shutdown_timeout", "shutdown-timeout", "time to wait for in-flight operations on shutdown",
+		durationSetting(func(c *Config) *time.Duration { return &c.ShutdownTimeout })},
+	{"CEPH_BROKER_RECONCILE_INTERVAL", "reconcile_interval", "reconcile-interval", "interval of reconciliation of mapped devices",
+		durationSetting(func(c *Config) *time.Duration { return &c.ReconcileInterval })},
+	{"BROKER_LOG_LEVEL", "log_level", "log-level", "log level",
+		stringSetting(func(c *Config) *string { return &c.LogLevel })},
+}
+
+// aliases maps alternative names accepted in configuration files and environment to setting names
+var aliases = map[string]string{
+	"CEPH_BROKER_PASSWORD": "CEPH_BROKER_PASS",
+}
+
+// Default returns configuration with default values
+func Default() Config {
+	return Config{
+		BindAddress:          defaultBindAddress,
+		Port:                 defaultPort,
+		UnixSocketMode:       defaultUnixSocketMode,
+		ClientCertPrincipals: []string{},
+		TLSMinVersion:        defaultTLSMinVersion,
+		TLSCipherSuites:      []string{},
+		CertReloadInterval:   defaultCertReloadInterval,
+		CephPool:             defaultCephPool,
+		CephMonitors:         []string{},
+		Backend:              BackendRBD,
+		TrashDeferment:       defaultTrashDeferment,
+		Admins:               []string{},
+		ShutdownTimeout:      defaultShutdownTimeout,
+		ReconcileInterval:    defaultReconcileInterval,
+	}
+}
+
+// ListenAddress returns address the server should listen on
+func (c Config) ListenAddress() string {
+	return fmt.Sprintf("%v:%v", c.BindAddress, c.Port)
+}
+
+// TLSVersion returns minimal TLS version
+func (c Config) TLSVersion() (uint16, error) {
+	version, ok := tlsVersions[c.TLSMinVersion]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version %q", c.TLSMinVersion)
+	}
+	return version, nil
+}
+
+// CipherSuiteIDs returns identifiers of configured cipher suites; nil means Go defaults
+func (c Config) CipherSuiteIDs() ([]uint16, error) {
+	if len(c.TLSCipherSuites) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	ids := []uint16{}
+	for _, name := range c.TLSCipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ClientAuthType returns client certificate policy. If it is not configured,
+// certificates are verified if given when client CA bundle is provided.
+func (c Config) ClientAuthType() (tls.ClientAuthType, error) {
+	policy := c.ClientAuth
+	if policy == "" {
+		policy = ClientAuthNone
+		if c.SSLClientCALocation != "" {
+			policy = ClientAuthVerifyIfGiven
+		}
+	}
+	authType, ok := clientAuthTypes[policy]
+	if !ok {
+		return tls.NoClientCert, fmt.Errorf("invalid client auth policy %q", policy)
+	}
+	return authType, nil
+}
+
+// Load builds configuration from defaults, configuration file, environment variables and command-line arguments,
+// in order of increasing precedence, and validates the result
+func Load(args []string) (Config, error) {
+	c := Default()
+
+	flags := flag.NewFlagSet("tap-ceph-broker", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(FileEnvVarName), "configuration file in tap-ceph-broker.conf or YAML format")
+	flagValues := map[string]*string{}
+	for _, s := range settings {
+		flagValues[s.env] = flags.String(s.flag, "", s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return c, err
+	}
+
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return c, err
+		}
+		if err = c.apply(values, "file "+*configFile); err != nil {
+			return c, err
+		}
+	}
+
+	if err := c.apply(environment(), "environment"); err != nil {
+		return c, err
+	}
+
+	explicitFlags := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				explicitFlags[s.env] = *flagValues[s.env]
+			}
+		}
+	})
+	if err := c.apply(explicitFlags, "command-line"); err != nil {
+		return c, err
+	}
+
+	return c, c.Validate()
+}
+
+func (c *Config) apply(values map[string]string, source string) error {
+	for _, s := range settings {
+		value, ok := values[s.env]
+		if !ok {
+			continue
+		}
+		if err := s.set(c, value); err != nil {
+			return fmt.Errorf("invalid %s in %s: %v", s.env, source, err)
+		}
+	}
+	return nil
+}
+
+func environment() map[string]string {
+	values := map[string]string{}
+	for alias, name := range aliases {
+		if value, ok := os.LookupEnv(alias); ok {
+			values[name] = value
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			values[s.env] = value
+		}
+	}
+	return values
+}
+
+// readFile reads YAML file if it has .yaml or .yml extension, otherwise tap-ceph-broker.conf format is assumed
+func readFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration file: %v", err)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAML(content)
+	default:
+		return parseConf(content)
+	}
+}
+
+// parseConf parses KEY="value" lines as in shipped tap-ceph-broker.conf. Unknown keys are skipped,
+// as the same file is used as systemd environment file.
+func parseConf(content []byte) (map[string]string, error) {
+	return parseLines(content, func(key string) (string, bool) {
+		key = strings.TrimPrefix(key, "export ")
+		if name, ok := aliases[key]; ok {
+			return name, true
+		}
+		for _, s := range settings {
+			if s.env == key {
+				return s.env, true
+			}
+		}
+		return "", false
+	})
+}
+
+// ReadEnvironmentFile reads all KEY="value" lines of file in tap-ceph-broker.conf format,
+// so that other tools can share it with the broker
+func ReadEnvironmentFile(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read configuration file: %v", err)
+	}
+	return parseLines(content, func(key string) (string, bool) {
+		return strings.TrimPrefix(key, "export "), true
+	})
+}
+
+// parseYAML parses flat YAML mapping of scalar values
+func parseYAML(content []byte) (map[string]string, error) {
+	document := yaml.MapSlice{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, item := range document {
+		key := fmt.Sprint(item.Key)
+		name := ""
+		for _, s := range settings {
+			if s.yaml == key {
+				name = s.env
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		switch value := item.Value.(type) {
+		case nil:
+			values[name] = ""
+		case yaml.MapSlice, []interface{}:
+			return nil, fmt.Errorf("setting %q: nested values are not supported", key)
+		default:
+			values[name] = fmt.Sprint(value)
+		}
+	}
+	return values, nil
+}
+
+func parseLines(content []byte, settingName func(key string) (string, bool)) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		trimmed := strings.TrimSpace(scanner.Text())
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key=value", lineNumber)
+		}
+		key := strings.TrimSpace(parts[0])
+		name, ok := settingName(key)
+		if !ok {
+			continue
+		}
+		value, err := unquote(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+func unquote(value string) (string, error) {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+		if value[len(value)-1] != value[0] {
+			return "", errors.New("unterminated quoted value")
+		}
+		return value[1 : len(value)-1], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+func validateReadableFile(name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return file.Close()
+}
+
+// Validate checks that configuration is complete and consistent
+func (c Config) Validate() error {
+	if c.SSLCertLocation == "" || c.SSLKeyLocation == "" {
+		return errors.New("only SSL protocol is supported: server certificate and key locations are required")
+	}
+	if err := validateReadableFile("server certificate", c.SSLCertLocation); err != nil {
+		return err
+	}
+	if err := validateReadableFile("server key", c.SSLKeyLocation); err != nil {
+		return err
+	}
+	if c.SSLClientCALocation != "" {
+		if err := validateReadableFile("client CA bundle", c.SSLClientCALocation); err != nil {
+			return err
+		}
+	}
+	if (c.User == "") != (c.Password == "") {
+		return errors.New("both user and password have to be provided for basic auth")
+	}
+	if c.User == "" && c.SSLClientCALocation == "" {
+		return errors.New("no authentication method configured: provide user and password or client CA bundle")
+	}
+	if c.UnixSocketMode&^os.ModePerm != 0 {
+		return fmt.Errorf("invalid Unix socket mode %o", c.UnixSocketMode)
+	}
+	if (c.UnixSocket != "" || c.CSISocket != "") && c.UnixSocketMode&0007 != 0 {
+		return fmt.Errorf("Unix socket mode %o gives access to all local users", c.UnixSocketMode)
+	}
+	if c.CSISocket != "" && c.CSISocket == c.UnixSocket {
+		return errors.New("CSI socket has to differ from Unix socket of REST API")
+	}
+	if c.UnixSocketGroup != "" {
+		if _, err := user.LookupGroup(c.UnixSocketGroup); err != nil {
+			return fmt.Errorf("invalid Unix socket group: %v", err)
+		}
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", c.Port)
+	}
+	if _, err := c.TLSVersion(); err != nil {
+		return err
+	}
+	if _, err := c.CipherSuiteIDs(); err != nil {
+		return err
+	}
+	authType, err := c.ClientAuthType()
+	if err != nil {
+		return err
+	}
+	if (authType == tls.VerifyClientCertIfGiven || authType == tls.RequireAndVerifyClientCert) && c.SSLClientCALocation == "" {
+		return fmt.Errorf("client auth policy %q requires client CA bundle", c.ClientAuth)
+	}
+	if c.CertReloadInterval <= 0 {
+		return fmt.Errorf("certificate reload interval has to be positive, got %v", c.CertReloadInterval)
+	}
+	if c.CephPool == "" {
+		return errors.New("Ceph pool cannot be empty")
+	}
+	if c.Backend != BackendRBD && c.Backend != BackendFake {
+		return fmt.Errorf("unknown backend %q, use %q or %q", c.Backend, BackendRBD, BackendFake)
+	}
+	if c.TrashDeferment < 0 {
+		return fmt.Errorf("trash deferment cannot be negative, got %v", c.TrashDeferment)
+	}
+	if c.OvercommitRatio < 0 || math.IsNaN(c.OvercommitRatio) || math.IsInf(c.OvercommitRatio, 0) {
+		return fmt.Errorf("overcommit ratio cannot be negative, got %v", c.OvercommitRatio)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown timeout has to be positive, got %v", c.ShutdownTimeout)
+	}
+	if c.ReconcileInterval <= 0 {
+		return fmt.Errorf("reconcile interval has to be positive, got %v", c.ReconcileInterval)
+	}
+	if c.LogLevel != "" {
+		switch strings.ToUpper(c.LogLevel) {
+		case "CRITICAL", "ERROR", "WARNING", "NOTICE", "INFO", "DEBUG":
+		default:
+			return fmt.Errorf("invalid log level %q", c.LogLevel)
+		}
+	}
+	return nil
+}
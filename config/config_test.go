@@ -0,0 +1,241 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func clearEnvironment() {
+	os.Unsetenv(FileEnvVarName)
+	for alias := range aliases {
+		os.Unsetenv(alias)
+	}
+	for _, s := range settings {
+		os.Unsetenv(s.env)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	Convey("Testing Load", t, func() {
+		clearEnvironment()
+		dir, err := ioutil.TempDir("", "config")
+		So(err, ShouldBeNil)
+		cert := filepath.Join(dir, "cert.pem")
+		key := filepath.Join(dir, "key.pem")
+		So(ioutil.WriteFile(cert, []byte("cert"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(key, []byte("key"), 0600), ShouldBeNil)
+
+		writeFile := func(name, content string) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, []byte(content), 0600), ShouldBeNil)
+			return path
+		}
+
+		Convey("When tap-ceph-broker.conf file is given", func() {
+			path := writeFile("tap-ceph-broker.conf", `# tap-ceph-broker configuration options
+
+CEPH_BROKER_USER="admin"
+
+CEPH_BROKER_PASSWORD="password"
+
+PORT="49999"
+INSECURE_SKIP_VERIFY=false
+CEPH_BROKER_SSL_CERT_LOCATION="`+cert+`"
+CEPH_BROKER_SSL_KEY_LOCATION="`+key+`"
+CEPH_BROKER_CLIENT_CERT_PRINCIPALS="worker-1, worker-2"
+`)
+
+			cfg, err := Load([]string{"-config", path})
+
+			So(err, ShouldBeNil)
+			So(cfg.User, ShouldEqual, "admin")
+			So(cfg.Password, ShouldEqual, "password")
+			So(cfg.ListenAddress(), ShouldEqual, "0.0.0.0:49999")
+			So(cfg.ClientCertPrincipals, ShouldResemble, []string{"worker-1", "worker-2"})
+			So(cfg.ShutdownTimeout, ShouldEqual, defaultShutdownTimeout)
+		})
+
+		Convey("When YAML file is given", func() {
+			path := writeFile("config.yaml", `---
+user: admin
+password: 'secret'
+port: 8443 # comment
+ssl_cert_location: `+cert+`
+ssl_key_location: `+key+`
+shutdown_timeout: 90s
+`)
+
+			cfg, err := Load([]string{"-config", path})
+
+			So(err, ShouldBeNil)
+			So(cfg.Password, ShouldEqual, "secret")
+			So(cfg.Port, ShouldEqual, "8443")
+			So(cfg.ShutdownTimeout, ShouldEqual, 90*time.Second)
+		})
+
+		Convey("When YAML file contains unknown setting", func() {
+			path := writeFile("config.yml", "unknown: value\n")
+
+			_, err := Load([]string{"-config", path})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When YAML file contains nested value", func() {
+			path := writeFile("config.yaml", "user:\n  name: admin\n")
+
+			_, err := Load([]string{"-config", path})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When environment and flags are given they take precedence over file", func() {
+			path := writeFile("config.yaml", "user: admin\npassword: secret\nport: 8443\nssl_cert_location: "+cert+"\nssl_key_location: "+key+"\n")
+			os.Setenv(FileEnvVarName, path)
+			os.Setenv("PORT", "9443")
+			os.Setenv("CEPH_BROKER_USER", "operator")
+
+			cfg, err := Load([]string{"-user", "agent"})
+
+			So(err, ShouldBeNil)
+			So(cfg.Port, ShouldEqual, "9443")
+			So(cfg.User, ShouldEqual, "agent")
+		})
+
+		Convey("When certificate is missing", func() {
+			_, err := Load([]string{"-user", "admin", "-password", "secret"})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When no authentication method is configured", func() {
+			_, err := Load([]string{"-ssl-cert", cert, "-ssl-key", key})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When duration is malformed", func() {
+			os.Setenv("CEPH_BROKER_RECONCILE_INTERVAL", "often")
+
+			_, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When TLS settings are given", func() {
+			cfg, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key,
+				"-tls-min-version", "1.3", "-tls-cipher-suites", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+
+			So(err, ShouldBeNil)
+			version, _ := cfg.TLSVersion()
+			So(version, ShouldEqual, tls.VersionTLS13)
+			suites, _ := cfg.CipherSuiteIDs()
+			So(suites, ShouldResemble, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256})
+		})
+
+		Convey("When cipher suite is unknown", func() {
+			_, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key,
+				"-tls-cipher-suites", "TLS_RSA_WITH_RC4_128_SHA"})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When client certificates are required without CA bundle", func() {
+			_, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key,
+				"-client-auth", ClientAuthRequire})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When client auth policy is not given it depends on CA bundle", func() {
+			cfg := Default()
+			authType, err := cfg.ClientAuthType()
+			So(err, ShouldBeNil)
+			So(authType, ShouldEqual, tls.NoClientCert)
+
+			cfg.SSLClientCALocation = cert
+			authType, err = cfg.ClientAuthType()
+			So(err, ShouldBeNil)
+			So(authType, ShouldEqual, tls.VerifyClientCertIfGiven)
+		})
+
+		Convey("When backend is selected", func() {
+			cfg, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-backend", BackendFake})
+			So(err, ShouldBeNil)
+			So(cfg.Backend, ShouldEqual, BackendFake)
+
+			_, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-backend", "ceph"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When overcommit ratio is given", func() {
+			cfg, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-overcommit-ratio", "1.5"})
+			So(err, ShouldBeNil)
+			So(cfg.OvercommitRatio, ShouldEqual, 1.5)
+
+			_, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-overcommit-ratio", "-1"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When trash settings are given", func() {
+			cfg, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key})
+			So(err, ShouldBeNil)
+			So(cfg.TrashDeferment, ShouldEqual, defaultTrashDeferment)
+			So(cfg.Admins, ShouldBeEmpty)
+
+			os.Setenv("CEPH_BROKER_ADMINS", "admin, ops@example.com")
+			cfg, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-trash-deferment", "48h"})
+			So(err, ShouldBeNil)
+			So(cfg.TrashDeferment, ShouldEqual, 48*time.Hour)
+			So(cfg.Admins, ShouldResemble, []string{"admin", "ops@example.com"})
+
+			_, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-trash-deferment", "-1h"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When CSI socket is given", func() {
+			cfg, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-csi-socket", "/run/csi.sock"})
+			So(err, ShouldBeNil)
+			So(cfg.CSISocket, ShouldEqual, "/run/csi.sock")
+
+			_, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-csi-socket", "/run/csi.sock", "-unix-socket", "/run/csi.sock"})
+			So(err, ShouldNotBeNil)
+
+			_, err = Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-csi-socket", "/run/csi.sock", "-unix-socket-mode", "0666"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When port is invalid", func() {
+			_, err := Load([]string{"-user", "admin", "-password", "secret", "-ssl-cert", cert, "-ssl-key", key, "-port", "http"})
+
+			So(err, ShouldNotBeNil)
+		})
+
+		Reset(func() {
+			clearEnvironment()
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -17,38 +17,108 @@
 package main
 
 import (
+	"net/http"
 	"os"
 
 	"github.com/gocraft/web"
+	"google.golang.org/grpc"
 
 	"github.com/trustedanalytics-ng/tap-ceph-broker/api"
-	httpGoCommon "github.com/trustedanalytics-ng/tap-go-common/http"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/audit"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage/fake"
 	commonLogger "github.com/trustedanalytics-ng/tap-go-common/logger"
 	commonOS "github.com/trustedanalytics-ng/tap-go-common/os"
 )
 
-const (
-	sslCertLocationEnvVarName = "CEPH_BROKER_SSL_CERT_LOCATION"
-	sslKeyLocationEnvVarName  = "CEPH_BROKER_SSL_KEY_LOCATION"
-)
-
 var logger, _ = commonLogger.InitLogger("main")
 
 func main() {
-	sos := commonOS.StandardOS{}
-	context := api.Context{OS: sos}
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.LogLevel != "" {
+		if err = setLoggerLevel(cfg.LogLevel); err != nil {
+			logger.Fatalf("Cannot set log level: %v", err)
+		}
+	}
+
+	var sos commonOS.OS = commonOS.StandardOS{}
+	if cfg.Backend == config.BackendFake {
+		logger.Warning("Using in-memory fake backend, RBD images are not stored in Ceph")
+		sos = fake.New()
+	}
+	operations := api.NewOperationTracker()
+	if cfg.StateFile != "" {
+		if operations, err = api.NewPersistentOperationTracker(cfg.StateFile); err != nil {
+			logger.Fatalf("Cannot load state file %q: %v", cfg.StateFile, err)
+		}
+	}
+	service := storage.New(sos, operations)
+	service.SeedCatalog = cfg.SeedCatalog
+	service.Pool = cfg.CephPool
+	service.OvercommitRatio = cfg.OvercommitRatio
+	brokerContext := api.Context{Storage: service, Config: cfg, Operations: operations}
+
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.New(cfg.AuditLog)
+		if err != nil {
+			logger.Fatalf("Cannot initialize audit log: %v", err)
+		}
+		brokerContext.Audit = auditLog
+	}
+
+	router := api.SetupRouter(&brokerContext)
 
-	router := api.SetupRouter(&context)
+	stopReconciliation := make(chan struct{})
+	brokerContext.StartReconciliation(cfg.ReconcileInterval, stopReconciliation)
 
-	startServer(router)
+	servers := []*http.Server{startServer(router, cfg)}
+	if cfg.UnixSocket != "" {
+		servers = append(servers, startUnixServer(router, cfg))
+	}
+	var csiServer *grpc.Server
+	if cfg.CSISocket != "" {
+		csiServer = startCSIServer(&brokerContext, cfg)
+	}
+	waitForShutdown(servers, csiServer, &brokerContext, cfg.ShutdownTimeout, stopReconciliation)
+}
+
+func setLoggerLevel(level string) error {
+	if err := commonLogger.SetLoggerLevel(logger, level); err != nil {
+		return err
+	}
+	if err := storage.SetLoggerLevel(level); err != nil {
+		return err
+	}
+	return api.SetLoggerLevel(level)
 }
 
-func startServer(router *web.Router) {
-	cert := os.Getenv(sslCertLocationEnvVarName)
-	key := os.Getenv(sslKeyLocationEnvVarName)
-	if cert == "" || key == "" {
-		logger.Fatalf("Only SSL protocol is supported. You need to provide environment variables %q and %q.", sslCertLocationEnvVarName, sslKeyLocationEnvVarName)
-	} else {
-		httpGoCommon.StartServerTLS(cert, key, router)
+// startServer starts serving TLS in background. Process exits if server cannot be started.
+func startServer(router *web.Router, cfg config.Config) *http.Server {
+	reloader, err := newCertReloader(cfg.SSLCertLocation, cfg.SSLKeyLocation)
+	if err != nil {
+		logger.Fatalf("Cannot load server certificate: %v", err)
+	}
+	reloader.watch(cfg.CertReloadInterval)
+
+	tlsConfig, err := getTLSConfig(cfg, reloader)
+	if err != nil {
+		logger.Fatalf("Cannot prepare TLS configuration: %v", err)
+	}
+
+	server := &http.Server{
+		Addr:      cfg.ListenAddress(),
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
+	logger.Infof("TLS Will listen on: %s", server.Addr)
+	go func() {
+		if err := server.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			logger.Fatal("Couldn't serve app on ", server.Addr, " Error:", err)
+		}
+	}()
+	return server
 }
@@ -0,0 +1,40 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import "time"
+
+// AuditEntry describes single mutating operation executed by ceph-broker
+type AuditEntry struct {
+	Time          time.Time              `json:"time"`
+	RequestID     string                 `json:"requestId,omitempty"`
+	Principal     string                 `json:"principal"`
+	SourceAddress string                 `json:"sourceAddress"`
+	Operation     string                 `json:"operation"`
+	ImageName     string                 `json:"imageName,omitempty"`
+	LockName      string                 `json:"lockName,omitempty"`
+	Locker        string                 `json:"locker,omitempty"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	Outcome       string                 `json:"outcome"`
+	Status        int                    `json:"status"`
+	DurationMs    int64                  `json:"durationMs"`
+}
+
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
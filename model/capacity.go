@@ -0,0 +1,30 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+// InsufficientCapacityResponse is returned with status 507 when request would exceed capacity of the pool.
+// Space which can be provisioned is pool capacity multiplied by overcommit ratio.
+type InsufficientCapacityResponse struct {
+	Message          string  `json:"message"`
+	RequestID        string  `json:"requestId,omitempty"`
+	Pool             string  `json:"pool"`
+	CapacityBytes    uint64  `json:"capacityBytes"`
+	OvercommitRatio  float64 `json:"overcommitRatio"`
+	ProvisionedBytes uint64  `json:"provisionedBytes"`
+	AvailableBytes   uint64  `json:"availableBytes"`
+	RequestedBytes   uint64  `json:"requestedBytes"`
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import "time"
+
+// Messages of CSI Controller service (github.com/container-storage-interface/spec, v1), limited to the fields
+// used by the broker. Field names follow csi.proto; api.NewCSIServer copies them to and from generated types.
+
+// CSICode mirrors gRPC status code returned by CSI Controller service
+type CSICode int
+
+const (
+	CSICodeOK                 CSICode = 0
+	CSICodeInvalidArgument    CSICode = 3
+	CSICodeNotFound           CSICode = 5
+	CSICodeAlreadyExists      CSICode = 6
+	CSICodeResourceExhausted  CSICode = 8
+	CSICodeFailedPrecondition CSICode = 9
+	CSICodeAborted            CSICode = 10
+	CSICodeOutOfRange         CSICode = 11
+	CSICodeInternal           CSICode = 13
+)
+
+// CSIError is returned by CSI Controller service calls
+type CSIError struct {
+	Code    CSICode `json:"code"`
+	Message string  `json:"message"`
+}
+
+func (e *CSIError) Error() string {
+	return e.Message
+}
+
+// CapacityRange limits size of the volume in bytes, zero means no limit
+type CapacityRange struct {
+	RequiredBytes int64 `json:"required_bytes"`
+	LimitBytes    int64 `json:"limit_bytes"`
+}
+
+// VolumeCapability describes how volume is going to be accessed; Block and Mount are exclusive
+type VolumeCapability struct {
+	Block      bool   `json:"block,omitempty"`
+	FsType     string `json:"fs_type,omitempty"`
+	AccessMode string `json:"access_mode"`
+}
+
+// Access modes supported by RBD volumes, named as csi.proto VolumeCapability.AccessMode.Mode values
+const (
+	CSIAccessModeSingleNodeWriter     = "SINGLE_NODE_WRITER"
+	CSIAccessModeSingleNodeReaderOnly = "SINGLE_NODE_READER_ONLY"
+	CSIAccessModeMultiNodeReaderOnly  = "MULTI_NODE_READER_ONLY"
+)
+
+// Volume is RBD image exposed through CSI
+type Volume struct {
+	VolumeID      string            `json:"volume_id"`
+	CapacityBytes int64             `json:"capacity_bytes"`
+	VolumeContext map[string]string `json:"volume_context,omitempty"`
+}
+
+// Snapshot is RBD image snapshot exposed through CSI
+type Snapshot struct {
+	SnapshotID     string    `json:"snapshot_id"`
+	SourceVolumeID string    `json:"source_volume_id"`
+	SizeBytes      int64     `json:"size_bytes"`
+	CreationTime   time.Time `json:"creation_time"`
+	ReadyToUse     bool      `json:"ready_to_use"`
+}
+
+// CreateVolumeRequest asks for volume of given name; calls with the same name and compatible size are idempotent
+type CreateVolumeRequest struct {
+	Name               string             `json:"name"`
+	CapacityRange      *CapacityRange     `json:"capacity_range,omitempty"`
+	VolumeCapabilities []VolumeCapability `json:"volume_capabilities"`
+	Parameters         map[string]string  `json:"parameters,omitempty"`
+}
+
+// CreateVolumeResponse describes created or already existing volume
+type CreateVolumeResponse struct {
+	Volume Volume `json:"volume"`
+}
+
+// DeleteVolumeRequest identifies volume to delete
+type DeleteVolumeRequest struct {
+	VolumeID string `json:"volume_id"`
+}
+
+// ControllerExpandVolumeRequest asks to grow volume to satisfy capacity range
+type ControllerExpandVolumeRequest struct {
+	VolumeID      string         `json:"volume_id"`
+	CapacityRange *CapacityRange `json:"capacity_range"`
+}
+
+// ControllerExpandVolumeResponse returns new size of volume and whether file system has to be expanded on the node
+type ControllerExpandVolumeResponse struct {
+	CapacityBytes         int64 `json:"capacity_bytes"`
+	NodeExpansionRequired bool  `json:"node_expansion_required"`
+}
+
+// CreateSnapshotRequest asks for snapshot of source volume with given name
+type CreateSnapshotRequest struct {
+	SourceVolumeID string `json:"source_volume_id"`
+	Name           string `json:"name"`
+}
+
+// CreateSnapshotResponse describes created or already existing snapshot
+type CreateSnapshotResponse struct {
+	Snapshot Snapshot `json:"snapshot"`
+}
+
+// ListVolumesRequest asks for page of volumes; zero MaxEntries means no limit
+type ListVolumesRequest struct {
+	MaxEntries    int32  `json:"max_entries"`
+	StartingToken string `json:"starting_token"`
+}
+
+// ListVolumesResponse contains page of volumes and token of the next page, empty on the last one
+type ListVolumesResponse struct {
+	Entries   []Volume `json:"entries"`
+	NextToken string   `json:"next_token"`
+}
+
+// Controller service capabilities supported by the broker
+const (
+	CSICapabilityCreateDeleteVolume   = "CREATE_DELETE_VOLUME"
+	CSICapabilityCreateDeleteSnapshot = "CREATE_DELETE_SNAPSHOT"
+	CSICapabilityListVolumes          = "LIST_VOLUMES"
+	CSICapabilityExpandVolume         = "EXPAND_VOLUME"
+)
@@ -0,0 +1,35 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+// FsckRequest selects check-only or repair mode of file system check. File system is detected if it is not given.
+type FsckRequest struct {
+	Repair     bool   `json:"repair"`
+	FileSystem string `json:"fileSystem,omitempty"`
+}
+
+// FsckResult reports exit status and output of file system check tool
+type FsckResult struct {
+	ImageName  string `json:"imageName"`
+	FileSystem string `json:"fileSystem"`
+	Repair     bool   `json:"repair"`
+	Command    string `json:"command"`
+	ExitStatus int    `json:"exitStatus"`
+	// Clean is true if no errors were found or all of them were repaired
+	Clean  bool   `json:"clean"`
+	Report string `json:"report"`
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+// Subset of Kubernetes core/v1 API objects needed to use RBD image as a PersistentVolume
+
+// ObjectMeta is metadata of Kubernetes object
+type ObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// ObjectReference points to Kubernetes object in a namespace
+type ObjectReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// LocalObjectReference points to Kubernetes object in the same namespace
+type LocalObjectReference struct {
+	Name string `json:"name"`
+}
+
+// RBDVolumeSource is rbd volume source of PersistentVolume
+type RBDVolumeSource struct {
+	CephMonitors []string              `json:"monitors"`
+	RBDImage     string                `json:"image"`
+	FSType       string                `json:"fsType"`
+	RBDPool      string                `json:"pool"`
+	RadosUser    string                `json:"user"`
+	SecretRef    *LocalObjectReference `json:"secretRef"`
+	ReadOnly     bool                  `json:"readOnly,omitempty"`
+}
+
+// PersistentVolumeSpec describes RBD backed PersistentVolume
+type PersistentVolumeSpec struct {
+	Capacity                      map[string]string `json:"capacity"`
+	AccessModes                   []string          `json:"accessModes"`
+	PersistentVolumeReclaimPolicy string            `json:"persistentVolumeReclaimPolicy"`
+	StorageClassName              string            `json:"storageClassName,omitempty"`
+	ClaimRef                      *ObjectReference  `json:"claimRef,omitempty"`
+	RBD                           *RBDVolumeSource  `json:"rbd"`
+}
+
+// PersistentVolume is Kubernetes PersistentVolume manifest
+type PersistentVolume struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Metadata   ObjectMeta           `json:"metadata"`
+	Spec       PersistentVolumeSpec `json:"spec"`
+}
+
+// ResourceRequirements holds storage request of PersistentVolumeClaim
+type ResourceRequirements struct {
+	Requests map[string]string `json:"requests"`
+}
+
+// PersistentVolumeClaimSpec binds claim to the PersistentVolume
+type PersistentVolumeClaimSpec struct {
+	AccessModes      []string             `json:"accessModes"`
+	Resources        ResourceRequirements `json:"resources"`
+	StorageClassName *string              `json:"storageClassName,omitempty"`
+	VolumeName       string               `json:"volumeName"`
+}
+
+// PersistentVolumeClaim is Kubernetes PersistentVolumeClaim manifest
+type PersistentVolumeClaim struct {
+	APIVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Metadata   ObjectMeta                `json:"metadata"`
+	Spec       PersistentVolumeClaimSpec `json:"spec"`
+}
+
+// KubernetesList groups several manifests in a single document
+type KubernetesList struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Items      []interface{} `json:"items"`
+}
+
+const (
+	AccessModeReadWriteOnce = "ReadWriteOnce"
+	AccessModeReadOnlyMany  = "ReadOnlyMany"
+)
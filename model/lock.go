@@ -23,3 +23,19 @@ type Lock struct {
 	Locker    string `json:"locker"`
 	Address   string `json:"address"`
 }
+
+// Watcher represents client which has ceph RBD open, e.g. mapped it on a node
+type Watcher struct {
+	// Client is given like Locker of Lock, e.g. "client.4239"
+	Client  string `json:"client"`
+	Address string `json:"address"`
+	Cookie  uint64 `json:"cookie"`
+}
+
+// ImageInUseResponse is returned with status 409 when operation is refused because RBD is in use
+type ImageInUseResponse struct {
+	Message   string    `json:"message"`
+	RequestID string    `json:"requestId,omitempty"`
+	Watchers  []Watcher `json:"watchers"`
+	Locks     []Lock    `json:"locks"`
+}
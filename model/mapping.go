@@ -0,0 +1,35 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import "time"
+
+// MappedDevice represents RBD image mapped to block device on the broker host
+type MappedDevice struct {
+	ImageName string `json:"imageName"`
+	Pool      string `json:"pool"`
+	Device    string `json:"device"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ReconcileReport summarizes reconciliation pass which unmaps devices left mapped by the broker
+type ReconcileReport struct {
+	Time     time.Time      `json:"time"`
+	Unmapped []MappedDevice `json:"unmapped"`
+	Failed   []MappedDevice `json:"failed"`
+	Error    string         `json:"error,omitempty"`
+}
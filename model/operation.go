@@ -0,0 +1,53 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import "time"
+
+// RenameRequest gives new name of RBD in the pool
+type RenameRequest struct {
+	NewName string `json:"newName"`
+}
+
+// CopyRequest describes copy of RBD, by default placed in the pool of the broker
+type CopyRequest struct {
+	DestinationName string `json:"destinationName"`
+	DestinationPool string `json:"destinationPool,omitempty"`
+	// Deep copy includes snapshots of RBD
+	Deep bool `json:"deep,omitempty"`
+}
+
+const (
+	ImageOperationRename = "rename"
+	ImageOperationCopy   = "copy"
+)
+
+// ImageOperation reports state of rename or copy of RBD. State is one of OperationInProgress, OperationSucceeded
+// and OperationFailed, Progress is given in percent.
+type ImageOperation struct {
+	ID              string     `json:"id"`
+	Type            string     `json:"type"`
+	ImageName       string     `json:"imageName"`
+	DestinationName string     `json:"destinationName"`
+	DestinationPool string     `json:"destinationPool,omitempty"`
+	Deep            bool       `json:"deep,omitempty"`
+	State           string     `json:"state"`
+	Progress        int        `json:"progress"`
+	Description     string     `json:"description,omitempty"`
+	StartedAt       time.Time  `json:"startedAt"`
+	FinishedAt      *time.Time `json:"finishedAt,omitempty"`
+}
@@ -0,0 +1,97 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+// Catalog is returned by Open Service Broker API catalog endpoint
+type Catalog struct {
+	Services []Service `json:"services"`
+}
+
+// Service describes service offered by the broker in Open Service Broker API
+type Service struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	Bindable       bool     `json:"bindable"`
+	PlanUpdateable bool     `json:"plan_updateable"`
+	Tags           []string `json:"tags,omitempty"`
+	Plans          []Plan   `json:"plans"`
+}
+
+// Plan describes RBD size and file system preset
+type Plan struct {
+	ID          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Free        bool                   `json:"free"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// ProvisionRequest is sent by platform to create service instance
+type ProvisionRequest struct {
+	ServiceID        string                 `json:"service_id"`
+	PlanID           string                 `json:"plan_id"`
+	OrganizationGUID string                 `json:"organization_guid"`
+	SpaceGUID        string                 `json:"space_guid"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// ProvisionResponse is returned for created service instance
+type ProvisionResponse struct {
+	Operation string `json:"operation,omitempty"`
+}
+
+// LastOperation reports state of asynchronous operation
+type LastOperation struct {
+	State       string `json:"state"`
+	Description string `json:"description,omitempty"`
+}
+
+const (
+	OperationInProgress = "in progress"
+	OperationSucceeded  = "succeeded"
+	OperationFailed     = "failed"
+)
+
+// BindRequest is sent by platform to create service binding
+type BindRequest struct {
+	ServiceID  string                 `json:"service_id"`
+	PlanID     string                 `json:"plan_id"`
+	AppGUID    string                 `json:"app_guid,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// BindResponse contains credentials giving access to RBD image of service instance
+type BindResponse struct {
+	Credentials RBDCredentials `json:"credentials"`
+}
+
+// RBDCredentials are used by application to map RBD image
+type RBDCredentials struct {
+	Pool       string   `json:"pool"`
+	ImageName  string   `json:"imageName"`
+	FileSystem string   `json:"fileSystem"`
+	Monitors   []string `json:"monitors"`
+	User       string   `json:"user"`
+	Key        string   `json:"key"`
+}
+
+// OSBError is returned by Open Service Broker API endpoints on failure
+type OSBError struct {
+	Error       string `json:"error,omitempty"`
+	Description string `json:"description"`
+}
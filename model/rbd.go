@@ -18,12 +18,45 @@ package model
 
 // RBD represents ceph RBD instance
 type RBD struct {
-	ImageName  string `json:"imageName"`
-	Size       uint64 `json:"size"`
-	FileSystem string `json:"fileSystem"`
+	ImageName   string       `json:"imageName"`
+	Size        uint64       `json:"size"`
+	FileSystem  string       `json:"fileSystem"`
+	MkfsOptions *MkfsOptions `json:"mkfsOptions,omitempty"`
+	// InitialContent is unpacked to the file system after formatting
+	InitialContent *InitialContent `json:"initialContent,omitempty"`
+	// Labels are stored as image-meta with "label." prefix
+	Labels map[string]string `json:"labels,omitempty"`
+	// Owner is principal which created RBD, set by the broker and stored as image-meta
+	Owner string `json:"owner,omitempty"`
+}
+
+// MkfsOptions are passed to mkfs when RBD is formatted; supported options depend on file system
+type MkfsOptions struct {
+	Label string `json:"label,omitempty"`
+	UUID  string `json:"uuid,omitempty"`
+	// InodeSize in bytes, supported by ext3, ext4 and xfs
+	InodeSize uint64 `json:"inodeSize,omitempty"`
+	// ReservedBlocksPercentage of blocks reserved for the super-user, supported by ext3 and ext4
+	ReservedBlocksPercentage *uint64 `json:"reservedBlocksPercentage,omitempty"`
+	// DisableLazyInit initializes inode tables and journal during formatting, supported by ext3 and ext4
+	DisableLazyInit bool `json:"disableLazyInit,omitempty"`
+}
+
+// InitialContent of RBD file system, given as uploaded tar archive or name of archive in seed catalog of the broker.
+// Root directory of the file system gets owner and mode, if given.
+type InitialContent struct {
+	// Archive is tar archive, optionally compressed, encoded in base64 in JSON
+	Archive []byte  `json:"archive,omitempty"`
+	Seed    string  `json:"seed,omitempty"`
+	UID     *uint32 `json:"uid,omitempty"`
+	GID     *uint32 `json:"gid,omitempty"`
+	// Mode in octal notation, e.g. "0750"
+	Mode string `json:"mode,omitempty"`
 }
 
 const (
-	XFS  = "xfs"
-	EXT4 = "ext4"
+	XFS   = "xfs"
+	EXT4  = "ext4"
+	EXT3  = "ext3"
+	BTRFS = "btrfs"
 )
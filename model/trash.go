@@ -0,0 +1,30 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+import "time"
+
+// TrashEntry describes image moved to rbd trash. It can be restored until it is purged, which is allowed
+// when its deferment period ends.
+type TrashEntry struct {
+	ID            string    `json:"id"`
+	ImageName     string    `json:"imageName"`
+	DeletedAt     time.Time `json:"deletedAt"`
+	DeferredUntil time.Time `json:"deferredUntil"`
+	// Expired is set when deferment period has ended and the image can be purged
+	Expired bool `json:"expired"`
+}
@@ -0,0 +1,40 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package model
+
+// SnapshotUsage reports space of image snapshot
+type SnapshotUsage struct {
+	Name             string `json:"name"`
+	ProvisionedBytes uint64 `json:"provisionedBytes"`
+	UsedBytes        uint64 `json:"usedBytes"`
+}
+
+// ImageUsage reports provisioned and actually used space of image, excluding its snapshots
+type ImageUsage struct {
+	ImageName        string          `json:"imageName"`
+	ProvisionedBytes uint64          `json:"provisionedBytes"`
+	UsedBytes        uint64          `json:"usedBytes"`
+	UsedPercentage   float64         `json:"usedPercentage"`
+	Snapshots        []SnapshotUsage `json:"snapshots"`
+}
+
+// UsageReport reports usage of images in the pool. Totals include all images and snapshots, also filtered out ones.
+type UsageReport struct {
+	Images                []ImageUsage `json:"images"`
+	TotalProvisionedBytes uint64       `json:"totalProvisionedBytes"`
+	TotalUsedBytes        uint64       `json:"totalUsedBytes"`
+}
@@ -0,0 +1,72 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/api"
+)
+
+// waitForShutdown blocks until SIGTERM or SIGINT is received, then stops background reconciliation and
+// accepting new requests, waits up to timeout for in-flight operations and unmaps images left mapped by the broker.
+// CSI server is optional.
+func waitForShutdown(servers []*http.Server, csiServer *grpc.Server, brokerContext *api.Context, timeout time.Duration, stopReconciliation chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	close(stopReconciliation)
+	logger.Infof("Received %v, shutting down. In-flight operations: %d, waiting up to %v", sig, brokerContext.Operations.InFlight(), timeout)
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Errorf("Server shutdown did not complete: %v", err)
+		}
+	}
+	if csiServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			csiServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			logger.Error("CSI server shutdown did not complete")
+			csiServer.Stop()
+		}
+	}
+	abandoned := brokerContext.Operations.InFlight()
+
+	unmapped, failed := brokerContext.UnmapAll()
+	for image, err := range failed {
+		logger.Errorf("Cannot unmap RBD image %q: %v", image, err)
+	}
+
+	logger.Infof("Shutdown completed in %v: abandoned operations: %d, unmapped images: %v, images left mapped: %d",
+		time.Since(startTime), abandoned, unmapped, len(failed))
+}
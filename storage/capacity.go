@@ -0,0 +1,145 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const mebibyte = 1024 * 1024
+
+// CapacityError is returned when image cannot be created or grown without exceeding pool capacity
+// multiplied by overcommit ratio
+type CapacityError struct {
+	Pool             string
+	CapacityBytes    uint64
+	OvercommitRatio  float64
+	ProvisionedBytes uint64
+	RequestedBytes   uint64
+}
+
+// LimitBytes returns space which can be provisioned in the pool
+func (e *CapacityError) LimitBytes() uint64 {
+	return uint64(float64(e.CapacityBytes) * e.OvercommitRatio)
+}
+
+// AvailableBytes returns space which can still be provisioned in the pool
+func (e *CapacityError) AvailableBytes() uint64 {
+	if limit := e.LimitBytes(); limit > e.ProvisionedBytes {
+		return limit - e.ProvisionedBytes
+	}
+	return 0
+}
+
+func (e *CapacityError) Error() string {
+	return fmt.Sprintf("pool %q has %d bytes available for provisioning, %d bytes requested (capacity %d bytes, overcommit ratio %g, %d bytes provisioned)",
+		e.Pool, e.AvailableBytes(), e.RequestedBytes, e.CapacityBytes, e.OvercommitRatio, e.ProvisionedBytes)
+}
+
+type cephDFOutput struct {
+	Pools []struct {
+		Name  string `json:"name"`
+		Stats struct {
+			// Stored is reported by Ceph Nautilus and later, where BytesUsed includes replicas
+			Stored    *uint64 `json:"stored"`
+			BytesUsed uint64  `json:"bytes_used"`
+			MaxAvail  uint64  `json:"max_avail"`
+		} `json:"stats"`
+	} `json:"pools"`
+}
+
+// poolCapacity returns space stored in the pool and still available in it, in bytes
+func (s *RBDService) poolCapacity(ctx context.Context) (uint64, error) {
+	output, err := s.execute(ctx, cephPath, "df", "--format", "json")
+	if err != nil {
+		return 0, fmt.Errorf("cannot get pool stats: %v", err)
+	}
+	df := cephDFOutput{}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &df); err != nil {
+		return 0, fmt.Errorf("cannot parse ceph df output %q: %v", output, err)
+	}
+	for _, pool := range df.Pools {
+		if pool.Name != s.Pool {
+			continue
+		}
+		stored := pool.Stats.BytesUsed
+		if pool.Stats.Stored != nil {
+			stored = *pool.Stats.Stored
+		}
+		return stored + pool.Stats.MaxAvail, nil
+	}
+	return 0, fmt.Errorf("pool %q not found in ceph df output", s.Pool)
+}
+
+// trashedBytes returns provisioned size of images in trash, which keep their space until they are purged
+func (s *RBDService) trashedBytes(ctx context.Context) (uint64, error) {
+	entries, err := s.ListTrash(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("cannot list trash: %v", err)
+	}
+	var size uint64
+	for _, entry := range entries {
+		output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("info", "--image-id", entry.ID, "--format", "json")...)
+		if err != nil {
+			if rbdNotFound(output) {
+				// purged after listing
+				continue
+			}
+			return 0, fmt.Errorf("cannot get info of trashed image %q: %v", entry.ImageName, err)
+		}
+		info := ImageInfo{}
+		if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &info); err != nil {
+			return 0, fmt.Errorf("cannot parse rbd info output %q: %v", output, err)
+		}
+		size += info.Size
+	}
+	return size, nil
+}
+
+// checkCapacity returns *CapacityError if provisioning requested bytes more would exceed pool capacity
+// multiplied by overcommit ratio. Images in trash are counted as provisioned. The check is disabled
+// if ratio is not positive.
+func (s *RBDService) checkCapacity(ctx context.Context, requested uint64) error {
+	if s.OvercommitRatio <= 0 || requested == 0 {
+		return nil
+	}
+	capacity, err := s.poolCapacity(ctx)
+	if err != nil {
+		return err
+	}
+	images, err := s.ListImagesInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot list images: %v", err)
+	}
+	provisioned, err := s.trashedBytes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		provisioned += image.Size
+	}
+
+	capacityErr := &CapacityError{Pool: s.Pool, CapacityBytes: capacity, OvercommitRatio: s.OvercommitRatio, ProvisionedBytes: provisioned, RequestedBytes: requested}
+	if provisioned+requested > capacityErr.LimitBytes() {
+		loggerFrom(ctx).Errorf("capacity check FAILED: %v", capacityErr)
+		return capacityErr
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type cephAuthEntry struct {
+	Entity string `json:"entity"`
+	Key    string `json:"key"`
+}
+
+// CreateCephUser creates Ceph user allowed to use only the image, or returns key of existing one.
+// OSD caps are limited to objects of the image in Pool, so the user cannot open other images.
+func (s *RBDService) CreateCephUser(ctx context.Context, user, imageName string) (string, error) {
+	info, err := s.ImageInfo(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	if info.ID == "" {
+		return "", fmt.Errorf("RBD image %q has no id, only format 2 images are supported", imageName)
+	}
+	output, err := s.execute(ctx, cephPath, "auth", "get-or-create", user,
+		"mon", "profile rbd", "osd", imageOSDCaps(s.Pool, imageName, info.ID), "--format", "json")
+	if err != nil {
+		return "", err
+	}
+	entries := []cephAuthEntry{}
+	if err = json.Unmarshal([]byte(output), &entries); err != nil || len(entries) == 0 {
+		return "", fmt.Errorf("cannot parse ceph auth output %q: %v", output, err)
+	}
+	return entries[0].Key, nil
+}
+
+// imageOSDCaps allows reading and writing data, header and object map of format 2 image with id,
+// and reading the object which maps its name to id
+func imageOSDCaps(pool, name, id string) string {
+	if pool != "" {
+		pool = " pool=" + pool
+	}
+	grants := []string{}
+	for _, prefix := range []string{"rbd_data.", "rbd_header.", "rbd_object_map."} {
+		grants = append(grants, "allow rwx"+pool+" object_prefix "+prefix+id)
+	}
+	grants = append(grants, "allow rx"+pool+" object_prefix rbd_id."+name)
+	return strings.Join(grants, ", ")
+}
+
+// DeleteCephUser removes Ceph user, ErrNotFound is returned if it does not exist
+func (s *RBDService) DeleteCephUser(ctx context.Context, user string) error {
+	if output, err := s.executeCombinedOutput(ctx, cephPath, "auth", "del", user); err != nil {
+		if rbdNotFound(output) || strings.Contains(output, "does not exist") {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
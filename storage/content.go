@@ -0,0 +1,157 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+const (
+	mountPath  = "/bin/mount"
+	umountPath = "/bin/umount"
+	tarPath    = "/bin/tar"
+	chownPath  = "/bin/chown"
+	chmodPath  = "/bin/chmod"
+)
+
+// ErrUnknownSeed is returned when initial content refers to archive missing in seed catalog
+var ErrUnknownSeed = errors.New("unknown seed")
+
+var (
+	seedNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+	seedExtensions  = []string{".tar", ".tar.gz", ".tgz"}
+)
+
+// ValidateInitialContent checks that at most one archive source is given and mode is valid
+func ValidateInitialContent(content *model.InitialContent) error {
+	if content == nil {
+		return nil
+	}
+	if len(content.Archive) > 0 && content.Seed != "" {
+		return errors.New("initial content can be given either as archive or as seed, not both")
+	}
+	if content.Seed != "" && !seedNamePattern.MatchString(content.Seed) {
+		return fmt.Errorf("invalid seed name %q", content.Seed)
+	}
+	if content.Mode != "" {
+		if _, err := parseMode(content.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseMode(mode string) (uint64, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid mode %q: octal permissions expected", mode)
+	}
+	return value, nil
+}
+
+// seedArchive returns path of seed archive in catalog, ErrUnknownSeed is returned if it does not exist
+func (s *RBDService) seedArchive(seed string) (string, error) {
+	if s.SeedCatalog == "" {
+		return "", ErrUnknownSeed
+	}
+	for _, extension := range seedExtensions {
+		path := filepath.Join(s.SeedCatalog, seed+extension)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", ErrUnknownSeed
+}
+
+// populate mounts formatted device in temporary directory, unpacks archive and sets owner and mode of the root
+// directory, then unmounts it. Archive is path of seed archive, or empty if uploaded archive should be used.
+func (s *RBDService) populate(ctx context.Context, device string, content *model.InitialContent, archive string) (err error) {
+	if archive == "" && len(content.Archive) > 0 {
+		if archive, err = writeTempArchive(content.Archive); err != nil {
+			return err
+		}
+		defer os.Remove(archive)
+	}
+
+	mountPoint, err := ioutil.TempDir("", "tap-ceph-broker-")
+	if err != nil {
+		return fmt.Errorf("cannot create mount point: %v", err)
+	}
+	defer os.Remove(mountPoint)
+
+	if _, err = s.executeCombinedOutput(ctx, mountPath, device, mountPoint); err != nil {
+		return fmt.Errorf("cannot mount device %q: %v", device, err)
+	}
+	defer func() {
+		if _, umountErr := s.executeCombinedOutput(ctx, umountPath, mountPoint); umountErr != nil && err == nil {
+			err = fmt.Errorf("cannot unmount device %q: %v", device, umountErr)
+		}
+	}()
+
+	if archive != "" {
+		if output, err := s.executeCombinedOutput(ctx, tarPath, "-x", "--no-same-owner", "-f", archive, "-C", mountPoint); err != nil {
+			return fmt.Errorf("cannot unpack archive: %v: %s", err, output)
+		}
+	}
+	if owner := ownerArgument(content); owner != "" {
+		if _, err = s.executeCombinedOutput(ctx, chownPath, owner, mountPoint); err != nil {
+			return fmt.Errorf("cannot change owner of root directory: %v", err)
+		}
+	}
+	if content.Mode != "" {
+		if _, err = s.executeCombinedOutput(ctx, chmodPath, content.Mode, mountPoint); err != nil {
+			return fmt.Errorf("cannot change mode of root directory: %v", err)
+		}
+	}
+	return nil
+}
+
+func ownerArgument(content *model.InitialContent) string {
+	owner := ""
+	if content.UID != nil {
+		owner = strconv.FormatUint(uint64(*content.UID), 10)
+	}
+	if content.GID != nil {
+		owner += ":" + strconv.FormatUint(uint64(*content.GID), 10)
+	}
+	return owner
+}
+
+func writeTempArchive(archive []byte) (string, error) {
+	file, err := ioutil.TempFile("", "tap-ceph-broker-archive-")
+	if err != nil {
+		return "", fmt.Errorf("cannot store archive: %v", err)
+	}
+	_, err = file.Write(archive)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("cannot store archive: %v", err)
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,99 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var poolNamePattern = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_.]*$`)
+
+// ValidatePoolName checks name of Ceph pool
+func ValidatePoolName(pool string) error {
+	if !poolNamePattern.MatchString(pool) {
+		return fmt.Errorf("pool name %q is invalid: it has to consist of alphanumeric characters, '-', '_' or '.'", pool)
+	}
+	return nil
+}
+
+// ValidateDestinationName checks name of image created by rename or copy, which cannot refer to another pool
+func ValidateDestinationName(name string) error {
+	if err := ValidateImageName(name); err != nil {
+		return err
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("rbd image name %q cannot contain '/'", name)
+	}
+	return nil
+}
+
+// ImageSpec returns specification of image in pool, as accepted by rbd commands. Empty pool stands for
+// the pool of the service.
+func ImageSpec(pool, name string) string {
+	if pool == "" {
+		return name
+	}
+	return pool + "/" + name
+}
+
+func copyError(output string, err error) error {
+	if rbdNotFound(output) {
+		return ErrNotFound
+	}
+	if strings.Contains(strings.ToUpper(output), "FILE EXISTS") {
+		return ErrImageExists
+	}
+	return err
+}
+
+// RenameImage renames image within the pool. ErrNotFound is returned if it does not exist
+// and ErrImageExists if there is image with the new name.
+func (s *RBDService) RenameImage(ctx context.Context, name, newName string) error {
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("rename", name, newName)...); err != nil {
+		return copyError(output, err)
+	}
+	return nil
+}
+
+// CopyImage copies image, including its image-meta, to destName in pool, or in the pool of the service if pool
+// is empty. Deep copy includes snapshots. Copy within the pool of the service is subject to capacity check.
+// ErrNotFound is returned if the image does not exist and ErrImageExists if destination exists.
+func (s *RBDService) CopyImage(ctx context.Context, name, pool, destName string, deep bool) error {
+	if pool == "" || pool == s.Pool {
+		info, err := s.ImageInfo(ctx, name)
+		if err != nil {
+			return err
+		}
+		if err = s.checkCapacity(ctx, info.Size); err != nil {
+			return err
+		}
+	}
+	args := []string{"cp", name, destName}
+	if deep {
+		args = append([]string{"deep"}, args...)
+	}
+	if pool != "" {
+		args = append(args, "--dest-pool", pool)
+	}
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool(args...)...); err != nil {
+		return copyError(output, err)
+	}
+	return nil
+}
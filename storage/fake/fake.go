@@ -0,0 +1,1211 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package fake emulates rbd, ceph, mkfs, mount and fsck commands in memory. OS implements command execution interface
+// used by storage service, so that the broker can be run and tested without Ceph cluster.
+package fake
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mebibyte           = 1024 * 1024
+	rbdTimestampLayout = "Mon Jan _2 15:04:05 2006"
+	rbdExpiresAtLayout = "2006-01-02 15:04:05"
+
+	// Pool is the only pool reported by ceph df and accepted with --pool; copies to other pools are kept aside
+	Pool = "rbd"
+	// DefaultPoolCapacity is capacity of the pool in MB, 1 TiB
+	DefaultPoolCapacity = 1024 * 1024
+)
+
+// Lock is advisory lock of an image
+type Lock struct {
+	ID      string
+	Locker  string
+	Address string
+}
+
+// Watcher is client which has an image open, reported by rbd status
+type Watcher struct {
+	Address string
+	Client  uint64
+	Cookie  uint64
+}
+
+// Snapshot of an image
+type Snapshot struct {
+	ID        uint64
+	Name      string
+	Size      uint64 // MB
+	Used      uint64 // MB
+	Timestamp time.Time
+}
+
+// Image is state of emulated RBD image
+type Image struct {
+	Name       string
+	ID         string // names objects of the image, e.g. rbd_data.<id>.*
+	Size       uint64 // MB
+	Used       uint64 // MB, reported by rbd du
+	FileSystem string
+	Device     string
+	Locks      []Lock
+	Watchers   []Watcher
+	Snapshots  []Snapshot
+	Meta       map[string]string
+
+	// MountPoint is set while file system of mapped image is mounted
+	MountPoint string
+	// Files are paths of entries unpacked to file system
+	Files     []string
+	RootOwner string
+	RootMode  string
+	// Corrupted file system is reported by check and fixed by repair
+	Corrupted bool
+
+	// mappingCookie identifies watcher of the mapped device
+	mappingCookie uint64
+}
+
+// trashedImage is image moved to trash with rbd trash mv
+type trashedImage struct {
+	id        string
+	image     *Image
+	deletedAt time.Time
+	expiresAt time.Time
+}
+
+type failure struct {
+	command []string
+	output  string
+	err     error
+}
+
+type hold struct {
+	command []string
+	release chan struct{}
+}
+
+// OS emulates rbd, ceph and mkfs commands. It is safe for concurrent use.
+type OS struct {
+	mutex      sync.Mutex
+	images     map[string]*Image
+	trash      map[string]*trashedImage
+	users      map[string]string
+	failures   []failure
+	holds      []hold
+	commands   []string
+	nextDevice int
+	nextID     uint64
+	capacity   uint64
+}
+
+// New returns OS without any images
+func New() *OS {
+	return &OS{images: map[string]*Image{}, trash: map[string]*trashedImage{}, users: map[string]string{}, capacity: DefaultPoolCapacity}
+}
+
+// commandError is returned for failed commands, like *exec.ExitError
+type commandError struct {
+	status int
+}
+
+func (e commandError) Error() string {
+	return fmt.Sprintf("exit status %d", e.status)
+}
+
+// ExitCode returns exit status, like (*exec.ExitError).ExitCode
+func (e commandError) ExitCode() int {
+	return e.status
+}
+
+func failed(status int, format string, a ...interface{}) (string, error) {
+	return fmt.Sprintf(format, a...) + "\n", commandError{status: status}
+}
+
+// ExecuteCommand returns standard output of emulated command
+func (o *OS) ExecuteCommand(name string, arg ...string) (string, error) {
+	output, err := o.execute(name, arg...)
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// ExecuteCommandCombinedOutput returns standard output, or error message if command failed
+func (o *OS) ExecuteCommandCombinedOutput(name string, arg ...string) (string, error) {
+	return o.execute(name, arg...)
+}
+
+// Fail makes the next command starting with given words fail with output and err. Command name is given without path.
+func (o *OS) Fail(output string, err error, command ...string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.failures = append(o.failures, failure{command: command, output: output, err: err})
+}
+
+// Hold makes the next command starting with given words wait until returned function is called, so that other
+// commands can be run while it is in progress. Command name is given without path.
+func (o *OS) Hold(command ...string) func() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	h := hold{command: command, release: make(chan struct{})}
+	o.holds = append(o.holds, h)
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(h.release) })
+	}
+}
+
+// Commands returns executed commands, names are given without path
+func (o *OS) Commands() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return append([]string{}, o.commands...)
+}
+
+// Image returns copy of the image state
+func (o *OS) Image(name string) (Image, bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	image, ok := o.images[name]
+	if !ok {
+		return Image{}, false
+	}
+	copied := *image
+	copied.Locks = append([]Lock{}, image.Locks...)
+	copied.Watchers = append([]Watcher{}, image.Watchers...)
+	copied.Snapshots = append([]Snapshot{}, image.Snapshots...)
+	copied.Files = append([]string{}, image.Files...)
+	copied.Meta = map[string]string{}
+	for key, value := range image.Meta {
+		copied.Meta[key] = value
+	}
+	return copied, true
+}
+
+// AddImage creates image, formatted with fs unless it is empty
+func (o *OS) AddImage(name string, size uint64, fs string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.images[name] = &Image{Name: name, ID: o.newImageID(), Size: size, FileSystem: fs, Meta: map[string]string{}}
+}
+
+// SetUsed sets space used by the image in MB
+func (o *OS) SetUsed(name string, used uint64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if image, ok := o.images[name]; ok {
+		image.Used = used
+	}
+}
+
+// SetPoolCapacity sets capacity of the pool reported by ceph df in MB
+func (o *OS) SetPoolCapacity(capacity uint64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.capacity = capacity
+}
+
+// Corrupt marks file system of the image as corrupted
+func (o *OS) Corrupt(name string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if image, ok := o.images[name]; ok {
+		image.Corrupted = true
+	}
+}
+
+// AddLock locks existing image, as done by rbd clients
+func (o *OS) AddLock(name string, lock Lock) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if image, ok := o.images[name]; ok {
+		image.Locks = append(image.Locks, lock)
+	}
+}
+
+// AddWatcher opens existing image, as done by librbd clients
+func (o *OS) AddWatcher(name string, watcher Watcher) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if image, ok := o.images[name]; ok {
+		image.Watchers = append(image.Watchers, watcher)
+	}
+}
+
+// MapImage maps existing image, as done by rbd clients
+func (o *OS) MapImage(name string) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.mapImage(o.images[name])
+}
+
+func (o *OS) mapImage(image *Image) string {
+	if image == nil {
+		return ""
+	}
+	image.Device = fmt.Sprintf("/dev/rbd%d", o.nextDevice)
+	o.nextDevice++
+	o.nextID++
+	image.mappingCookie = 18446462598732840000 + o.nextID
+	image.Watchers = append(image.Watchers, Watcher{Address: fmt.Sprintf("127.0.0.1:0/%d", o.nextID), Client: 4000 + o.nextID, Cookie: image.mappingCookie})
+	return image.Device
+}
+
+func startsWith(command, words []string) bool {
+	if len(words) > len(command) {
+		return false
+	}
+	for i, word := range words {
+		if command[i] != word {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *OS) injectedFailure(command []string) (failure, bool) {
+	for i, f := range o.failures {
+		if startsWith(command, f.command) {
+			o.failures = append(o.failures[:i], o.failures[i+1:]...)
+			return f, true
+		}
+	}
+	return failure{}, false
+}
+
+// waitIfHeld blocks until held command is released, without blocking other commands
+func (o *OS) waitIfHeld(command []string) {
+	o.mutex.Lock()
+	for i, h := range o.holds {
+		if startsWith(command, h.command) {
+			o.holds = append(o.holds[:i], o.holds[i+1:]...)
+			o.mutex.Unlock()
+			<-h.release
+			return
+		}
+	}
+	o.mutex.Unlock()
+}
+
+func (o *OS) execute(name string, arg ...string) (string, error) {
+	command := append([]string{filepath.Base(name)}, arg...)
+	o.waitIfHeld(command)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.commands = append(o.commands, strings.Join(command, " "))
+	if f, ok := o.injectedFailure(command); ok {
+		return f.output, f.err
+	}
+
+	switch {
+	case command[0] == "rbd":
+		return o.rbd(arg)
+	case command[0] == "ceph":
+		return o.ceph(arg)
+	case strings.HasPrefix(command[0], "mkfs."):
+		return o.mkfs(strings.TrimPrefix(command[0], "mkfs."), arg)
+	case command[0] == "mount":
+		return o.mount(arg)
+	case command[0] == "umount":
+		return o.umount(arg)
+	case command[0] == "tar":
+		return o.tar(arg)
+	case command[0] == "chown":
+		return o.changeRoot(command[0], arg, func(image *Image, value string) { image.RootOwner = value })
+	case command[0] == "blkid":
+		return o.blkid(arg)
+	case command[0] == "e2fsck", command[0] == "xfs_repair", command[0] == "btrfs":
+		return o.fsck(command[0], arg)
+	case command[0] == "chmod":
+		return o.changeRoot(command[0], arg, func(image *Image, value string) { image.RootMode = value })
+	}
+	return failed(127, "%s: command not found", name)
+}
+
+// positional returns arguments which are not options
+func positional(arg []string) []string {
+	words := []string{}
+	for i := 0; i < len(arg); i++ {
+		switch {
+		case arg[i] == "--format" || arg[i] == "--expires-at" || arg[i] == "--dest-pool" || arg[i] == "--pool" || arg[i] == "-p" || arg[i] == "--image-id":
+			i++
+		case strings.HasPrefix(arg[i], "-"):
+		default:
+			words = append(words, arg[i])
+		}
+	}
+	return words
+}
+
+func option(arg []string, name string) (string, bool) {
+	for i, a := range arg {
+		if strings.HasPrefix(a, name+"=") {
+			return strings.TrimPrefix(a, name+"="), true
+		}
+		if a == name && i+1 < len(arg) {
+			return arg[i+1], true
+		}
+	}
+	return "", false
+}
+
+func hasOption(arg []string, name string) bool {
+	for _, a := range arg {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonOutput(v interface{}) (string, error) {
+	output, err := json.Marshal(v)
+	if err != nil {
+		return failed(1, "cannot encode output: %v", err)
+	}
+	return string(output) + "\n", nil
+}
+
+func (o *OS) newImageID() string {
+	o.nextID++
+	return fmt.Sprintf("%012x", o.nextID)
+}
+
+func (o *OS) imageOrFail(name string) (*Image, string, error) {
+	image, ok := o.images[name]
+	if !ok {
+		output, err := failed(2, "rbd: error opening image %s: (2) No such file or directory", name)
+		return nil, output, err
+	}
+	return image, "", nil
+}
+
+// inPool tells if image with key is in Pool; images in other pools are kept under "pool/name" keys
+func inPool(key string) bool {
+	return !strings.Contains(key, "/")
+}
+
+// sortedImages returns images in Pool
+func (o *OS) sortedImages() []*Image {
+	names := []string{}
+	for name := range o.images {
+		if inPool(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	images := []*Image{}
+	for _, name := range names {
+		images = append(images, o.images[name])
+	}
+	return images
+}
+
+func (o *OS) rbd(arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) == 0 {
+		return failed(22, "rbd: error: command is missing")
+	}
+	pool, ok := option(arg, "--pool")
+	if !ok {
+		pool, ok = option(arg, "-p")
+	}
+	if ok && pool != Pool {
+		return failed(2, "rbd: error opening pool '%s': (2) No such file or directory", pool)
+	}
+
+	switch words[0] {
+	case "create":
+		return o.rbdCreate(words, arg)
+	case "list", "ls":
+		return o.rbdList(arg)
+	case "info":
+		return o.rbdInfo(words, arg)
+	case "map":
+		return o.rbdMap(words)
+	case "unmap":
+		return o.rbdUnmap(words)
+	case "showmapped":
+		return o.rbdShowmapped()
+	case "remove", "rm":
+		return o.rbdRemove(words)
+	case "resize":
+		return o.rbdResize(words, arg)
+	case "lock":
+		return o.rbdLock(words)
+	case "snap":
+		return o.rbdSnap(words)
+	case "du", "disk-usage":
+		return o.rbdDiskUsage(words)
+	case "image-meta":
+		return o.rbdImageMeta(words)
+	case "trash":
+		return o.rbdTrash(words, arg)
+	case "status":
+		return o.rbdStatus(words)
+	case "rename", "mv":
+		return o.rbdRename(words)
+	case "copy", "cp":
+		return o.rbdCopy(words[1:], arg, false)
+	case "deep":
+		if len(words) < 2 || (words[1] != "copy" && words[1] != "cp") {
+			return failed(22, "rbd: error parsing command 'deep'")
+		}
+		return o.rbdCopy(words[2:], arg, true)
+	}
+	return failed(22, "rbd: error parsing command '%s'", words[0])
+}
+
+func parseSize(arg []string) (uint64, bool) {
+	value, ok := option(arg, "--size")
+	if !ok {
+		value, ok = option(arg, "-s")
+	}
+	if !ok {
+		return 0, false
+	}
+	size, err := strconv.ParseUint(strings.TrimSuffix(value, "M"), 10, 64)
+	return size, err == nil
+}
+
+func (o *OS) rbdCreate(words, arg []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: image name was not specified")
+	}
+	size, ok := parseSize(arg)
+	if !ok {
+		return failed(22, "rbd: must specify --size <M/G/T>")
+	}
+	if _, exists := o.images[words[1]]; exists {
+		return failed(17, "rbd: create error: (17) File exists")
+	}
+	o.images[words[1]] = &Image{Name: words[1], ID: o.newImageID(), Size: size, Meta: map[string]string{}}
+	return "", nil
+}
+
+func (o *OS) rbdList(arg []string) (string, error) {
+	if hasOption(arg, "--long") || hasOption(arg, "-l") {
+		entries := []map[string]interface{}{}
+		for _, image := range o.sortedImages() {
+			entries = append(entries, map[string]interface{}{"image": image.Name, "size": image.Size * mebibyte, "format": 2})
+			for _, snapshot := range image.Snapshots {
+				entries = append(entries, map[string]interface{}{"image": image.Name, "snapshot": snapshot.Name, "size": snapshot.Size * mebibyte, "format": 2})
+			}
+		}
+		return jsonOutput(entries)
+	}
+	output := ""
+	for _, image := range o.sortedImages() {
+		output += image.Name + "\n"
+	}
+	return output, nil
+}
+
+func (o *OS) rbdDiskUsage(words []string) (string, error) {
+	images := o.sortedImages()
+	if len(words) > 1 {
+		image, output, err := o.imageOrFail(words[1])
+		if err != nil {
+			return output, err
+		}
+		images = []*Image{image}
+	}
+	entries := []map[string]interface{}{}
+	var totalProvisioned, totalUsed uint64
+	for _, image := range images {
+		for _, snapshot := range image.Snapshots {
+			entries = append(entries, map[string]interface{}{"name": image.Name, "snapshot": snapshot.Name, "provisioned_size": snapshot.Size * mebibyte, "used_size": snapshot.Used * mebibyte})
+			totalUsed += snapshot.Used * mebibyte
+		}
+		entries = append(entries, map[string]interface{}{"name": image.Name, "provisioned_size": image.Size * mebibyte, "used_size": image.Used * mebibyte})
+		totalProvisioned += image.Size * mebibyte
+		totalUsed += image.Used * mebibyte
+	}
+	return jsonOutput(map[string]interface{}{"images": entries, "total_provisioned_size": totalProvisioned, "total_used_size": totalUsed})
+}
+
+func (o *OS) rbdInfo(words, arg []string) (string, error) {
+	var image *Image
+	if id, ok := option(arg, "--image-id"); ok {
+		// images in trash can be opened only by id
+		trashed, ok := o.trash[id]
+		if !ok {
+			return failed(2, "rbd: error opening image %s: (2) No such file or directory", id)
+		}
+		image = trashed.image
+	} else {
+		if len(words) < 2 {
+			return failed(22, "rbd: image name was not specified")
+		}
+		var output string
+		var err error
+		if image, output, err = o.imageOrFail(words[1]); err != nil {
+			return output, err
+		}
+	}
+	return jsonOutput(map[string]interface{}{
+		"name":              image.Name,
+		"id":                image.ID,
+		"size":              image.Size * mebibyte,
+		"objects":           image.Size / 4,
+		"order":             22,
+		"block_name_prefix": "rbd_data." + image.ID,
+		"format":            2,
+	})
+}
+
+func (o *OS) rbdMap(words []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: image name was not specified")
+	}
+	image, output, err := o.imageOrFail(words[1])
+	if err != nil {
+		return output, err
+	}
+	return o.mapImage(image) + "\n", nil
+}
+
+func (o *OS) rbdUnmap(words []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: unmap requires either image name or device path")
+	}
+	for _, image := range o.images {
+		if image.Device != "" && (image.Name == words[1] || image.Device == words[1]) {
+			if image.MountPoint != "" {
+				return failed(16, "rbd: sysfs write failed\nrbd: unmap failed: (16) Device or resource busy")
+			}
+			image.Device = ""
+			for i, watcher := range image.Watchers {
+				if watcher.Cookie == image.mappingCookie {
+					image.Watchers = append(image.Watchers[:i], image.Watchers[i+1:]...)
+					break
+				}
+			}
+			return "", nil
+		}
+	}
+	return failed(22, "rbd: %s: not a mapped image or snapshot", words[1])
+}
+
+func (o *OS) rbdShowmapped() (string, error) {
+	entries := []map[string]string{}
+	for _, image := range o.sortedImages() {
+		if image.Device != "" {
+			id := strings.TrimPrefix(image.Device, "/dev/rbd")
+			entries = append(entries, map[string]string{"id": id, "pool": Pool, "name": image.Name, "snap": "-", "device": image.Device})
+		}
+	}
+	return jsonOutput(entries)
+}
+
+func (o *OS) rbdRemove(words []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: image name was not specified")
+	}
+	image, ok := o.images[words[1]]
+	if !ok {
+		return failed(2, "rbd: delete error: (2) No such file or directory")
+	}
+	if len(image.Watchers) > 0 {
+		return failed(16, "rbd: error: image still has watchers")
+	}
+	if len(image.Snapshots) > 0 {
+		return failed(39, "rbd: image has snapshots - these must be deleted with 'rbd snap purge' before the image can be removed.")
+	}
+	delete(o.images, image.Name)
+	return "", nil
+}
+
+func (o *OS) rbdResize(words, arg []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: image name was not specified")
+	}
+	image, output, err := o.imageOrFail(words[1])
+	if err != nil {
+		return output, err
+	}
+	size, ok := parseSize(arg)
+	if !ok {
+		return failed(22, "rbd: must specify --size <M/G/T>")
+	}
+	if size < image.Size && !hasOption(arg, "--allow-shrink") {
+		return failed(22, "rbd: shrinking an image is only allowed with the --allow-shrink flag")
+	}
+	image.Size = size
+	return "", nil
+}
+
+func (o *OS) rbdLock(words []string) (string, error) {
+	if len(words) < 3 {
+		return failed(22, "rbd: lock command requires image name")
+	}
+	image, output, err := o.imageOrFail(words[2])
+	if err != nil {
+		return output, err
+	}
+
+	switch words[1] {
+	case "list", "ls":
+		if len(image.Locks) == 0 {
+			return "", nil
+		}
+		output := fmt.Sprintf("There is %d exclusive lock on this image.\nLocker      ID                  Address\n", len(image.Locks))
+		for _, lock := range image.Locks {
+			output += fmt.Sprintf("%s %s %s\n", lock.Locker, lock.ID, lock.Address)
+		}
+		return output, nil
+	case "add":
+		if len(words) < 4 {
+			return failed(22, "rbd: lock id was not specified")
+		}
+		for _, lock := range image.Locks {
+			if lock.ID == words[3] {
+				return failed(16, "rbd: lock is already held by someone else")
+			}
+		}
+		o.nextID++
+		image.Locks = append(image.Locks, Lock{ID: words[3], Locker: fmt.Sprintf("client.%d", 4000+o.nextID), Address: fmt.Sprintf("127.0.0.1:0/%d", o.nextID)})
+		return "", nil
+	case "remove", "rm":
+		if len(words) < 5 {
+			return failed(22, "rbd: lock id and locker were not specified")
+		}
+		for i, lock := range image.Locks {
+			if lock.ID == words[3] && lock.Locker == words[4] {
+				image.Locks = append(image.Locks[:i], image.Locks[i+1:]...)
+				return "", nil
+			}
+		}
+		return failed(2, "rbd: releasing lock failed: (2) No such file or directory")
+	}
+	return failed(22, "rbd: error parsing command 'lock %s'", words[1])
+}
+
+func splitSnapshot(spec string) (string, string) {
+	parts := strings.SplitN(spec, "@", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (o *OS) rbdSnap(words []string) (string, error) {
+	if len(words) < 3 {
+		return failed(22, "rbd: snap command requires image name")
+	}
+	name, snapshotName := splitSnapshot(words[2])
+	image, output, err := o.imageOrFail(name)
+	if err != nil {
+		return output, err
+	}
+
+	switch words[1] {
+	case "list", "ls":
+		entries := []map[string]interface{}{}
+		for _, snapshot := range image.Snapshots {
+			entries = append(entries, map[string]interface{}{
+				"id":        snapshot.ID,
+				"name":      snapshot.Name,
+				"size":      snapshot.Size * mebibyte,
+				"timestamp": snapshot.Timestamp.Format(rbdTimestampLayout),
+			})
+		}
+		return jsonOutput(entries)
+	case "create", "add":
+		if snapshotName == "" {
+			return failed(22, "rbd: snapshot name was not specified")
+		}
+		for _, snapshot := range image.Snapshots {
+			if snapshot.Name == snapshotName {
+				return failed(17, "rbd: failed to create snapshot: (17) File exists")
+			}
+		}
+		o.nextID++
+		image.Snapshots = append(image.Snapshots, Snapshot{ID: o.nextID, Name: snapshotName, Size: image.Size, Used: image.Used, Timestamp: time.Now()})
+		return "", nil
+	case "remove", "rm":
+		for i, snapshot := range image.Snapshots {
+			if snapshot.Name == snapshotName {
+				image.Snapshots = append(image.Snapshots[:i], image.Snapshots[i+1:]...)
+				return "", nil
+			}
+		}
+		return failed(2, "rbd: failed to remove snapshot: (2) No such file or directory")
+	}
+	return failed(22, "rbd: error parsing command 'snap %s'", words[1])
+}
+
+func (o *OS) rbdImageMeta(words []string) (string, error) {
+	if len(words) < 3 {
+		return failed(22, "rbd: image-meta command requires image name")
+	}
+	image, output, err := o.imageOrFail(words[2])
+	if err != nil {
+		return output, err
+	}
+
+	switch words[1] {
+	case "list", "ls":
+		return jsonOutput(image.Meta)
+	case "get":
+		if len(words) < 4 {
+			return failed(22, "rbd: metadata key was not specified")
+		}
+		value, ok := image.Meta[words[3]]
+		if !ok {
+			return failed(2, "rbd: failed to get metadata %s of image : (2) No such file or directory", words[3])
+		}
+		return value + "\n", nil
+	case "set":
+		if len(words) < 5 {
+			return failed(22, "rbd: metadata key and value were not specified")
+		}
+		image.Meta[words[3]] = words[4]
+		return "", nil
+	case "remove", "rm":
+		if len(words) < 4 {
+			return failed(22, "rbd: metadata key was not specified")
+		}
+		if _, ok := image.Meta[words[3]]; !ok {
+			return failed(2, "rbd: failed to remove metadata %s of image : (2) No such file or directory", words[3])
+		}
+		delete(image.Meta, words[3])
+		return "", nil
+	}
+	return failed(22, "rbd: error parsing command 'image-meta %s'", words[1])
+}
+
+func (o *OS) rbdRename(words []string) (string, error) {
+	if len(words) < 3 {
+		return failed(22, "rbd: source and destination image names were not specified")
+	}
+	image, ok := o.images[words[1]]
+	if !ok {
+		return failed(2, "rbd: rename error: (2) No such file or directory")
+	}
+	if _, exists := o.images[words[2]]; exists {
+		return failed(17, "rbd: rename error: (17) File exists")
+	}
+	delete(o.images, words[1])
+	image.Name = words[2]
+	o.images[words[2]] = image
+	return "", nil
+}
+
+// rbdCopy copies image with its image-meta, and with snapshots if deep is set.
+// Destination in pool other than Pool given with --dest-pool is kept under "pool/name" key.
+func (o *OS) rbdCopy(words, arg []string, deep bool) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: source and destination image names were not specified")
+	}
+	image, ok := o.images[words[0]]
+	if !ok {
+		return failed(2, "rbd: error opening image %s: (2) No such file or directory", words[0])
+	}
+	key := words[1]
+	if pool, ok := option(arg, "--dest-pool"); ok && pool != Pool {
+		key = pool + "/" + words[1]
+	}
+	if _, exists := o.images[key]; exists {
+		return failed(17, "rbd: copy failed: (17) File exists")
+	}
+	copied := &Image{
+		Name:       words[1],
+		ID:         o.newImageID(),
+		Size:       image.Size,
+		Used:       image.Used,
+		FileSystem: image.FileSystem,
+		Files:      append([]string{}, image.Files...),
+		RootOwner:  image.RootOwner,
+		RootMode:   image.RootMode,
+		Corrupted:  image.Corrupted,
+		Meta:       map[string]string{},
+	}
+	for key, value := range image.Meta {
+		copied.Meta[key] = value
+	}
+	if deep {
+		for _, snapshot := range image.Snapshots {
+			o.nextID++
+			snapshot.ID = o.nextID
+			copied.Snapshots = append(copied.Snapshots, snapshot)
+		}
+	}
+	o.images[key] = copied
+	return "", nil
+}
+
+func (o *OS) rbdStatus(words []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: image name was not specified")
+	}
+	image, output, err := o.imageOrFail(words[1])
+	if err != nil {
+		return output, err
+	}
+	watchers := []map[string]interface{}{}
+	for _, watcher := range image.Watchers {
+		watchers = append(watchers, map[string]interface{}{"address": watcher.Address, "client": watcher.Client, "cookie": watcher.Cookie})
+	}
+	return jsonOutput(map[string]interface{}{"watchers": watchers})
+}
+
+func (o *OS) rbdTrash(words, arg []string) (string, error) {
+	if len(words) < 2 {
+		return failed(22, "rbd: trash command requires subcommand")
+	}
+	now := time.Now()
+
+	switch words[1] {
+	case "mv", "move":
+		if len(words) < 3 {
+			return failed(22, "rbd: image name was not specified")
+		}
+		image, ok := o.images[words[2]]
+		if !ok {
+			return failed(2, "rbd: deferred delete error: (2) No such file or directory")
+		}
+		if len(image.Watchers) > 0 {
+			return failed(16, "rbd: deferred delete error: (16) Device or resource busy")
+		}
+		expiresAt := now
+		if value, ok := option(arg, "--expires-at"); ok {
+			var err error
+			if expiresAt, err = time.ParseInLocation(rbdExpiresAtLayout, value, time.UTC); err != nil {
+				return failed(22, "rbd: invalid expires-at %q", value)
+			}
+		}
+		o.nextID++
+		id := fmt.Sprintf("%012x", o.nextID)
+		o.trash[id] = &trashedImage{id: id, image: image, deletedAt: now, expiresAt: expiresAt}
+		delete(o.images, image.Name)
+		return "", nil
+	case "ls", "list":
+		entries := []map[string]string{}
+		for _, trashed := range o.sortedTrash() {
+			entry := map[string]string{"id": trashed.id, "name": trashed.image.Name}
+			if hasOption(arg, "--long") || hasOption(arg, "-l") {
+				entry["source"] = "USER"
+				entry["deleted_at"] = trashed.deletedAt.Local().Format(rbdTimestampLayout)
+				if now.Before(trashed.expiresAt) {
+					entry["status"] = "protected until " + trashed.expiresAt.Local().Format(rbdTimestampLayout)
+				} else {
+					entry["status"] = "expired at " + trashed.expiresAt.Local().Format(rbdTimestampLayout)
+				}
+			}
+			entries = append(entries, entry)
+		}
+		return jsonOutput(entries)
+	case "restore":
+		if len(words) < 3 {
+			return failed(22, "rbd: image id was not specified")
+		}
+		trashed, ok := o.trash[words[2]]
+		if !ok {
+			return failed(2, "rbd: restore error: (2) No such file or directory")
+		}
+		if _, exists := o.images[trashed.image.Name]; exists {
+			return failed(17, "rbd: restore error: (17) File exists")
+		}
+		o.images[trashed.image.Name] = trashed.image
+		delete(o.trash, trashed.id)
+		return "", nil
+	case "rm", "remove":
+		if len(words) < 3 {
+			return failed(22, "rbd: image id was not specified")
+		}
+		trashed, ok := o.trash[words[2]]
+		if !ok {
+			return failed(2, "rbd: remove error: (2) No such file or directory")
+		}
+		if now.Before(trashed.expiresAt) && !hasOption(arg, "--force") {
+			return failed(1, "rbd: error: deferment time has not expired, please use --force if you really want to remove the image")
+		}
+		delete(o.trash, trashed.id)
+		return "", nil
+	case "purge":
+		for id, trashed := range o.trash {
+			if !now.Before(trashed.expiresAt) {
+				delete(o.trash, id)
+			}
+		}
+		return "", nil
+	}
+	return failed(22, "rbd: error parsing command 'trash %s'", words[1])
+}
+
+func (o *OS) sortedTrash() []*trashedImage {
+	trash := make([]*trashedImage, 0, len(o.trash))
+	for _, trashed := range o.trash {
+		trash = append(trash, trashed)
+	}
+	sort.Slice(trash, func(i, j int) bool { return trash[i].id < trash[j].id })
+	return trash
+}
+
+func (o *OS) ceph(arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) == 1 && words[0] == "df" {
+		return o.cephDF()
+	}
+	if len(words) < 3 || words[0] != "auth" {
+		return failed(22, "ceph: unsupported command %s", strings.Join(arg, " "))
+	}
+	user := words[2]
+
+	switch words[1] {
+	case "get-or-create":
+		key, ok := o.users[user]
+		if !ok {
+			o.nextID++
+			key = fmt.Sprintf("QVFCZmFrZWtleQ%08d==", o.nextID)
+			o.users[user] = key
+		}
+		return jsonOutput([]map[string]interface{}{{"entity": user, "key": key, "caps": map[string]string{}}})
+	case "del", "rm":
+		if _, ok := o.users[user]; !ok {
+			return failed(2, "Error ENOENT: entity %s does not exist", user)
+		}
+		delete(o.users, user)
+		return "", nil
+	}
+	return failed(22, "ceph: unsupported command %s", strings.Join(arg, " "))
+}
+
+// cephDF reports space used by images, also trashed ones, and their snapshots as stored in the pool
+func (o *OS) cephDF() (string, error) {
+	images := []*Image{}
+	for key, image := range o.images {
+		if inPool(key) {
+			images = append(images, image)
+		}
+	}
+	for _, trashed := range o.trash {
+		images = append(images, trashed.image)
+	}
+	var stored uint64
+	for _, image := range images {
+		stored += image.Used
+		for _, snapshot := range image.Snapshots {
+			stored += snapshot.Used
+		}
+	}
+	var available uint64
+	if o.capacity > stored {
+		available = o.capacity - stored
+	}
+	pool := map[string]interface{}{
+		"name": Pool,
+		"id":   1,
+		"stats": map[string]uint64{
+			"stored":     stored * mebibyte,
+			"bytes_used": 3 * stored * mebibyte,
+			"max_avail":  available * mebibyte,
+		},
+	}
+	return jsonOutput(map[string]interface{}{"pools": []interface{}{pool}})
+}
+
+func (o *OS) mkfs(fs string, arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) < 1 {
+		return failed(1, "mkfs.%s: no device specified", fs)
+	}
+	// device is the last argument, after mkfs options
+	device := words[len(words)-1]
+	for _, image := range o.images {
+		if image.Device == device {
+			image.FileSystem = fs
+			image.Files, image.RootOwner, image.RootMode, image.Corrupted = nil, "", "", false
+			return "", nil
+		}
+	}
+	return failed(1, "mkfs.%s: cannot open %s: No such file or directory", fs, device)
+}
+
+func (o *OS) mountedImage(mountPoint string) *Image {
+	for _, image := range o.images {
+		if image.MountPoint != "" && image.MountPoint == mountPoint {
+			return image
+		}
+	}
+	return nil
+}
+
+func (o *OS) mount(arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) != 2 {
+		return failed(1, "mount: device and mount point have to be specified")
+	}
+	for _, image := range o.images {
+		if image.Device != words[0] {
+			continue
+		}
+		if image.FileSystem == "" {
+			return failed(32, "mount: %s: wrong fs type, bad option, bad superblock on %s", words[1], words[0])
+		}
+		if image.MountPoint != "" {
+			return failed(32, "mount: %s: %s already mounted", words[1], words[0])
+		}
+		image.MountPoint = words[1]
+		return "", nil
+	}
+	return failed(32, "mount: %s: special device %s does not exist", words[1], words[0])
+}
+
+func (o *OS) umount(arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) != 1 {
+		return failed(1, "umount: mount point has to be specified")
+	}
+	for _, image := range o.images {
+		if image.MountPoint == words[0] || image.Device == words[0] && image.MountPoint != "" {
+			image.MountPoint = ""
+			return "", nil
+		}
+	}
+	return failed(32, "umount: %s: not mounted", words[0])
+}
+
+// tar unpacks real archive file given with -f, recording its entries in image mounted at directory given with -C
+func (o *OS) tar(arg []string) (string, error) {
+	archive, ok := option(arg, "-f")
+	directory, _ := option(arg, "-C")
+	if !hasOption(arg, "-x") || !ok {
+		return failed(2, "tar: only extraction of archive file is supported")
+	}
+	image := o.mountedImage(directory)
+	if image == nil {
+		return failed(2, "tar: %s: Cannot open: No such file or directory", directory)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return failed(2, "tar: %s: Cannot open: No such file or directory", archive)
+	}
+	defer file.Close()
+	buffered := bufio.NewReader(file)
+	var reader io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		if reader, err = gzip.NewReader(buffered); err != nil {
+			return failed(2, "tar: This does not look like a tar archive")
+		}
+	}
+
+	files := []string{}
+	archiveReader := tar.NewReader(reader)
+	for {
+		header, err := archiveReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return failed(2, "tar: This does not look like a tar archive")
+		}
+		name := strings.TrimPrefix(filepath.Clean("/"+header.Name), "/")
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	image.Files = append(image.Files, files...)
+	sort.Strings(image.Files)
+	return "", nil
+}
+
+// changeRoot emulates chown and chmod of root directory of mounted file system
+func (o *OS) changeRoot(name string, arg []string, change func(image *Image, value string)) (string, error) {
+	words := positional(arg)
+	if len(words) != 2 {
+		return failed(1, "%s: missing operand", name)
+	}
+	image := o.mountedImage(words[1])
+	if image == nil {
+		return failed(1, "%s: cannot access '%s': No such file or directory", name, words[1])
+	}
+	change(image, words[0])
+	return "", nil
+}
+
+func (o *OS) mappedImage(device string) *Image {
+	for _, image := range o.images {
+		if image.Device != "" && image.Device == device {
+			return image
+		}
+	}
+	return nil
+}
+
+func (o *OS) blkid(arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) == 0 {
+		return failed(4, "blkid: no device specified")
+	}
+	image := o.mappedImage(words[len(words)-1])
+	if image == nil || image.FileSystem == "" {
+		return failed(2, "")
+	}
+	return image.FileSystem + "\n", nil
+}
+
+// fsck emulates e2fsck, xfs_repair and btrfs check with their exit statuses
+func (o *OS) fsck(name string, arg []string) (string, error) {
+	words := positional(arg)
+	if len(words) == 0 {
+		return failed(8, "%s: no device specified", name)
+	}
+	device := words[len(words)-1]
+	image := o.mappedImage(device)
+	if image == nil {
+		return failed(8, "%s: No such file or directory while trying to open %s", name, device)
+	}
+	if image.MountPoint != "" {
+		return failed(8, "%s: %s is mounted", name, device)
+	}
+
+	repair := hasOption(arg, "-y") || hasOption(arg, "--repair") || name == "xfs_repair" && !hasOption(arg, "-n")
+	if !image.Corrupted {
+		return fmt.Sprintf("%s: %s: clean\n", name, device), nil
+	}
+	if !repair {
+		if name == "e2fsck" {
+			return failed(4, "%s: %s: inode bitmap differences, UNEXPECTED INCONSISTENCY", name, device)
+		}
+		return failed(1, "%s: %s: found corruption, no modify flag set", name, device)
+	}
+	image.Corrupted = false
+	if name == "e2fsck" {
+		return failed(1, "%s: %s: ***** FILE SYSTEM WAS MODIFIED *****", name, device)
+	}
+	return fmt.Sprintf("%s: %s: corruption repaired\n", name, device), nil
+}
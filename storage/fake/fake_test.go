@@ -0,0 +1,179 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/storage"
+)
+
+func TestFakeWithStorageService(t *testing.T) {
+	Convey("Testing fake OS with storage service", t, func() {
+		backend := New()
+		service := storage.New(backend, nil)
+		ctx := context.Background()
+		rbd := model.RBD{ImageName: "image1", Size: 100, FileSystem: model.EXT4}
+
+		Convey("When image is created", func() {
+			_, err := service.CreateImage(ctx, rbd)
+			So(err, ShouldBeNil)
+
+			info, err := service.ImageInfo(ctx, "image1")
+			So(err, ShouldBeNil)
+			So(info.Size, ShouldEqual, 100*mebibyte)
+			images, err := service.ListImages(ctx)
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, []string{"image1"})
+			So(backend.Commands(), ShouldResemble, []string{
+				"rbd create image1 --size=100 --image-feature=layering",
+				"rbd map image1",
+				"mkfs.ext4 /dev/rbd0",
+				"rbd image-meta set image1 filesystem ext4",
+				"rbd unmap image1",
+				"rbd info image1 --format json",
+				"rbd list",
+			})
+
+			Convey("Then it cannot be created again", func() {
+				_, err := service.CreateImage(ctx, rbd)
+
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("Then it can be resized and removed", func() {
+				So(service.ResizeImage(ctx, "image1", 200), ShouldBeNil)
+				So(service.ResizeImage(ctx, "image1", 50), ShouldNotBeNil)
+				So(service.DeleteImage(ctx, "image1"), ShouldBeNil)
+				So(service.DeleteImage(ctx, "image1"), ShouldEqual, storage.ErrNotFound)
+			})
+
+			Convey("Then its locks can be listed and removed", func() {
+				backend.AddLock("image1", Lock{ID: "lock1", Locker: "client.1", Address: "10.0.0.1:0/1"})
+
+				locks, err := service.ListLocks(ctx)
+				So(err, ShouldBeNil)
+				So(locks, ShouldResemble, []model.Lock{{ImageName: "image1", LockName: "lock1", Locker: "client.1", Address: "10.0.0.1:0/1"}})
+
+				So(service.RemoveLock(ctx, locks[0]), ShouldBeNil)
+				So(service.RemoveLock(ctx, locks[0]), ShouldNotBeNil)
+			})
+
+			Convey("Then snapshots prevent removal", func() {
+				So(service.CreateSnapshot(ctx, "image1", "snap1"), ShouldBeNil)
+				snapshots, err := service.ListSnapshots(ctx, "image1")
+				So(err, ShouldBeNil)
+				So(snapshots, ShouldHaveLength, 1)
+				So(snapshots[0].Name, ShouldEqual, "snap1")
+
+				So(service.DeleteImage(ctx, "image1"), ShouldNotBeNil)
+				So(service.RemoveSnapshot(ctx, "image1", "snap1"), ShouldBeNil)
+				So(service.RemoveSnapshot(ctx, "image1", "snap1"), ShouldEqual, storage.ErrNotFound)
+				So(service.DeleteImage(ctx, "image1"), ShouldBeNil)
+			})
+
+			Convey("Then mapped devices are listed", func() {
+				device := backend.MapImage("image1")
+
+				devices, err := service.ListMappedDevices(ctx)
+				So(err, ShouldBeNil)
+				So(devices, ShouldResemble, []model.MappedDevice{{ImageName: "image1", Pool: "rbd", Device: device}})
+				So(service.DeleteImage(ctx, "image1"), ShouldNotBeNil)
+
+				So(service.UnmapDevice(ctx, device), ShouldBeNil)
+				So(service.UnmapDevice(ctx, device), ShouldNotBeNil)
+			})
+
+			Convey("Then it can be renamed and copied to another pool", func() {
+				So(service.RenameImage(ctx, "image1", "image2"), ShouldBeNil)
+				So(service.CopyImage(ctx, "image2", "archive", "image1", false), ShouldBeNil)
+				So(service.CopyImage(ctx, "image2", "archive", "image1", false), ShouldEqual, storage.ErrImageExists)
+
+				images, err := service.ListImages(ctx)
+				So(err, ShouldBeNil)
+				So(images, ShouldResemble, []string{"image2"})
+				info, err := service.ImageInfo(ctx, storage.ImageSpec("archive", "image1"))
+				So(err, ShouldBeNil)
+				So(info.Size, ShouldEqual, 100*mebibyte)
+			})
+		})
+
+		Convey("When pool is configured", func() {
+			service.Pool = Pool
+
+			_, err := service.CreateImage(ctx, model.RBD{ImageName: "image1", Size: 100})
+			So(err, ShouldBeNil)
+			images, err := service.ListImages(ctx)
+			So(err, ShouldBeNil)
+			So(images, ShouldResemble, []string{"image1"})
+			So(backend.Commands(), ShouldResemble, []string{
+				"rbd create image1 --size=100 --image-feature=layering --pool rbd",
+				"rbd list --pool rbd",
+			})
+
+			Convey("Then images of other pools are not used", func() {
+				service.Pool = "other"
+
+				_, err := service.ImageInfo(ctx, "image1")
+
+				So(err, ShouldEqual, storage.ErrNotFound)
+			})
+		})
+
+		Convey("When image does not exist", func() {
+			_, err := service.ImageInfo(ctx, "image1")
+
+			So(err, ShouldEqual, storage.ErrNotFound)
+		})
+
+		Convey("When failure is injected", func() {
+			backend.Fail("", errors.New("injected"), "mkfs.ext4")
+
+			_, err := service.CreateImage(ctx, rbd)
+			So(err, ShouldNotBeNil)
+			image, ok := backend.Image("image1")
+			So(ok, ShouldBeTrue)
+			So(image.FileSystem, ShouldBeEmpty)
+			So(image.Device, ShouldNotBeEmpty)
+		})
+
+		Convey("When Ceph user is created", func() {
+			backend.AddImage("image1", 100, "")
+			key, err := service.CreateCephUser(ctx, "client.user1", "image1")
+			So(err, ShouldBeNil)
+			So(key, ShouldNotBeEmpty)
+
+			again, err := service.CreateCephUser(ctx, "client.user1", "image1")
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, key)
+
+			So(service.DeleteCephUser(ctx, "client.user1"), ShouldBeNil)
+			So(service.DeleteCephUser(ctx, "client.user1"), ShouldEqual, storage.ErrNotFound)
+		})
+
+		Convey("When Ceph user is created for missing image", func() {
+			_, err := service.CreateCephUser(ctx, "client.user1", "image1")
+
+			So(err, ShouldEqual, storage.ErrNotFound)
+		})
+	})
+}
@@ -0,0 +1,154 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+const (
+	blkidPath     = "/sbin/blkid"
+	e2fsckPath    = "/sbin/e2fsck"
+	xfsRepairPath = "/sbin/xfs_repair"
+	btrfsPath     = "/sbin/btrfs"
+)
+
+// BusyError is returned when image is in use and operation requiring exclusive access was refused
+type BusyError struct {
+	ImageName string
+	Watchers  []model.Watcher
+	Locks     []model.Lock
+}
+
+func (e *BusyError) Error() string {
+	reasons := []string{}
+	if len(e.Watchers) > 0 {
+		watchers := []string{}
+		for _, watcher := range e.Watchers {
+			watchers = append(watchers, fmt.Sprintf("%s (%s)", watcher.Client, watcher.Address))
+		}
+		reasons = append(reasons, "watched by "+strings.Join(watchers, ", "))
+	}
+	if len(e.Locks) > 0 {
+		lockers := []string{}
+		for _, lock := range e.Locks {
+			lockers = append(lockers, fmt.Sprintf("%s (%s, lock %s)", lock.Locker, lock.Address, lock.LockName))
+		}
+		reasons = append(reasons, "locked by "+strings.Join(lockers, ", "))
+	}
+	return fmt.Sprintf("image %q is %s", e.ImageName, strings.Join(reasons, " and "))
+}
+
+// fsckCommand returns check or repair command of file system; clean tells whether exit status means that
+// no errors remain in the file system
+func fsckCommand(fs, device string, repair bool) (path string, args []string, clean func(status int) bool, err error) {
+	switch fs {
+	case model.EXT4, model.EXT3, "ext2":
+		mode := "-n"
+		if repair {
+			mode = "-y"
+		}
+		// 1 means that errors were corrected
+		return e2fsckPath, []string{"-f", mode, device}, func(status int) bool { return status <= 1 }, nil
+	case model.XFS:
+		args = []string{device}
+		if !repair {
+			args = []string{"-n", device}
+		}
+		return xfsRepairPath, args, func(status int) bool { return status == 0 }, nil
+	case model.BTRFS:
+		mode := "--readonly"
+		if repair {
+			mode = "--repair"
+		}
+		return btrfsPath, []string{"check", mode, device}, func(status int) bool { return status == 0 }, nil
+	}
+	return "", nil, nil, fmt.Errorf("checking file system %q is not supported", fs)
+}
+
+// exitStatus returns exit status of failed command, or -1 if it could not be started
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(interface {
+		ExitCode() int
+	}); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// CheckFileSystem maps the image, checks or repairs its file system and unmaps it. If fs is empty, file system
+// recorded in image-meta at formatting is used, and only if there is none it is detected with blkid. ErrNotFound is returned if image does not exist and *BusyError if it is watched
+// or locked, as file system mounted elsewhere can be neither repaired nor reliably checked.
+// Failing check is not an error, its exit status and output are returned in the result.
+func (s *RBDService) CheckFileSystem(ctx context.Context, imageName, fs string, repair bool) (model.FsckResult, error) {
+	result := model.FsckResult{ImageName: imageName, FileSystem: fs, Repair: repair}
+	if _, err := s.ImageInfo(ctx, imageName); err != nil {
+		return result, err
+	}
+	if result.FileSystem == "" {
+		metadata, err := s.ImageMetadata(ctx, imageName)
+		if err != nil {
+			return result, err
+		}
+		result.FileSystem = metadata.FileSystem
+	}
+	if err := s.CheckNotInUse(ctx, imageName); err != nil {
+		return result, err
+	}
+
+	defer s.releaseImage(imageName)
+	device, err := s.rbdMap(ctx, imageName)
+	if err != nil {
+		return result, fmt.Errorf("cannot map RBD image %q: %v", imageName, err)
+	}
+	result, checkErr := s.checkDevice(ctx, device, result)
+	if err = s.UnmapImage(ctx, imageName); err != nil {
+		return result, fmt.Errorf("cannot unmap RBD image %q: %v", imageName, err)
+	}
+	return result, checkErr
+}
+
+func (s *RBDService) checkDevice(ctx context.Context, device string, result model.FsckResult) (model.FsckResult, error) {
+	if result.FileSystem == "" {
+		output, err := s.execute(ctx, blkidPath, "-o", "value", "-s", "TYPE", device)
+		if err != nil {
+			return result, fmt.Errorf("cannot detect file system of device %q: %v", device, err)
+		}
+		result.FileSystem = strings.TrimSpace(output)
+	}
+	path, args, clean, err := fsckCommand(result.FileSystem, device, result.Repair)
+	if err != nil {
+		return result, err
+	}
+
+	result.Command = strings.Join(append([]string{path}, args...), " ")
+	output, err := s.executeCombinedOutput(ctx, path, args...)
+	result.ExitStatus = exitStatus(err)
+	if result.ExitStatus < 0 {
+		return result, fmt.Errorf("cannot run %s: %v", path, err)
+	}
+	result.Clean = clean(result.ExitStatus)
+	result.Report = output
+	return result, nil
+}
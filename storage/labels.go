@@ -0,0 +1,255 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// labelMetaPrefix distinguishes labels from other image-meta keys, e.g. conf_ overrides of librbd settings
+	labelMetaPrefix = "label."
+	// ownerMetaKey stores principal which created the image
+	ownerMetaKey = "owner"
+	// fileSystemMetaKey stores file system the image was formatted with
+	fileSystemMetaKey = "filesystem"
+)
+
+var (
+	labelNamePattern   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+	labelPrefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+)
+
+// ValidateLabelKey checks label key: name of at most 63 alphanumeric characters, '-', '_' or '.',
+// optionally preceded by DNS subdomain prefix and '/', as in Kubernetes labels
+func ValidateLabelKey(key string) error {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		if !labelPrefixPattern.MatchString(key[:i]) {
+			return fmt.Errorf("label key %q has invalid prefix", key)
+		}
+		name = key[i+1:]
+	}
+	if !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("label key %q is invalid: name has to have at most 63 alphanumeric characters, '-', '_' or '.'", key)
+	}
+	return nil
+}
+
+// ValidateLabelValue checks label value: empty or at most 63 alphanumeric characters, '-', '_' or '.'
+func ValidateLabelValue(value string) error {
+	if value != "" && !labelNamePattern.MatchString(value) {
+		return fmt.Errorf("label value %q is invalid: it has to have at most 63 alphanumeric characters, '-', '_' or '.'", value)
+	}
+	return nil
+}
+
+// ValidateLabels checks keys and values of labels
+func ValidateLabels(labels map[string]string) error {
+	for _, key := range sortedKeys(labels) {
+		if err := ValidateLabelKey(key); err != nil {
+			return err
+		}
+		if err := ValidateLabelValue(labels[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *RBDService) imageMeta(ctx context.Context, name string) (map[string]string, error) {
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("image-meta", "list", name, "--format", "json")...)
+	if err != nil {
+		if rbdNotFound(output) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	meta := map[string]string{}
+	if lines := filterNonemptyLines(output); len(lines) > 0 {
+		if err = json.Unmarshal([]byte(strings.Join(lines, "\n")), &meta); err != nil {
+			return nil, fmt.Errorf("cannot parse rbd image-meta output %q: %v", output, err)
+		}
+	}
+	return meta, nil
+}
+
+// ImageMetadata is kept by the broker in image-meta of the image
+type ImageMetadata struct {
+	Labels map[string]string
+	// Owner is principal which created the image, empty if it is not known
+	Owner string
+	// FileSystem the image was formatted with, empty if it is unformatted or was not created by the broker
+	FileSystem string
+}
+
+// ImageMetadata returns labels, owner and file system of the image, ErrNotFound is returned if it does not exist
+func (s *RBDService) ImageMetadata(ctx context.Context, name string) (ImageMetadata, error) {
+	meta, err := s.imageMeta(ctx, name)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	metadata := ImageMetadata{Labels: map[string]string{}, Owner: meta[ownerMetaKey], FileSystem: meta[fileSystemMetaKey]}
+	for key, value := range meta {
+		if strings.HasPrefix(key, labelMetaPrefix) {
+			metadata.Labels[strings.TrimPrefix(key, labelMetaPrefix)] = value
+		}
+	}
+	return metadata, nil
+}
+
+// ImageLabels returns labels of the image stored in its metadata, ErrNotFound is returned if it does not exist
+func (s *RBDService) ImageLabels(ctx context.Context, name string) (map[string]string, error) {
+	metadata, err := s.ImageMetadata(ctx, name)
+	return metadata.Labels, err
+}
+
+// UpdateImageLabels sets labels given with values and removes ones given with nil, other labels are kept.
+// Resulting labels are returned, ErrNotFound is returned if the image does not exist.
+func (s *RBDService) UpdateImageLabels(ctx context.Context, name string, patch map[string]*string) (map[string]string, error) {
+	labels, err := s.ImageLabels(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(patch))
+	for key := range patch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := patch[key]
+		if value == nil {
+			if _, ok := labels[key]; !ok {
+				continue
+			}
+			if _, err = s.execute(ctx, rbdPath, s.inPool("image-meta", "remove", name, labelMetaPrefix+key)...); err != nil {
+				return nil, fmt.Errorf("cannot remove label %q: %v", key, err)
+			}
+			delete(labels, key)
+			continue
+		}
+		if err = s.setLabel(ctx, name, key, *value); err != nil {
+			return nil, err
+		}
+		labels[key] = *value
+	}
+	return labels, nil
+}
+
+func (s *RBDService) setLabel(ctx context.Context, name, key, value string) error {
+	if _, err := s.execute(ctx, rbdPath, s.inPool("image-meta", "set", name, labelMetaPrefix+key, value)...); err != nil {
+		return fmt.Errorf("cannot set label %q: %v", key, err)
+	}
+	return nil
+}
+
+// SetImageOwner records principal owning the image
+func (s *RBDService) SetImageOwner(ctx context.Context, name, owner string) error {
+	if _, err := s.execute(ctx, rbdPath, s.inPool("image-meta", "set", name, ownerMetaKey, owner)...); err != nil {
+		return fmt.Errorf("cannot set owner of RBD image %q: %v", name, err)
+	}
+	return nil
+}
+
+type selectorOperator int
+
+const (
+	selectorEquals selectorOperator = iota
+	selectorNotEquals
+	selectorExists
+	selectorNotExists
+)
+
+type selectorRequirement struct {
+	key      string
+	operator selectorOperator
+	value    string
+}
+
+// LabelSelector selects images by labels. Empty selector matches all images.
+type LabelSelector []selectorRequirement
+
+// ParseLabelSelector parses comma separated requirements, all of which have to be met:
+// "key=value" (or "key==value"), "key!=value", "key" (label is set) and "!key" (label is not set)
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	result := LabelSelector{}
+	for _, term := range strings.Split(selector, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		requirement := selectorRequirement{}
+		switch {
+		case strings.Contains(term, "!="):
+			parts := strings.SplitN(term, "!=", 2)
+			requirement = selectorRequirement{key: parts[0], operator: selectorNotEquals, value: parts[1]}
+		case strings.Contains(term, "=="):
+			parts := strings.SplitN(term, "==", 2)
+			requirement = selectorRequirement{key: parts[0], operator: selectorEquals, value: parts[1]}
+		case strings.Contains(term, "="):
+			parts := strings.SplitN(term, "=", 2)
+			requirement = selectorRequirement{key: parts[0], operator: selectorEquals, value: parts[1]}
+		case strings.HasPrefix(term, "!"):
+			requirement = selectorRequirement{key: term[1:], operator: selectorNotExists}
+		default:
+			requirement = selectorRequirement{key: term, operator: selectorExists}
+		}
+		requirement.key = strings.TrimSpace(requirement.key)
+		requirement.value = strings.TrimSpace(requirement.value)
+		if err := ValidateLabelKey(requirement.key); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", term, err)
+		}
+		if err := ValidateLabelValue(requirement.value); err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", term, err)
+		}
+		result = append(result, requirement)
+	}
+	return result, nil
+}
+
+// Matches returns true if labels meet all requirements of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+		switch requirement.operator {
+		case selectorEquals:
+			ok = ok && value == requirement.value
+		case selectorNotEquals:
+			ok = !ok || value != requirement.value
+		case selectorNotExists:
+			ok = !ok
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	testCases := []struct {
+		labels  map[string]string
+		isError bool
+	}{
+		{nil, false},
+		{map[string]string{"env": "prod", "cost-center": "CC_1234", "example.com/owner": "team.storage"}, false},
+		{map[string]string{"empty": ""}, false},
+		{map[string]string{"": "value"}, true},
+		{map[string]string{"-env": "prod"}, true},
+		{map[string]string{"env": "prod env"}, true},
+		{map[string]string{"Example.com/owner": "x"}, true},
+		{map[string]string{"example.com/": "x"}, true},
+		{map[string]string{strings.Repeat("k", 64): "x"}, true},
+		{map[string]string{"env": strings.Repeat("v", 64)}, true},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateLabels(tc.labels)
+		if (err == nil && tc.isError) || (err != nil && !tc.isError) {
+			t.Errorf("ValidateLabels(%v) returned error: %v; error expected: %v", tc.labels, err, tc.isError)
+		}
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "storage"}
+	testCases := []struct {
+		selector string
+		matches  bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env==prod", true},
+		{"env = prod , team", true},
+		{"env=qa", false},
+		{"env!=qa", true},
+		{"env!=prod", false},
+		{"owner!=me", true},
+		{"team", true},
+		{"owner", false},
+		{"!owner", true},
+		{"!env", false},
+		{"env=prod,team=qa", false},
+	}
+
+	for _, tc := range testCases {
+		selector, err := ParseLabelSelector(tc.selector)
+		if err != nil {
+			t.Errorf("ParseLabelSelector(%q) returned error: %v", tc.selector, err)
+			continue
+		}
+		if matches := selector.Matches(labels); matches != tc.matches {
+			t.Errorf("selector %q matches %v: %v, expected %v", tc.selector, labels, matches, tc.matches)
+		}
+	}
+
+	for _, invalid := range []string{"=prod", "env=prod env", "!", "env in (prod)"} {
+		if _, err := ParseLabelSelector(invalid); err == nil {
+			t.Errorf("ParseLabelSelector(%q) should return error", invalid)
+		}
+	}
+}
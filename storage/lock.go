@@ -0,0 +1,152 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func (s *RBDService) lockListForImage(ctx context.Context, imageName string) ([]model.Lock, error) {
+	log := loggerFrom(ctx)
+	log.Debug("lockListForImage: getting locks for image", imageName)
+	out := []model.Lock{}
+	output, err := s.execute(ctx, rbdPath, s.inPool("lock", "list", imageName)...)
+	if err != nil {
+		log.Errorf("lockListForImage: FAILED: %v", err)
+		return out, err
+	}
+	log.Debug("lockListForImage: rbd output: ", string(output))
+	lockLines := filterNonemptyLines(output)
+
+	for i, nonemptyLockLine := range lockLines {
+		if i < 2 {
+			continue // skip header and 'There is 1 exclusive lock on this image.' line
+		}
+
+		fields := strings.Fields(nonemptyLockLine)
+		if len(fields) < 3 {
+			continue
+		}
+
+		lock := model.Lock{LockName: fields[1], ImageName: imageName, Locker: fields[0], Address: fields[2]}
+		/*
+		   There is 1 exclusive lock on this image.
+		   Locker      ID                                                         Address
+		   client.4239 kubelet_lock_magic_compute-worker-1.instance.cluster.local 10.0.2.190:0/3340152652
+		*/
+
+		out = append(out, lock)
+	}
+	log.Info("locks: ", out)
+	return out, nil
+}
+
+// ListLocks returns locks of all images in the pool
+func (s *RBDService) ListLocks(ctx context.Context) ([]model.Lock, error) {
+	log := loggerFrom(ctx)
+	log.Debug("allLocks")
+	locks := []model.Lock{}
+	images, err := s.ListImages(ctx)
+	log.Info("allLocks: images", images)
+	if err != nil {
+		return locks, err
+	}
+	for _, image := range images {
+		log.Info("allLocks: getting locks for image", image)
+		imageLocks, err := s.lockListForImage(ctx, image)
+		if err != nil {
+			return locks, err
+		}
+		locks = append(locks, imageLocks...)
+	}
+	return locks, nil
+}
+
+// RemoveLock breaks lock of the image
+func (s *RBDService) RemoveLock(ctx context.Context, lock model.Lock) error {
+	log := loggerFrom(ctx)
+	log.Info("removeLock:", lock)
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("lock", "remove", lock.ImageName, lock.LockName, lock.Locker)...)
+	if err != nil {
+		log.Error("removeLock: FAILED:", err, string(output))
+		return err
+	}
+	log.Info("removeLock: SUCCESS.")
+	return nil
+}
+
+// ImageWatchers returns clients which have the image open, ErrNotFound is returned if it does not exist
+func (s *RBDService) ImageWatchers(ctx context.Context, imageName string) ([]model.Watcher, error) {
+	watchers := []model.Watcher{}
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("status", imageName, "--format", "json")...)
+	if err != nil {
+		if rbdNotFound(output) {
+			return watchers, ErrNotFound
+		}
+		return watchers, err
+	}
+	status := struct {
+		Watchers []struct {
+			Address string `json:"address"`
+			Client  uint64 `json:"client"`
+			Cookie  uint64 `json:"cookie"`
+		} `json:"watchers"`
+	}{}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &status); err != nil {
+		return watchers, fmt.Errorf("cannot parse rbd status output %q: %v", output, err)
+	}
+	for _, watcher := range status.Watchers {
+		watchers = append(watchers, model.Watcher{Client: fmt.Sprintf("client.%d", watcher.Client), Address: watcher.Address, Cookie: watcher.Cookie})
+	}
+	return watchers, nil
+}
+
+// CheckNotInUse returns *BusyError if the image is open by any client or locked, ErrNotFound is returned
+// if it does not exist
+func (s *RBDService) CheckNotInUse(ctx context.Context, imageName string) error {
+	watchers, err := s.ImageWatchers(ctx, imageName)
+	if err != nil {
+		return err
+	}
+	locks, err := s.lockListForImage(ctx, imageName)
+	if err != nil {
+		return err
+	}
+	if len(watchers) > 0 || len(locks) > 0 {
+		return &BusyError{ImageName: imageName, Watchers: watchers, Locks: locks}
+	}
+	return nil
+}
+
+// BreakLocks removes all locks of the image and returns them
+func (s *RBDService) BreakLocks(ctx context.Context, imageName string) ([]model.Lock, error) {
+	locks, err := s.lockListForImage(ctx, imageName)
+	if err != nil {
+		return []model.Lock{}, err
+	}
+	for _, lock := range locks {
+		if err = s.RemoveLock(ctx, lock); err != nil {
+			return []model.Lock{}, fmt.Errorf("cannot break lock %s of %s: %v", lock.LockName, lock.Locker, err)
+		}
+	}
+	return locks, nil
+}
@@ -0,0 +1,67 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+type showmappedEntry struct {
+	ID     string `json:"id"`
+	Pool   string `json:"pool"`
+	Name   string `json:"name"`
+	Snap   string `json:"snap"`
+	Device string `json:"device"`
+}
+
+// parseShowmapped accepts both list (newer Ceph releases) and object keyed by id (older releases) output formats
+func parseShowmapped(output string) ([]model.MappedDevice, error) {
+	devices := []model.MappedDevice{}
+	entries := []showmappedEntry{}
+	if err := json.Unmarshal([]byte(output), &entries); err != nil {
+		entriesByID := map[string]showmappedEntry{}
+		if err := json.Unmarshal([]byte(output), &entriesByID); err != nil {
+			return devices, fmt.Errorf("cannot parse rbd showmapped output: %v", err)
+		}
+		for _, entry := range entriesByID {
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range entries {
+		devices = append(devices, model.MappedDevice{ImageName: entry.Name, Pool: entry.Pool, Device: entry.Device})
+	}
+	return devices, nil
+}
+
+// ListMappedDevices returns RBD devices mapped on the host
+func (s *RBDService) ListMappedDevices(ctx context.Context) ([]model.MappedDevice, error) {
+	output, err := s.execute(ctx, rbdPath, "showmapped", "--format", "json")
+	if err != nil {
+		return []model.MappedDevice{}, err
+	}
+	return parseShowmapped(output)
+}
+
+// UnmapDevice unmaps RBD device
+func (s *RBDService) UnmapDevice(ctx context.Context, device string) error {
+	_, err := s.execute(ctx, rbdPath, "unmap", device)
+	return err
+}
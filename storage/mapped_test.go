@@ -0,0 +1,51 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func TestParseShowmapped(t *testing.T) {
+	Convey("Testing parseShowmapped", t, func() {
+		expected := []model.MappedDevice{{ImageName: "image1", Pool: "rbd", Device: "/dev/rbd0"}}
+
+		Convey("When output is a list", func() {
+			devices, err := parseShowmapped(`[{"id":"0","pool":"rbd","namespace":"","name":"image1","snap":"-","device":"/dev/rbd0"}]`)
+
+			So(err, ShouldBeNil)
+			So(devices, ShouldResemble, expected)
+		})
+
+		Convey("When output is an object keyed by id", func() {
+			devices, err := parseShowmapped(`{"0":{"pool":"rbd","name":"image1","snap":"-","device":"/dev/rbd0"}}`)
+
+			So(err, ShouldBeNil)
+			So(devices, ShouldResemble, expected)
+		})
+
+		Convey("When output is malformed", func() {
+			_, err := parseShowmapped("warning: no json")
+
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,159 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+var (
+	mkfsLabelPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+	mkfsUUIDPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// fileSystemSpec is allow-list of mkfs options of file system; zero limits mean the option is not supported
+type fileSystemSpec struct {
+	maxLabelLength int
+	uuid           bool
+	minInodeSize   uint64
+	maxInodeSize   uint64
+	// extOptions allows reserved blocks percentage and disabling lazy initialization
+	extOptions bool
+	arguments  func(options model.MkfsOptions) []string
+}
+
+var extSpec = fileSystemSpec{
+	maxLabelLength: 16,
+	uuid:           true,
+	minInodeSize:   128,
+	maxInodeSize:   4096,
+	extOptions:     true,
+	arguments: func(options model.MkfsOptions) []string {
+		args := []string{}
+		if options.Label != "" {
+			args = append(args, "-L", options.Label)
+		}
+		if options.UUID != "" {
+			args = append(args, "-U", options.UUID)
+		}
+		if options.InodeSize != 0 {
+			args = append(args, "-I", strconv.FormatUint(options.InodeSize, 10))
+		}
+		if options.ReservedBlocksPercentage != nil {
+			args = append(args, "-m", strconv.FormatUint(*options.ReservedBlocksPercentage, 10))
+		}
+		if options.DisableLazyInit {
+			args = append(args, "-E", "lazy_itable_init=0,lazy_journal_init=0")
+		}
+		return args
+	},
+}
+
+var fileSystems = map[string]fileSystemSpec{
+	model.EXT4: extSpec,
+	model.EXT3: extSpec,
+	model.XFS: {
+		maxLabelLength: 12,
+		uuid:           true,
+		minInodeSize:   256,
+		maxInodeSize:   2048,
+		arguments: func(options model.MkfsOptions) []string {
+			args := []string{}
+			if options.Label != "" {
+				args = append(args, "-L", options.Label)
+			}
+			if options.UUID != "" {
+				args = append(args, "-m", "uuid="+options.UUID)
+			}
+			if options.InodeSize != 0 {
+				args = append(args, "-i", "size="+strconv.FormatUint(options.InodeSize, 10))
+			}
+			return args
+		},
+	},
+	model.BTRFS: {
+		maxLabelLength: 255,
+		uuid:           true,
+		arguments: func(options model.MkfsOptions) []string {
+			args := []string{}
+			if options.Label != "" {
+				args = append(args, "-L", options.Label)
+			}
+			if options.UUID != "" {
+				args = append(args, "-U", options.UUID)
+			}
+			return args
+		},
+	},
+}
+
+// ValidateMkfsOptions checks that options are supported by file system and have safe values
+func ValidateMkfsOptions(fs string, options *model.MkfsOptions) error {
+	if options == nil {
+		return nil
+	}
+	spec, ok := fileSystems[fs]
+	if !ok {
+		return fmt.Errorf("file system %q is not allowed", fs)
+	}
+
+	if options.Label != "" {
+		if len(options.Label) > spec.maxLabelLength {
+			return fmt.Errorf("%s label can have at most %d characters", fs, spec.maxLabelLength)
+		}
+		if !mkfsLabelPattern.MatchString(options.Label) {
+			return fmt.Errorf("invalid label %q: only letters, digits, '.', '_' and '-' are allowed", options.Label)
+		}
+	}
+	if options.UUID != "" && !mkfsUUIDPattern.MatchString(options.UUID) {
+		return fmt.Errorf("invalid UUID %q", options.UUID)
+	}
+	if options.InodeSize != 0 {
+		if spec.maxInodeSize == 0 {
+			return fmt.Errorf("inode size is not supported by %s", fs)
+		}
+		size := options.InodeSize
+		if size < spec.minInodeSize || size > spec.maxInodeSize || size&(size-1) != 0 {
+			return fmt.Errorf("%s inode size has to be a power of 2 between %d and %d", fs, spec.minInodeSize, spec.maxInodeSize)
+		}
+	}
+	if options.ReservedBlocksPercentage != nil {
+		if !spec.extOptions {
+			return fmt.Errorf("reserved blocks percentage is not supported by %s", fs)
+		}
+		if *options.ReservedBlocksPercentage > 50 {
+			return fmt.Errorf("reserved blocks percentage cannot exceed 50")
+		}
+	}
+	if options.DisableLazyInit && !spec.extOptions {
+		return fmt.Errorf("disabling lazy initialization is not supported by %s", fs)
+	}
+	return nil
+}
+
+// mkfsArguments returns mkfs arguments preceding the device; options have to be validated
+func mkfsArguments(fs string, options *model.MkfsOptions) []string {
+	spec, ok := fileSystems[fs]
+	if !ok || options == nil {
+		return []string{}
+	}
+	return spec.arguments(*options)
+}
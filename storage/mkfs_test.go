@@ -0,0 +1,86 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func percentage(value uint64) *uint64 {
+	return &value
+}
+
+func TestValidateMkfsOptions(t *testing.T) {
+	const uuid = "3f1b2c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+	testCases := []struct {
+		fs      string
+		options *model.MkfsOptions
+		isError bool
+	}{
+		{model.EXT4, nil, false},
+		{model.EXT4, &model.MkfsOptions{Label: "data", UUID: uuid, InodeSize: 256, ReservedBlocksPercentage: percentage(0), DisableLazyInit: true}, false},
+		{model.EXT3, &model.MkfsOptions{Label: "legacy", ReservedBlocksPercentage: percentage(5)}, false},
+		{model.XFS, &model.MkfsOptions{Label: "xfs_data", UUID: uuid, InodeSize: 512}, false},
+		{model.BTRFS, &model.MkfsOptions{Label: "a-long-btrfs-label", UUID: uuid}, false},
+		{model.EXT4, &model.MkfsOptions{Label: "label-longer-than-16"}, true},
+		{model.XFS, &model.MkfsOptions{Label: "thirteen-char"}, true},
+		{model.EXT4, &model.MkfsOptions{Label: "-O bad"}, true},
+		{model.EXT4, &model.MkfsOptions{Label: "a;b"}, true},
+		{model.EXT4, &model.MkfsOptions{UUID: "not-a-uuid"}, true},
+		{model.EXT4, &model.MkfsOptions{InodeSize: 300}, true},
+		{model.EXT4, &model.MkfsOptions{InodeSize: 64}, true},
+		{model.XFS, &model.MkfsOptions{InodeSize: 128}, true},
+		{model.BTRFS, &model.MkfsOptions{InodeSize: 256}, true},
+		{model.EXT4, &model.MkfsOptions{ReservedBlocksPercentage: percentage(51)}, true},
+		{model.XFS, &model.MkfsOptions{ReservedBlocksPercentage: percentage(1)}, true},
+		{model.BTRFS, &model.MkfsOptions{DisableLazyInit: true}, true},
+		{"wrongFS", &model.MkfsOptions{}, true},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateMkfsOptions(tc.fs, tc.options)
+		if (err == nil && tc.isError) || (err != nil && !tc.isError) {
+			t.Errorf("ValidateMkfsOptions(%s, %+v) returned error: %v; error expected: %v", tc.fs, tc.options, err, tc.isError)
+		}
+	}
+}
+
+func TestMkfsArguments(t *testing.T) {
+	const uuid = "3f1b2c4d-5e6f-4a7b-8c9d-0e1f2a3b4c5d"
+	testCases := []struct {
+		fs      string
+		options *model.MkfsOptions
+		args    []string
+	}{
+		{model.EXT4, nil, []string{}},
+		{model.EXT4, &model.MkfsOptions{Label: "data", UUID: uuid, InodeSize: 256, ReservedBlocksPercentage: percentage(0), DisableLazyInit: true},
+			[]string{"-L", "data", "-U", uuid, "-I", "256", "-m", "0", "-E", "lazy_itable_init=0,lazy_journal_init=0"}},
+		{model.XFS, &model.MkfsOptions{Label: "data", UUID: uuid, InodeSize: 512},
+			[]string{"-L", "data", "-m", "uuid=" + uuid, "-i", "size=512"}},
+		{model.BTRFS, &model.MkfsOptions{Label: "data", UUID: uuid}, []string{"-L", "data", "-U", uuid}},
+	}
+
+	for _, tc := range testCases {
+		args := mkfsArguments(tc.fs, tc.options)
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("mkfsArguments(%s, %+v) = %v; want %v", tc.fs, tc.options, args, tc.args)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+// ValidateSize checks size of RBD image
+func ValidateSize(size uint64) error {
+	if size == 0 {
+		return errors.New("rbd size cannot be null")
+	}
+	return nil
+}
+
+// ValidateImageName checks name of RBD image
+func ValidateImageName(name string) error {
+	if len(name) == 0 {
+		return errors.New("rbd image name is empty")
+	}
+	return nil
+}
+
+// ValidateFileSystem checks if RBD image can be formatted with file system
+func ValidateFileSystem(input string) error {
+	if _, ok := fileSystems[input]; ok {
+		return nil
+	}
+	return fmt.Errorf("file system %q is not allowed", input)
+}
+
+// ValidateRBD checks RBD image to be created
+func ValidateRBD(rbd model.RBD) error {
+	if err := ValidateSize(rbd.Size); err != nil {
+		return err
+	}
+	if err := ValidateFileSystem(rbd.FileSystem); err != nil {
+		return err
+	}
+	if err := ValidateMkfsOptions(rbd.FileSystem, rbd.MkfsOptions); err != nil {
+		return err
+	}
+	if err := ValidateInitialContent(rbd.InitialContent); err != nil {
+		return err
+	}
+	if err := ValidateLabels(rbd.Labels); err != nil {
+		return err
+	}
+	return ValidateImageName(rbd.ImageName)
+}
+
+func rbdNotFound(message string) bool {
+	const notFound = "NO SUCH FILE"
+	return strings.Contains(strings.ToUpper(message), notFound)
+}
+
+func (s *RBDService) rbdCreate(ctx context.Context, name string, size uint64) error {
+	_, err := s.execute(ctx, rbdPath, s.inPool("create", name, fmt.Sprintf("--size=%d", size), "--image-feature=layering")...)
+	return err
+}
+
+func (s *RBDService) rbdMap(ctx context.Context, name string) (string, error) {
+	out, err := s.execute(ctx, rbdPath, s.inPool("map", name)...)
+	if err != nil {
+		return "", err
+	}
+	device := strings.TrimSpace(string(out))
+	s.mappedImage(name, device)
+	return device, nil
+}
+
+// UnmapImage unmaps image mapped on the host
+func (s *RBDService) UnmapImage(ctx context.Context, name string) error {
+	if _, err := s.execute(ctx, rbdPath, s.inPool("unmap", name)...); err != nil {
+		return err
+	}
+	s.unmappedImage(name)
+	return nil
+}
+
+// DeleteImage removes image, ErrNotFound is returned if it does not exist
+func (s *RBDService) DeleteImage(ctx context.Context, name string) error {
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("remove", name)...); err != nil {
+		if rbdNotFound(string(output)) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ImageInfo describes RBD image, size is given in bytes
+type ImageInfo struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	// ID names objects of format 2 image, e.g. rbd_data.<id>.*
+	ID string `json:"id"`
+}
+
+// ImageInfo returns details of the image, ErrNotFound is returned if it does not exist
+func (s *RBDService) ImageInfo(ctx context.Context, name string) (ImageInfo, error) {
+	info := ImageInfo{}
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("info", name, "--format", "json")...)
+	if err != nil {
+		if rbdNotFound(output) {
+			return info, ErrNotFound
+		}
+		return info, err
+	}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &info); err != nil {
+		return info, fmt.Errorf("cannot parse rbd info output %q: %v", output, err)
+	}
+	return info, nil
+}
+
+// ResizeImage changes size of the image to size MB, growing is subject to capacity check
+func (s *RBDService) ResizeImage(ctx context.Context, name string, size uint64) error {
+	if s.OvercommitRatio > 0 {
+		info, err := s.ImageInfo(ctx, name)
+		if err != nil {
+			return err
+		}
+		if size*mebibyte > info.Size {
+			if err = s.checkCapacity(ctx, size*mebibyte-info.Size); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := s.execute(ctx, rbdPath, s.inPool("resize", name, fmt.Sprintf("--size=%d", size))...)
+	return err
+}
+
+// ListImages returns names of images in the pool
+func (s *RBDService) ListImages(ctx context.Context) ([]string, error) {
+	log := loggerFrom(ctx)
+	log.Debug("listImages")
+	output, err := s.execute(ctx, rbdPath, s.inPool("list")...)
+	if err != nil {
+		log.Errorf("listImages: FAILED: %v", err)
+		return []string{}, err
+	}
+	log.Debug("listImages: rbd output: ", string(output))
+	imageLines := filterNonemptyLines(output)
+	return imageLines, nil
+}
+
+// ListImagesInfo returns details of all images in the pool, snapshots are skipped
+func (s *RBDService) ListImagesInfo(ctx context.Context) ([]ImageInfo, error) {
+	output, err := s.execute(ctx, rbdPath, s.inPool("list", "--long", "--format", "json")...)
+	if err != nil {
+		return []ImageInfo{}, err
+	}
+	entries := []struct {
+		Image    string `json:"image"`
+		Snapshot string `json:"snapshot"`
+		Size     uint64 `json:"size"`
+	}{}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &entries); err != nil {
+		return []ImageInfo{}, fmt.Errorf("cannot parse rbd list output %q: %v", output, err)
+	}
+	images := []ImageInfo{}
+	for _, entry := range entries {
+		if entry.Snapshot != "" {
+			continue
+		}
+		images = append(images, ImageInfo{Name: entry.Image, Size: entry.Size})
+	}
+	return images, nil
+}
+
+func (s *RBDService) formatDevice(ctx context.Context, device string, fs string, options *model.MkfsOptions) error {
+	args := append(mkfsArguments(fs, options), device)
+	_, err := s.execute(ctx, "/sbin/mkfs."+fs, args...)
+	return err
+}
+
+// CreateImage creates image with labels and formats it with file system, if given, and unpacks initial content to it.
+// ErrUnknownSeed is returned before the image is created if seed archive does not exist.
+func (s *RBDService) CreateImage(ctx context.Context, input model.RBD) (model.RBD, error) {
+	seedArchive := ""
+	if input.InitialContent != nil && input.InitialContent.Seed != "" {
+		var err error
+		if seedArchive, err = s.seedArchive(input.InitialContent.Seed); err != nil {
+			return model.RBD{}, err
+		}
+	}
+	if err := s.checkCapacity(ctx, input.Size*mebibyte); err != nil {
+		return model.RBD{}, err
+	}
+	if err := s.rbdCreate(ctx, input.ImageName, input.Size); err != nil {
+		return model.RBD{}, fmt.Errorf("cannot create RBD image with name %q and size %d: %v", input.ImageName, input.Size, err)
+	}
+	if input.Owner != "" {
+		if err := s.SetImageOwner(ctx, input.ImageName, input.Owner); err != nil {
+			return model.RBD{}, err
+		}
+	}
+	for _, key := range sortedKeys(input.Labels) {
+		if err := s.setLabel(ctx, input.ImageName, key, input.Labels[key]); err != nil {
+			return model.RBD{}, err
+		}
+	}
+	if input.FileSystem == "" {
+		return input, nil
+	}
+
+	defer s.releaseImage(input.ImageName)
+	device, err := s.rbdMap(ctx, input.ImageName)
+	if err != nil {
+		return model.RBD{}, fmt.Errorf("cannot map RBD image %q: %v", input.ImageName, err)
+	}
+	if err = s.formatDevice(ctx, device, input.FileSystem, input.MkfsOptions); err != nil {
+		return model.RBD{}, fmt.Errorf("cannot format device %q: %v", device, err)
+	}
+	if _, err = s.execute(ctx, rbdPath, s.inPool("image-meta", "set", input.ImageName, fileSystemMetaKey, input.FileSystem)...); err != nil {
+		return model.RBD{}, fmt.Errorf("cannot record file system of RBD image %q: %v", input.ImageName, err)
+	}
+	if input.InitialContent != nil {
+		if err = s.populate(ctx, device, input.InitialContent, seedArchive); err != nil {
+			return model.RBD{}, fmt.Errorf("cannot populate device %q: %v", device, err)
+		}
+		content := *input.InitialContent
+		content.Archive = nil
+		input.InitialContent = &content
+	}
+	if err = s.UnmapImage(ctx, input.ImageName); err != nil {
+		return model.RBD{}, fmt.Errorf("cannot unmap RBD image %q: %v", input.ImageName, err)
+	}
+
+	return input, nil
+}
@@ -0,0 +1,77 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"testing"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+func TestValidateRBD(t *testing.T) {
+	testCases := []struct {
+		rbd     model.RBD
+		isError bool
+	}{
+		{model.RBD{ImageName: "", Size: 100, FileSystem: model.XFS}, true},
+		{model.RBD{ImageName: "", Size: 0, FileSystem: model.XFS}, true},
+		{model.RBD{ImageName: "someimage", Size: 0, FileSystem: model.EXT4}, true},
+		{model.RBD{ImageName: "someimage", Size: 200, FileSystem: "wrongFS"}, true},
+		{model.RBD{ImageName: "some image", Size: 100, FileSystem: model.EXT4}, false},
+		{model.RBD{ImageName: "some image", Size: 1024 * 1024, FileSystem: model.XFS}, false},
+		{model.RBD{ImageName: "some image_123", Size: 1024 * 1024 * 1000 * 9, FileSystem: model.XFS}, false},
+		{model.RBD{ImageName: "legacy", Size: 100, FileSystem: model.EXT3}, false},
+		{model.RBD{ImageName: "legacy", Size: 100, FileSystem: model.BTRFS}, false},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, MkfsOptions: &model.MkfsOptions{DisableLazyInit: true}}, true},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, InitialContent: &model.InitialContent{Seed: "skeleton-1.0", Mode: "0755"}}, false},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, InitialContent: &model.InitialContent{Seed: "skeleton", Archive: []byte{1}}}, true},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, InitialContent: &model.InitialContent{Seed: "../skeleton"}}, true},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, InitialContent: &model.InitialContent{Mode: "0999"}}, true},
+		{model.RBD{ImageName: "someimage", Size: 100, FileSystem: model.XFS, InitialContent: &model.InitialContent{Mode: "17777"}}, true},
+	}
+
+	for _, tc := range testCases {
+		err := ValidateRBD(tc.rbd)
+		if (err == nil && tc.isError) || (err != nil && !tc.isError) {
+			t.Errorf("ValidateRBD(%v) returned error: %v; error expected: %v", tc.rbd, err != nil, tc.isError)
+		}
+	}
+}
+
+func TestRbdNotFound(t *testing.T) {
+	testCases := []struct {
+		message string
+		output  bool
+	}{
+		{"rbd: delete error: (2) No such file or directory", true},
+		{"No such file or directory", true},
+		{"No such file", true},
+		{"no such file", true},
+		{"NO SUCH FILE", true},
+		{"there is such file", false},
+		{"OK", false},
+		{"file removed", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		output := rbdNotFound(tc.message)
+		if output != tc.output {
+			t.Errorf("rbdNotFound(%s) = %v; want %v", tc.message, output, tc.output)
+		}
+	}
+}
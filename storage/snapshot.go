@@ -0,0 +1,68 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SnapshotSeparator separates image and snapshot name in rbd snapshot specification
+const SnapshotSeparator = "@"
+
+// SnapshotInfo describes RBD image snapshot, size is given in bytes
+type SnapshotInfo struct {
+	ID        uint64 `json:"id"`
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ListSnapshots returns snapshots of the image, ErrNotFound is returned if image does not exist
+func (s *RBDService) ListSnapshots(ctx context.Context, name string) ([]SnapshotInfo, error) {
+	snapshots := []SnapshotInfo{}
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("snap", "ls", name, "--format", "json")...)
+	if err != nil {
+		if rbdNotFound(output) {
+			return snapshots, ErrNotFound
+		}
+		return snapshots, err
+	}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &snapshots); err != nil {
+		return snapshots, fmt.Errorf("cannot parse rbd snap ls output %q: %v", output, err)
+	}
+	return snapshots, nil
+}
+
+// CreateSnapshot creates snapshot of the image
+func (s *RBDService) CreateSnapshot(ctx context.Context, name, snapshot string) error {
+	_, err := s.execute(ctx, rbdPath, s.inPool("snap", "create", name+SnapshotSeparator+snapshot)...)
+	return err
+}
+
+// RemoveSnapshot removes snapshot of the image, ErrNotFound is returned if it does not exist
+func (s *RBDService) RemoveSnapshot(ctx context.Context, name, snapshot string) error {
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("snap", "rm", name+SnapshotSeparator+snapshot)...); err != nil {
+		if rbdNotFound(output) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
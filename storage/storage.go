@@ -0,0 +1,226 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package storage manages Ceph RBD images, their locks, snapshots and mappings by executing rbd and ceph commands.
+// It is used by HTTP API of the broker and can be reused by other transports and command line tools.
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+	commonLogger "github.com/trustedanalytics-ng/tap-go-common/logger"
+	"github.com/trustedanalytics-ng/tap-go-common/os"
+)
+
+const (
+	rbdPath  = "/usr/bin/rbd"
+	cephPath = "/usr/bin/ceph"
+)
+
+var logger, _ = commonLogger.InitLogger("storage")
+
+// SetLoggerLevel changes log level of storage package
+func SetLoggerLevel(level string) error {
+	return commonLogger.SetLoggerLevel(logger, level)
+}
+
+// ErrNotFound is returned when image, snapshot or Ceph user does not exist
+var ErrNotFound = errors.New("not found")
+
+// Service manages RBD images. Context passed to its methods carries logger (see WithLogger); commands which were
+// started are not interrupted when it is cancelled, so that images are never left half-prepared.
+type Service interface {
+	// CreateImage creates image with rbd.Labels and rbd.Owner, maps it, formats it with rbd.FileSystem, which is kept
+	// in image-meta, unpacks rbd.InitialContent and unmaps it.
+	// Image is left unformatted if file system is empty. *CapacityError is returned if pool capacity would be exceeded.
+	CreateImage(ctx context.Context, rbd model.RBD) (model.RBD, error)
+	// DeleteImage removes image immediately, without moving it to trash
+	DeleteImage(ctx context.Context, imageName string) error
+	// TrashImage moves image to trash, it can be purged when deferment period ends
+	TrashImage(ctx context.Context, imageName string, deferment time.Duration) error
+	ListTrash(ctx context.Context) ([]model.TrashEntry, error)
+	// RestoreImage moves image with id from trash back to the pool
+	RestoreImage(ctx context.Context, id string) error
+	// PurgeImage removes image with id from trash, also before deferment period ends if force is set
+	PurgeImage(ctx context.Context, id string, force bool) error
+	// PurgeTrash removes images whose deferment period has ended from trash and returns them
+	PurgeTrash(ctx context.Context) ([]model.TrashEntry, error)
+	ImageInfo(ctx context.Context, imageName string) (ImageInfo, error)
+	// ResizeImage changes size of the image to size MB. *CapacityError is returned if pool capacity would be exceeded.
+	ResizeImage(ctx context.Context, imageName string, size uint64) error
+	ListImages(ctx context.Context) ([]string, error)
+	ListImagesInfo(ctx context.Context) ([]ImageInfo, error)
+	// ImageLabels returns labels of the image, stored as image-meta
+	ImageLabels(ctx context.Context, imageName string) (map[string]string, error)
+	// UpdateImageLabels sets labels given with values and removes ones given with nil, resulting labels are returned
+	UpdateImageLabels(ctx context.Context, imageName string, patch map[string]*string) (map[string]string, error)
+	// ImageMetadata returns labels, owner and file system of the image, stored as image-meta
+	ImageMetadata(ctx context.Context, imageName string) (ImageMetadata, error)
+	SetImageOwner(ctx context.Context, imageName, owner string) error
+	// RenameImage renames image within the pool, ErrImageExists is returned if there is image with the new name
+	RenameImage(ctx context.Context, imageName, newName string) error
+	// CopyImage copies image to destName in pool, or in the pool of the service if pool is empty, with snapshots
+	// if deep is set. *CapacityError is returned if capacity of the pool of the service would be exceeded.
+	CopyImage(ctx context.Context, imageName, pool, destName string, deep bool) error
+	// DiskUsage returns usage of the image, or of all images in the pool if imageName is empty
+	DiskUsage(ctx context.Context, imageName string) (model.UsageReport, error)
+	UnmapImage(ctx context.Context, imageName string) error
+
+	ListLocks(ctx context.Context) ([]model.Lock, error)
+	RemoveLock(ctx context.Context, lock model.Lock) error
+	// CheckNotInUse returns *BusyError if the image has watchers, e.g. is mapped on a node, or is locked
+	CheckNotInUse(ctx context.Context, imageName string) error
+	// BreakLocks removes all locks of the image and returns them
+	BreakLocks(ctx context.Context, imageName string) ([]model.Lock, error)
+
+	// CheckFileSystem checks or repairs file system of image which is not locked
+	CheckFileSystem(ctx context.Context, imageName, fs string, repair bool) (model.FsckResult, error)
+
+	ListSnapshots(ctx context.Context, imageName string) ([]SnapshotInfo, error)
+	CreateSnapshot(ctx context.Context, imageName, snapshot string) error
+	RemoveSnapshot(ctx context.Context, imageName, snapshot string) error
+
+	// ListMappedDevices returns all RBD devices mapped on the host, including ones not mapped by the broker
+	ListMappedDevices(ctx context.Context) ([]model.MappedDevice, error)
+	UnmapDevice(ctx context.Context, device string) error
+
+	// CreateCephUser creates Ceph user allowed to use only the image, or returns key of existing one
+	CreateCephUser(ctx context.Context, user, imageName string) (string, error)
+	DeleteCephUser(ctx context.Context, user string) error
+}
+
+// MappingTracker is notified about images mapped and unmapped by the service, so that they can be unmapped
+// if an operation is interrupted
+type MappingTracker interface {
+	MappedImage(imageName, device string)
+	UnmappedImage(imageName string)
+	// ReleaseImage is called when operation which mapped the image finished
+	ReleaseImage(imageName string)
+}
+
+// Logger is used to log operations of a single request
+type Logger interface {
+	Debug(args ...interface{})
+	Debugf(format string, args ...interface{})
+	Info(args ...interface{})
+	Infof(format string, args ...interface{})
+	Error(args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+type loggerKey struct{}
+
+// WithLogger returns context making the service log with l
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+func loggerFrom(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return logger
+}
+
+var _ Service = &RBDService{}
+
+// RBDService implements Service with rbd and ceph command line tools
+type RBDService struct {
+	os      os.OS
+	tracker MappingTracker
+
+	// SeedCatalog is directory of archives <seed>.tar, <seed>.tar.gz or <seed>.tgz used as initial content of images
+	SeedCatalog string
+	// Pool of images, passed to every rbd command; rbd default pool is used if it is empty.
+	// It is checked for capacity before images are created or grown.
+	Pool string
+	// OvercommitRatio limits space provisioned in the pool to its capacity multiplied by the ratio;
+	// capacity is not checked if it is not positive
+	OvercommitRatio float64
+}
+
+// New returns Service executing commands with os. Tracker is optional.
+func New(os os.OS, tracker MappingTracker) *RBDService {
+	return &RBDService{os: os, tracker: tracker}
+}
+
+// inPool adds Pool to arguments of rbd command
+func (s *RBDService) inPool(arg ...string) []string {
+	if s.Pool == "" {
+		return arg
+	}
+	return append(arg, "--pool", s.Pool)
+}
+
+func (s *RBDService) execute(ctx context.Context, name string, arg ...string) (string, error) {
+	log := loggerFrom(ctx)
+	log.Debugf("executing: %s %s", name, strings.Join(arg, " "))
+	output, err := s.os.ExecuteCommand(name, arg...)
+	if err != nil {
+		log.Errorf("command %s %s FAILED: %v", name, strings.Join(arg, " "), err)
+	}
+	return output, err
+}
+
+func (s *RBDService) executeCombinedOutput(ctx context.Context, name string, arg ...string) (string, error) {
+	log := loggerFrom(ctx)
+	log.Debugf("executing: %s %s", name, strings.Join(arg, " "))
+	output, err := s.os.ExecuteCommandCombinedOutput(name, arg...)
+	if err != nil {
+		log.Errorf("command %s %s FAILED: %v: %s", name, strings.Join(arg, " "), err, output)
+	}
+	return output, err
+}
+
+func (s *RBDService) mappedImage(imageName, device string) {
+	if s.tracker != nil {
+		s.tracker.MappedImage(imageName, device)
+	}
+}
+
+func (s *RBDService) unmappedImage(imageName string) {
+	if s.tracker != nil {
+		s.tracker.UnmappedImage(imageName)
+	}
+}
+
+func (s *RBDService) releaseImage(imageName string) {
+	if s.tracker != nil {
+		s.tracker.ReleaseImage(imageName)
+	}
+}
+
+// filterNonemptyLines drops empty lines and Ceph warnings from command output
+func filterNonemptyLines(input string) []string {
+	lines := strings.Split(string(input), "\n")
+	nonemptyLines := []string{}
+	for _, l := range lines {
+		// FIXME - workaround for Ceph warnings due to invalid ceph.conf
+		if strings.HasPrefix(l, "warning:") {
+			logger.Info("Skipping line:", l)
+			continue
+		}
+
+		if len(strings.TrimSpace(l)) > 0 {
+			nonemptyLines = append(nonemptyLines, l)
+		}
+	}
+	return nonemptyLines
+}
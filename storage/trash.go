@@ -0,0 +1,163 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+const (
+	rbdTimestampLayout = "Mon Jan _2 15:04:05 2006"
+	// rbdExpiresAtLayout is accepted by --expires-at option of rbd trash mv, in UTC
+	rbdExpiresAtLayout = "2006-01-02 15:04:05"
+
+	trashStatusExpired   = "expired at "
+	trashStatusProtected = "protected until "
+)
+
+var (
+	// ErrDefermentNotExpired is returned when trashed image is purged before its deferment period ends
+	ErrDefermentNotExpired = errors.New("deferment period of trashed image has not expired")
+	// ErrImageExists is returned when trashed image is restored with name of existing image
+	ErrImageExists = errors.New("image already exists")
+
+	trashIDPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+)
+
+// ValidateTrashID checks id of trashed image
+func ValidateTrashID(id string) error {
+	if !trashIDPattern.MatchString(id) {
+		return fmt.Errorf("trashed image id %q is invalid", id)
+	}
+	return nil
+}
+
+// TrashImage moves image to trash, from which it can be restored; it can be purged when deferment period ends.
+// ErrNotFound is returned if it does not exist.
+func (s *RBDService) TrashImage(ctx context.Context, name string, deferment time.Duration) error {
+	expiresAt := time.Now().Add(deferment).UTC().Format(rbdExpiresAtLayout)
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("trash", "mv", name, "--expires-at", expiresAt)...); err != nil {
+		if rbdNotFound(output) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// ListTrash returns images in trash
+func (s *RBDService) ListTrash(ctx context.Context) ([]model.TrashEntry, error) {
+	output, err := s.execute(ctx, rbdPath, s.inPool("trash", "ls", "--long", "--format", "json")...)
+	if err != nil {
+		return []model.TrashEntry{}, err
+	}
+	entries := []struct {
+		ID        string `json:"id"`
+		Name      string `json:"name"`
+		DeletedAt string `json:"deleted_at"`
+		Status    string `json:"status"`
+	}{}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &entries); err != nil {
+		return []model.TrashEntry{}, fmt.Errorf("cannot parse rbd trash ls output %q: %v", output, err)
+	}
+
+	result := []model.TrashEntry{}
+	for _, entry := range entries {
+		trashed := model.TrashEntry{ID: entry.ID, ImageName: entry.Name}
+		trashed.DeletedAt, _ = time.ParseInLocation(rbdTimestampLayout, entry.DeletedAt, time.Local)
+		deferredUntil := ""
+		switch {
+		case strings.HasPrefix(entry.Status, trashStatusExpired):
+			trashed.Expired = true
+			deferredUntil = strings.TrimPrefix(entry.Status, trashStatusExpired)
+		case strings.HasPrefix(entry.Status, trashStatusProtected):
+			deferredUntil = strings.TrimPrefix(entry.Status, trashStatusProtected)
+		}
+		trashed.DeferredUntil, _ = time.ParseInLocation(rbdTimestampLayout, deferredUntil, time.Local)
+		result = append(result, trashed)
+	}
+	return result, nil
+}
+
+// RestoreImage moves image with id from trash back to the pool. ErrNotFound is returned if it is not in trash
+// and ErrImageExists if image with its name was created in the meantime.
+func (s *RBDService) RestoreImage(ctx context.Context, id string) error {
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool("trash", "restore", id)...); err != nil {
+		if rbdNotFound(output) {
+			return ErrNotFound
+		}
+		if strings.Contains(strings.ToUpper(output), "FILE EXISTS") {
+			return ErrImageExists
+		}
+		return err
+	}
+	return nil
+}
+
+// PurgeImage removes image with id from trash. ErrDefermentNotExpired is returned if its deferment period
+// has not ended, unless force is set, and ErrNotFound if it is not in trash.
+func (s *RBDService) PurgeImage(ctx context.Context, id string, force bool) error {
+	args := []string{"trash", "rm", id}
+	if force {
+		args = append(args, "--force")
+	}
+	if output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool(args...)...); err != nil {
+		if rbdNotFound(output) {
+			return ErrNotFound
+		}
+		if strings.Contains(strings.ToUpper(output), "DEFERMENT TIME HAS NOT EXPIRED") {
+			return ErrDefermentNotExpired
+		}
+		return err
+	}
+	return nil
+}
+
+// PurgeTrash removes all images from trash whose deferment period has ended and returns them
+func (s *RBDService) PurgeTrash(ctx context.Context) ([]model.TrashEntry, error) {
+	before, err := s.ListTrash(ctx)
+	if err != nil {
+		return []model.TrashEntry{}, err
+	}
+	if _, err = s.execute(ctx, rbdPath, s.inPool("trash", "purge")...); err != nil {
+		return []model.TrashEntry{}, err
+	}
+	after, err := s.ListTrash(ctx)
+	if err != nil {
+		return []model.TrashEntry{}, err
+	}
+
+	remaining := map[string]bool{}
+	for _, entry := range after {
+		remaining[entry.ID] = true
+	}
+	purged := []model.TrashEntry{}
+	for _, entry := range before {
+		if !remaining[entry.ID] {
+			purged = append(purged, entry)
+		}
+	}
+	return purged, nil
+}
@@ -0,0 +1,83 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/model"
+)
+
+type diskUsageOutput struct {
+	Images []struct {
+		Name            string `json:"name"`
+		Snapshot        string `json:"snapshot"`
+		ProvisionedSize uint64 `json:"provisioned_size"`
+		UsedSize        uint64 `json:"used_size"`
+	} `json:"images"`
+	TotalProvisionedSize uint64 `json:"total_provisioned_size"`
+	TotalUsedSize        uint64 `json:"total_used_size"`
+}
+
+// DiskUsage returns usage of the image, or of all images in the pool if imageName is empty.
+// ErrNotFound is returned if the image does not exist.
+func (s *RBDService) DiskUsage(ctx context.Context, imageName string) (model.UsageReport, error) {
+	report := model.UsageReport{Images: []model.ImageUsage{}}
+	args := []string{"du", "--format", "json"}
+	if imageName != "" {
+		args = []string{"du", imageName, "--format", "json"}
+	}
+	output, err := s.executeCombinedOutput(ctx, rbdPath, s.inPool(args...)...)
+	if err != nil {
+		if rbdNotFound(output) {
+			return report, ErrNotFound
+		}
+		return report, err
+	}
+
+	usage := diskUsageOutput{}
+	if err = json.Unmarshal([]byte(strings.Join(filterNonemptyLines(output), "\n")), &usage); err != nil {
+		return report, fmt.Errorf("cannot parse rbd du output %q: %v", output, err)
+	}
+	report.TotalProvisionedBytes = usage.TotalProvisionedSize
+	report.TotalUsedBytes = usage.TotalUsedSize
+
+	// snapshots are listed before their image
+	indexes := map[string]int{}
+	for _, entry := range usage.Images {
+		index, ok := indexes[entry.Name]
+		if !ok {
+			index = len(report.Images)
+			indexes[entry.Name] = index
+			report.Images = append(report.Images, model.ImageUsage{ImageName: entry.Name, Snapshots: []model.SnapshotUsage{}})
+		}
+		image := &report.Images[index]
+		if entry.Snapshot != "" {
+			image.Snapshots = append(image.Snapshots, model.SnapshotUsage{Name: entry.Snapshot, ProvisionedBytes: entry.ProvisionedSize, UsedBytes: entry.UsedSize})
+			continue
+		}
+		image.ProvisionedBytes = entry.ProvisionedSize
+		image.UsedBytes = entry.UsedSize
+		if entry.ProvisionedSize > 0 {
+			image.UsedPercentage = 100 * float64(entry.UsedSize) / float64(entry.ProvisionedSize)
+		}
+	}
+	return report, nil
+}
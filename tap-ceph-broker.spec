@@ -18,8 +18,10 @@ Source0:	tap-ceph-broker
 
 %install
 install -D -p -m 755 %{SOURCE0}/application/tap-ceph-broker %{buildroot}%{_bindir}/tap-ceph-broker
+install -D -p -m 755 %{SOURCE0}/application/tap-ceph-broker-cli %{buildroot}%{_bindir}/tap-ceph-broker-cli
 install -D -p -m 644 %{SOURCE0}/tap-ceph-broker.service %{buildroot}%{_unitdir}/tap-ceph-broker.service
 install -D -p -m 644 %{SOURCE0}/tap-ceph-broker.conf %{buildroot}%{_sysconfdir}/sysconfig/tap-ceph-broker
+install -d -m 700 %{buildroot}%{_sharedstatedir}/tap-ceph-broker
 
 %post
 %systemd_post tap-ceph-broker.service
@@ -29,8 +31,10 @@ install -D -p -m 644 %{SOURCE0}/tap-ceph-broker.conf %{buildroot}%{_sysconfdir}/
 
 %files
 %{_bindir}/tap-ceph-broker
+%{_bindir}/tap-ceph-broker-cli
 %{_unitdir}/tap-ceph-broker.service
 %config(noreplace) %{_sysconfdir}/sysconfig/tap-ceph-broker
+%dir %{_sharedstatedir}/tap-ceph-broker
 
 %changelog
 * Mon Oct 10 2016 - Mariusz Klonowski <mariusz.klonowski@intel.com>
@@ -0,0 +1,169 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+)
+
+// certReloader serves server certificate, reloading it when certificate or key file changes or on SIGHUP
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns most recent modification time of certificate and key files
+func (r *certReloader) latestModTime() (time.Time, error) {
+	latest := time.Time{}
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load certificate %q and key %q: %v", r.certFile, r.keyFile, err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = &certificate
+	r.modTime = modTime
+	return nil
+}
+
+// reloadIfModified reloads certificate if any of the files changed since last load.
+// Previous certificate is kept if new one cannot be loaded, e.g. when only one of the files was replaced yet.
+func (r *certReloader) reloadIfModified() {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		logger.Errorf("Cannot check server certificate files: %v", err)
+		return
+	}
+	r.mutex.RLock()
+	modified := modTime.After(r.modTime)
+	r.mutex.RUnlock()
+	if !modified {
+		return
+	}
+	if err = r.reload(); err != nil {
+		logger.Errorf("Cannot reload server certificate, previous one is still used: %v", err)
+		return
+	}
+	logger.Info("Server certificate reloaded from", r.certFile)
+}
+
+// watch checks certificate files every interval and reloads certificate unconditionally on SIGHUP
+func (r *certReloader) watch(interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.reloadIfModified()
+			case <-hangups:
+				if err := r.reload(); err != nil {
+					logger.Errorf("Cannot reload server certificate on SIGHUP, previous one is still used: %v", err)
+					continue
+				}
+				logger.Info("Server certificate reloaded on SIGHUP from", r.certFile)
+			}
+		}
+	}()
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+// getTLSConfig prepares TLS settings with reloadable server certificate. Client certificates are verified
+// against CA bundle according to configured client auth policy.
+func getTLSConfig(cfg config.Config, reloader *certReloader) (*tls.Config, error) {
+	minVersion, err := cfg.TLSVersion()
+	if err != nil {
+		return nil, err
+	}
+	cipherSuites, err := cfg.CipherSuiteIDs()
+	if err != nil {
+		return nil, err
+	}
+	clientAuth, err := cfg.ClientAuthType()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		ClientAuth:     clientAuth,
+	}
+	if cfg.SSLClientCALocation == "" {
+		return tlsConfig, nil
+	}
+
+	caPem, err := ioutil.ReadFile(cfg.SSLClientCALocation)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no certificates found in %q", cfg.SSLClientCALocation)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	logger.Info("Client certificate authentication enabled with CA bundle:", cfg.SSLClientCALocation)
+	return tlsConfig, nil
+}
@@ -0,0 +1,105 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func writeSelfSignedCertificate(certFile, keyFile, commonName string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	So(err, ShouldBeNil)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+
+	So(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600), ShouldBeNil)
+	So(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), ShouldBeNil)
+	So(os.Chtimes(certFile, modTime, modTime), ShouldBeNil)
+	So(os.Chtimes(keyFile, modTime, modTime), ShouldBeNil)
+}
+
+func servedCommonName(reloader *certReloader) string {
+	certificate, err := reloader.getCertificate(nil)
+	So(err, ShouldBeNil)
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	So(err, ShouldBeNil)
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	Convey("Testing certReloader", t, func() {
+		dir, err := ioutil.TempDir("", "cert")
+		So(err, ShouldBeNil)
+		certFile := filepath.Join(dir, "cert.pem")
+		keyFile := filepath.Join(dir, "key.pem")
+		loadTime := time.Now().Add(-time.Minute)
+		writeSelfSignedCertificate(certFile, keyFile, "old", loadTime)
+
+		reloader, err := newCertReloader(certFile, keyFile)
+		So(err, ShouldBeNil)
+		So(servedCommonName(reloader), ShouldEqual, "old")
+
+		Convey("When files are replaced new certificate is served", func() {
+			writeSelfSignedCertificate(certFile, keyFile, "new", time.Now())
+
+			reloader.reloadIfModified()
+
+			So(servedCommonName(reloader), ShouldEqual, "new")
+		})
+
+		Convey("When files are not modified certificate is not reloaded", func() {
+			writeSelfSignedCertificate(certFile, keyFile, "new", loadTime)
+
+			reloader.reloadIfModified()
+
+			So(servedCommonName(reloader), ShouldEqual, "old")
+		})
+
+		Convey("When new key does not match certificate previous certificate is kept", func() {
+			So(ioutil.WriteFile(keyFile, []byte("broken"), 0600), ShouldBeNil)
+
+			reloader.reloadIfModified()
+
+			So(servedCommonName(reloader), ShouldEqual, "old")
+		})
+
+		Reset(func() {
+			os.RemoveAll(dir)
+		})
+	})
+}
@@ -0,0 +1,104 @@
+/**
+ * Copyright (c) 2017 Intel Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/gocraft/web"
+	"google.golang.org/grpc"
+
+	"github.com/trustedanalytics-ng/tap-ceph-broker/api"
+	"github.com/trustedanalytics-ng/tap-ceph-broker/config"
+)
+
+// listenUnix creates Unix socket at path with configured permissions, replacing stale socket left by previous process
+func listenUnix(path string, cfg config.Config) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.UnixSocketGroup != "" {
+		group, err := user.LookupGroup(cfg.UnixSocketGroup)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err = os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	if err = os.Chmod(path, cfg.UnixSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// startUnixServer serves router on Unix socket in background. Process exits if server cannot be started.
+func startUnixServer(router *web.Router, cfg config.Config) *http.Server {
+	listener, err := listenUnix(cfg.UnixSocket, cfg)
+	if err != nil {
+		logger.Fatalf("Cannot listen on Unix socket %q: %v", cfg.UnixSocket, err)
+	}
+
+	server := &http.Server{
+		Handler:     router,
+		ConnContext: api.UnixConnContext,
+	}
+	logger.Infof("Will listen on Unix socket: %s", cfg.UnixSocket)
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			logger.Fatal("Couldn't serve app on ", cfg.UnixSocket, " Error:", err)
+		}
+	}()
+	return server
+}
+
+// startCSIServer serves CSI gRPC services on Unix socket in background. Process exits if server cannot be started.
+func startCSIServer(brokerContext *api.Context, cfg config.Config) *grpc.Server {
+	listener, err := listenUnix(cfg.CSISocket, cfg)
+	if err != nil {
+		logger.Fatalf("Cannot listen on CSI socket %q: %v", cfg.CSISocket, err)
+	}
+
+	server := api.NewCSIServer(brokerContext)
+	logger.Infof("CSI will listen on Unix socket: %s", cfg.CSISocket)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Fatal("Couldn't serve CSI on ", cfg.CSISocket, " Error:", err)
+		}
+	}()
+	return server
+}